@@ -2,6 +2,8 @@ package main
 
 import (
     "context"
+    "crypto/ed25519"
+    "errors"
     "flag"
     "os"
     "os/signal"
@@ -9,9 +11,14 @@ import (
 
     "github.com/zmlAEQ/Aequa-network/internal/api"
     "github.com/zmlAEQ/Aequa-network/internal/consensus"
+    "github.com/zmlAEQ/Aequa-network/internal/consensus/payload"
+    qbft "github.com/zmlAEQ/Aequa-network/internal/consensus/qbft"
     "github.com/zmlAEQ/Aequa-network/internal/monitoring"
     "github.com/zmlAEQ/Aequa-network/internal/p2p"
+    "github.com/zmlAEQ/Aequa-network/internal/slashing"
+    "github.com/zmlAEQ/Aequa-network/internal/state"
     "github.com/zmlAEQ/Aequa-network/pkg/bus"
+    "github.com/zmlAEQ/Aequa-network/pkg/config"
     "github.com/zmlAEQ/Aequa-network/pkg/lifecycle"
     "github.com/zmlAEQ/Aequa-network/pkg/logger"
     "github.com/zmlAEQ/Aequa-network/pkg/trace"
@@ -19,32 +26,156 @@ import (
 
 func main() {
     var (
-        apiAddr  string
-        monAddr  string
-        upstream string
+        apiAddr   string
+        monAddr   string
+        upstream  string
+        lockPath  string
+        nodeID    string
+        keyPath   string
+        evPath    string
+        statePath string
+        retain    uint64
+        rateFrom  float64
+        rateType  float64
+        walDir    string
+        walSync   string
+        slashPath string
+        genesis   string
+        slashIn   string
+        slashOut  string
+        recPath   string
+        p2pAddr   string
+        peerList  string
     )
     flag.StringVar(&apiAddr, "validator-api", "127.0.0.1:4600", "Validator API listen address")
     flag.StringVar(&monAddr, "monitoring", "127.0.0.1:4620", "Monitoring listen address")
     flag.StringVar(&upstream, "upstream", "", "Optional upstream base URL for proxying non-critical requests")
+    flag.StringVar(&lockPath, "cluster-lock", "", "Optional cluster-lock.json defining the operator set")
+    flag.StringVar(&nodeID, "node-id", "", "Operator peer id of this node in the cluster lock")
+    flag.StringVar(&keyPath, "node-key", "", "File with the hex ed25519 key signing consensus messages and peer requests (required with --cluster-lock)")
+    flag.StringVar(&evPath, "evidence-file", "", "Optional file persisting equivocation evidence (in-memory if empty)")
+    flag.StringVar(&statePath, "state-file", "", "Optional file persisting consensus state and the anti-replay window (in-memory if empty)")
+    flag.Uint64Var(&retain, "replay-retention", 0, "Heights of anti-replay history to keep and persist (0 = default)")
+    flag.Float64Var(&rateFrom, "rate-limit", qbft.DefaultRateLimits().PerSender.PerSecond, "Consensus messages per second accepted from one peer (0 disables)")
+    flag.Float64Var(&rateType, "rate-limit-type", qbft.DefaultRateLimits().PerSenderType.PerSecond, "Consensus messages per second accepted from one peer per message type (0 disables)")
+    flag.StringVar(&walDir, "wal-dir", "", "Optional directory for the write-ahead log of verified consensus messages")
+    flag.StringVar(&walSync, "wal-sync", "always", "WAL fsync policy: always, batch or never")
+    flag.StringVar(&slashPath, "slashing-db", "", "Slashing-protection database file for --slashing-import/--slashing-export only: the node signs no validator duties and does not consult it at runtime")
+    flag.StringVar(&genesis, "genesis-validators-root", "", "Genesis validators root the slashing-protection database is bound to")
+    flag.StringVar(&slashIn, "slashing-import", "", "Import an EIP-3076 interchange file into --slashing-db and exit")
+    flag.StringVar(&slashOut, "slashing-export", "", "Export --slashing-db as an EIP-3076 interchange file and exit")
+    flag.StringVar(&recPath, "record", "", "Optional file recording every inbound consensus message for qbft-replay")
+    flag.StringVar(&p2pAddr, "p2p-listen", "127.0.0.1:4610", "Listen address for consensus messages from peers")
+    flag.StringVar(&peerList, "peers", "", "Comma-separated id=url peers consensus messages are sent to, e.g. b=http://10.0.0.2:4610 (requires --cluster-lock; requests are signed with the operator keys)")
     flag.Parse()
 
+    if slashIn != "" || slashOut != "" {
+        if err := slashingInterchange(slashPath, genesis, slashIn, slashOut); err != nil { logger.Error("slashing protection: " + err.Error()); os.Exit(1) }
+        return
+    }
+    if slashPath != "" { logger.Error("slashing protection: --slashing-db is only used with --slashing-import or --slashing-export"); os.Exit(1) }
+
     ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
     defer cancel()
 
     b := bus.New(256)
     publish := func(ctx context.Context, payload []byte) error {
         tid, _ := trace.FromContext(ctx)
-        b.Publish(ctx, bus.Event{Kind: bus.KindDuty, Body: payload, TraceID: tid})
+        d, err := api.ParseDuty(payload)
+        if err != nil { return err }
+        b.Publish(ctx, bus.Event{Kind: bus.KindDuty, Height: d.Height, Round: d.Round, Body: d, TraceID: tid})
         return nil
     }
 
     m := lifecycle.New()
-    m.Add(api.New(apiAddr, publish, upstream))
+    apiSvc := api.New(apiAddr, publish, upstream)
+    m.Add(apiSvc)
     m.Add(monitoring.New(monAddr))
     m.Add(p2p.New())
-    m.Add(consensus.NewWithSub(b.Subscribe()))
+    cons := consensus.NewWithSub(b.Subscribe())
+    var evStore state.EvidenceStore = state.NewMemoryEvidenceStore()
+    if evPath != "" {
+        fs, err := state.NewFileEvidenceStore(evPath)
+        if err != nil { logger.Error("evidence store: " + err.Error()); os.Exit(1) }
+        evStore = fs
+    }
+    cons.SetEvidenceStore(evStore)
+    if statePath != "" {
+        st := state.NewFileStore(statePath)
+        defer st.Close()
+        cons.SetStore(st)
+    }
+    cons.SetReplayRetention(retain)
+    cons.SetRateLimits(qbft.NewRateLimits(rateFrom, rateType))
+    if walDir != "" {
+        policy, err := state.ParseWALSync(walSync)
+        if err != nil { logger.Error("wal: " + err.Error()); os.Exit(1) }
+        wal, err := state.OpenWAL(walDir, state.WALOptions{Sync: policy})
+        if err != nil { logger.Error("wal: " + err.Error()); os.Exit(1) }
+        defer wal.Close()
+        cons.SetWAL(wal)
+    }
+    if recPath != "" {
+        rec, err := consensus.CreateRecording(recPath)
+        if err != nil { logger.Error("record: " + err.Error()); os.Exit(1) }
+        defer rec.Close()
+        cons.SetRecorder(rec)
+    }
+    cons.SetPayloadManager(payload.NewJSONManager(1 << 20))
+    apiSvc.SetEvidenceSource(func(ctx context.Context) (any, error) { return cons.Evidence(ctx) })
+    peers, err := p2p.ParsePeers(peerList)
+    if err != nil { logger.Error("peers: " + err.Error()); os.Exit(1) }
+    tr := p2p.NewHTTPTransport(p2p.PeerID(nodeID), p2pAddr, peers)
+    if lockPath == "" && len(peers) > 0 { logger.Error("peers: --peers requires --cluster-lock"); os.Exit(1) }
+    if lockPath != "" {
+        // An operator without its key neither proposes nor votes, and its
+        // peers could not be authenticated: refuse to start rather than idle.
+        if keyPath == "" || nodeID == "" { logger.Error("node key: --cluster-lock requires --node-key and --node-id"); os.Exit(1) }
+        lock, err := config.LoadClusterLock(lockPath)
+        if err != nil { logger.Error("cluster lock: " + err.Error()); os.Exit(1) }
+        vs, err := qbft.ValidatorsFromLock(lock)
+        if err != nil { logger.Error("cluster lock: " + err.Error()); os.Exit(1) }
+        if !vs.Contains(nodeID) { logger.Error("cluster lock: --node-id " + nodeID + " is not an operator"); os.Exit(1) }
+        key, err := qbft.LoadPrivateKey(keyPath)
+        if err != nil { logger.Error("node key: " + err.Error()); os.Exit(1) }
+        if pub, ok := vs.Key(nodeID); ok && !pub.Equal(key.Public()) { logger.Error("node key: does not match the lock key of " + nodeID); os.Exit(1) }
+        peerKeys := make(map[p2p.PeerID]ed25519.PublicKey)
+        for id, k := range vs.Keys() { peerKeys[p2p.PeerID(id)] = k }
+        cons.SetClusterLock(lock)
+        cons.SetSigner(qbft.NewKeySigner(nodeID, key))
+        tr.SetKeys(key, peerKeys)
+    } else if keyPath != "" {
+        logger.Error("node key: --node-key requires --cluster-lock"); os.Exit(1)
+    }
+    consensus.Connect(cons, tr)
+    m.Add(tr)
+    m.Add(cons)
 
     if err := m.StartAll(ctx); err != nil { logger.Error(err.Error()); os.Exit(1) }
     <-ctx.Done()
     _ = m.StopAll(context.Background())
-}
\ No newline at end of file
+}
+
+// slashingInterchange imports and/or exports the slashing-protection
+// database at path in the EIP-3076 interchange format.
+func slashingInterchange(path, genesis, in, out string) error {
+    if path == "" { return errors.New("--slashing-db is required") }
+    db, err := slashing.Open(path, genesis)
+    if err != nil { return err }
+    if in != "" {
+        f, err := os.Open(in)
+        if err != nil { return err }
+        err = db.Import(f)
+        _ = f.Close()
+        if err != nil { return err }
+    }
+    if out != "" {
+        // Checked before creating the file, so a failed export leaves no empty one.
+        if db.GenesisValidatorsRoot() == "" { return errors.New("export: " + slashing.ErrGenesisUnknown.Error() + ", set --genesis-validators-root or import first") }
+        f, err := os.OpenFile(out, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+        if err != nil { return err }
+        if err := db.Export(f); err != nil { _ = f.Close(); return err }
+        return f.Close()
+    }
+    return nil
+}
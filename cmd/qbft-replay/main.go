@@ -0,0 +1,82 @@
+// Command qbft-replay feeds a consensus recording (dvt-node --record) back
+// through the verifier and QBFT state machine, reports messages whose verdict
+// changed, and diffs the replayed qbft_state transitions against the
+// recording node's original log. It exits 1 when anything differs.
+//
+//	qbft-replay --recording node.qrec [--log node.log] [--cluster-lock lock.json] [--node-id id] [--rate-limit n --rate-limit-type n]
+package main
+
+import (
+    "bytes"
+    "errors"
+    "flag"
+    "fmt"
+    "os"
+
+    "github.com/zmlAEQ/Aequa-network/internal/consensus"
+    "github.com/zmlAEQ/Aequa-network/internal/consensus/payload"
+    qbft "github.com/zmlAEQ/Aequa-network/internal/consensus/qbft"
+    "github.com/zmlAEQ/Aequa-network/pkg/config"
+    "github.com/zmlAEQ/Aequa-network/pkg/logger"
+)
+
+func main() {
+    var (
+        recPath  string
+        logPath  string
+        lockPath string
+        rateFrom float64
+        rateType float64
+        opts     consensus.ReplayOptions
+    )
+    flag.StringVar(&recPath, "recording", "", "Recording file written by dvt-node --record")
+    flag.StringVar(&logPath, "log", "", "Optional JSON log of the recording node to diff transitions against")
+    flag.StringVar(&lockPath, "cluster-lock", "", "cluster-lock.json the node ran with")
+    flag.StringVar(&opts.Self, "node-id", "", "Operator id of the recording node")
+    flag.Uint64Var(&opts.ReplayRetain, "replay-retention", 0, "Anti-replay retention the node ran with (0 = default)")
+    flag.Float64Var(&rateFrom, "rate-limit", qbft.DefaultRateLimits().PerSender.PerSecond, "Per-peer message rate limit the node ran with (0 = disabled)")
+    flag.Float64Var(&rateType, "rate-limit-type", qbft.DefaultRateLimits().PerSenderType.PerSecond, "Per-peer, per-type message rate limit the node ran with (0 = disabled)")
+    flag.Parse()
+    limits := qbft.NewRateLimits(rateFrom, rateType)
+    opts.RateLimits = &limits
+    if recPath == "" { fail(2, errors.New("--recording is required")) }
+    recs, err := consensus.ReadRecordingFile(recPath)
+    if errors.Is(err, consensus.ErrRecordingTruncated) {
+        fmt.Fprintf(os.Stderr, "warning: %s: %v after %d records\n", recPath, err, len(recs))
+    } else if err != nil {
+        fail(2, err)
+    }
+    if lockPath != "" {
+        lock, err := config.LoadClusterLock(lockPath)
+        if err != nil { fail(2, err) }
+        opts.Lock = &lock
+    }
+    opts.Payloads = payload.NewJSONManager(1 << 20)
+
+    var logs bytes.Buffer
+    logger.SetOutput(&logs)
+    mismatches, err := consensus.Replay(recs, opts)
+    logger.SetOutput(os.Stdout)
+    if err != nil { fail(2, err) }
+    for _, m := range mismatches { fmt.Println("verdict", m) }
+
+    got, _ := consensus.ParseTransitions(&logs)
+    diff := 0
+    if logPath != "" {
+        f, err := os.Open(logPath)
+        if err != nil { fail(2, err) }
+        want, err := consensus.ParseTransitions(f)
+        _ = f.Close()
+        if err != nil { fail(2, err) }
+        d := consensus.DiffTransitions(want, got)
+        for _, line := range d { fmt.Println(line) }
+        diff = len(d)
+    }
+    fmt.Printf("replayed %d records: %d verdict mismatches, %d transitions, %d differing\n", len(recs), len(mismatches), len(got), diff)
+    if len(mismatches) > 0 || diff > 0 { os.Exit(1) }
+}
+
+func fail(code int, err error) {
+    fmt.Fprintln(os.Stderr, err)
+    os.Exit(code)
+}
@@ -0,0 +1,33 @@
+package api
+
+import (
+    "context"
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+)
+
+func TestHandleEvidence(t *testing.T) {
+    s := &Service{}
+    rr := httptest.NewRecorder()
+    s.handleEvidence(rr, httptest.NewRequest(http.MethodGet, "/v1/evidence", nil))
+    if rr.Code != http.StatusNotFound { t.Fatalf("unwired: want 404, got %d", rr.Code) }
+
+    s.SetEvidenceSource(func(context.Context) (any, error) { return []map[string]string{{"id": "e1"}}, nil })
+    rr = httptest.NewRecorder()
+    s.handleEvidence(rr, httptest.NewRequest(http.MethodGet, "/v1/evidence", nil))
+    if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"id":"e1"`) {
+        t.Fatalf("want 200 with records, got %d %q", rr.Code, rr.Body.String())
+    }
+
+    rr = httptest.NewRecorder()
+    s.handleEvidence(rr, httptest.NewRequest(http.MethodPost, "/v1/evidence", nil))
+    if rr.Code != http.StatusMethodNotAllowed { t.Fatalf("want 405, got %d", rr.Code) }
+
+    s.SetEvidenceSource(func(context.Context) (any, error) { return nil, errors.New("boom") })
+    rr = httptest.NewRecorder()
+    s.handleEvidence(rr, httptest.NewRequest(http.MethodGet, "/v1/evidence", nil))
+    if rr.Code != http.StatusInternalServerError { t.Fatalf("want 500, got %d", rr.Code) }
+}
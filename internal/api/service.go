@@ -10,13 +10,14 @@ import (
     "net/url"
     "time"
 
+    "github.com/zmlAEQ/Aequa-network/pkg/bus"
     "github.com/zmlAEQ/Aequa-network/pkg/lifecycle"
     "github.com/zmlAEQ/Aequa-network/pkg/logger"
     "github.com/zmlAEQ/Aequa-network/pkg/metrics"
     "github.com/zmlAEQ/Aequa-network/pkg/trace"
 )
 
-type Service struct{ addr string; srv *http.Server; onPublish func(ctx context.Context, payload []byte) error; upstream string }
+type Service struct{ addr string; srv *http.Server; onPublish func(ctx context.Context, payload []byte) error; upstream string; evidence func(ctx context.Context) (any, error) }
 
 func New(addr string, onPublish func(ctx context.Context, payload []byte) error, upstream string) *Service {
     return &Service{addr: addr, onPublish: onPublish, upstream: upstream}
@@ -24,11 +25,15 @@ func New(addr string, onPublish func(ctx context.Context, payload []byte) error,
 
 func (s *Service) Name() string { return "api" }
 
+// SetEvidenceSource wires the provider behind GET /v1/evidence. Must be called before Start.
+func (s *Service) SetEvidenceSource(fn func(ctx context.Context) (any, error)) { s.evidence = fn }
+
 func (s *Service) Start(ctx context.Context) error {
     begin := time.Now()
     mux := http.NewServeMux()
     mux.HandleFunc("/health", s.handleHealth)
     mux.HandleFunc("/v1/duty", s.handleDuty)
+    mux.HandleFunc("/v1/evidence", s.handleEvidence)
     mux.HandleFunc("/", s.proxy)
     s.srv = &http.Server{ Addr: s.addr, Handler: mux }
     go func() {
@@ -102,26 +107,72 @@ func (s *Service) handleDuty(w http.ResponseWriter, r *http.Request) {
     w.WriteHeader(http.StatusAccepted)
 }
 
+// handleEvidence returns the recorded misbehaviour evidence as JSON.
+func (s *Service) handleEvidence(w http.ResponseWriter, r *http.Request) {
+    start := time.Now()
+    tid := traceID(r)
+    route := "/v1/evidence"
+
+    if r.Method != http.MethodGet {
+        s.logAPI(w, route, http.StatusMethodNotAllowed, start, tid, "error", "method not allowed")
+        return
+    }
+    if s.evidence == nil {
+        s.logAPI(w, route, http.StatusNotFound, start, tid, "error", "evidence not available")
+        return
+    }
+    items, err := s.evidence(r.Context())
+    if err != nil {
+        s.logAPI(w, route, http.StatusInternalServerError, start, tid, "error", "evidence unavailable")
+        return
+    }
+    b, err := json.Marshal(items)
+    if err != nil {
+        s.logAPI(w, route, http.StatusInternalServerError, start, tid, "error", "encode error")
+        return
+    }
+    dur := time.Since(start)
+    metrics.Inc("api_requests_total", map[string]string{"route":route,"code":"200"})
+    metrics.ObserveSummary("api_latency_ms", map[string]string{"route":route}, float64(dur.Milliseconds()))
+    logger.InfoJ("api_request", map[string]any{
+        "route": route,
+        "code":  200,
+        "bytes": len(b),
+        "latency_ms": dur.Milliseconds(),
+        "result": "ok",
+        "trace_id": tid,
+    })
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusOK)
+    _, _ = w.Write(b)
+}
+
 type dutyEnvelope struct {
     Type   string `json:"type"`
     Height uint64 `json:"height"`
     Round  uint64 `json:"round"`
-    Payload any   `json:"payload"`
+    Payload json.RawMessage `json:"payload"`
 }
 
 func validateDutyJSON(b []byte) error {
+    _, err := ParseDuty(b)
+    return err
+}
+
+// ParseDuty validates a /v1/duty envelope and returns the typed duty.
+func ParseDuty(b []byte) (bus.Duty, error) {
     var d dutyEnvelope
-    if len(b) == 0 { return fmt.Errorf("empty") }
-    if len(b) > 1<<20 { return fmt.Errorf("too large") }
-    if err := json.Unmarshal(b, &d); err != nil { return fmt.Errorf("invalid json") }
+    if len(b) == 0 { return bus.Duty{}, fmt.Errorf("empty") }
+    if len(b) > 1<<20 { return bus.Duty{}, fmt.Errorf("too large") }
+    if err := json.Unmarshal(b, &d); err != nil { return bus.Duty{}, fmt.Errorf("invalid json") }
     switch d.Type {
     case "attester", "proposer", "sync":
     default:
-        return fmt.Errorf("invalid type")
+        return bus.Duty{}, fmt.Errorf("invalid type")
     }
-    if d.Height > 1<<62 { return fmt.Errorf("height out of range") }
-    if d.Round > 1<<40 { return fmt.Errorf("round out of range") }
-    return nil
+    if d.Height > 1<<62 { return bus.Duty{}, fmt.Errorf("height out of range") }
+    if d.Round > 1<<40 { return bus.Duty{}, fmt.Errorf("round out of range") }
+    return bus.Duty{Type: d.Type, Height: d.Height, Round: d.Round, Payload: []byte(d.Payload)}, nil
 }
 
 func (s *Service) proxy(w http.ResponseWriter, r *http.Request) {
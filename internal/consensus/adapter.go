@@ -1,25 +1,36 @@
 package consensus
 
 import (
-    "fmt"
-
     qbft "github.com/zmlAEQ/Aequa-network/internal/consensus/qbft"
     "github.com/zmlAEQ/Aequa-network/pkg/bus"
 )
 
-// MapEventToQBFT converts a bus.Event into a qbft.Message.
-// Stub mapping: direct field mapping with conservative defaults.
-func MapEventToQBFT(ev bus.Event) qbft.Message {
-    id := fmt.Sprintf("ev-%s-%d-%d", ev.TraceID, ev.Height, ev.Round)
-    return qbft.Message{
-        ID:      id,
-        From:    "consensus_stub",
-        Type:    qbft.MsgPrepare,
-        Height:  ev.Height,
-        Round:   ev.Round,
-        Payload: nil,
-        TraceID: ev.TraceID,
-        Sig:     nil,
+// DutyOf returns the typed duty carried by a KindDuty event.
+func DutyOf(ev bus.Event) (bus.Duty, bool) {
+    if ev.Kind != bus.KindDuty { return bus.Duty{}, false }
+    switch d := ev.Body.(type) {
+    case bus.Duty:
+        return d, true
+    case *bus.Duty:
+        if d != nil { return *d, true }
     }
+    return bus.Duty{}, false
 }
 
+// MessageOf returns the consensus message carried by a KindConsensus event.
+// Messages without a trace id inherit the event's.
+func MessageOf(ev bus.Event) (qbft.Message, bool) {
+    if ev.Kind != bus.KindConsensus { return qbft.Message{}, false }
+    var msg qbft.Message
+    switch m := ev.Body.(type) {
+    case qbft.Message:
+        msg = m
+    case *qbft.Message:
+        if m == nil { return qbft.Message{}, false }
+        msg = *m
+    default:
+        return qbft.Message{}, false
+    }
+    if msg.TraceID == "" { msg.TraceID = ev.TraceID }
+    return msg, true
+}
@@ -7,18 +7,20 @@ import (
     "github.com/zmlAEQ/Aequa-network/pkg/bus"
 )
 
-func TestMapEventToQBFT_BasicMapping(t *testing.T) {
-    ev := bus.Event{Kind: bus.KindDuty, Height: 42, Round: 3, TraceID: "tid123"}
-    msg := MapEventToQBFT(ev)
-
-    if msg.Type != qbft.MsgPrepare { t.Fatalf("type: got %q", msg.Type) }
-    if msg.From != "consensus_stub" { t.Fatalf("from: got %q", msg.From) }
-    if msg.Height != 42 || msg.Round != 3 { t.Fatalf("coords: got (%d,%d)", msg.Height, msg.Round) }
-    if msg.TraceID != "tid123" { t.Fatalf("trace: got %q", msg.TraceID) }
-    wantID := "ev-tid123-42-3"
-    if msg.ID != wantID { t.Fatalf("id: got %q want %q", msg.ID, wantID) }
-    if len(msg.Sig) != 0 { t.Fatalf("sig: expected empty") }
-    if msg.Payload != nil {
-        t.Fatalf("payload: expected nil stub")
+func TestDutyOf(t *testing.T) {
+    d := bus.Duty{Type: "attester", Height: 42, Payload: []byte(`{"slot":1}`)}
+    if got, ok := DutyOf(bus.Event{Kind: bus.KindDuty, Body: d}); !ok || got.Height != 42 || string(got.Payload) != `{"slot":1}` {
+        t.Fatalf("duty: %+v %v", got, ok)
     }
-}
\ No newline at end of file
+    if _, ok := DutyOf(bus.Event{Kind: bus.KindDuty, Body: []byte("raw")}); ok { t.Fatalf("untyped body accepted") }
+    if _, ok := DutyOf(bus.Event{Kind: bus.KindConsensus, Body: d}); ok { t.Fatalf("wrong kind accepted") }
+}
+
+func TestMessageOf(t *testing.T) {
+    m := qbft.Message{ID: "m1", From: "a", Type: qbft.MsgPrepare, Height: 42, Round: 3}
+    got, ok := MessageOf(bus.Event{Kind: bus.KindConsensus, Body: m, TraceID: "tid123"})
+    if !ok || got.ID != "m1" || got.TraceID != "tid123" { t.Fatalf("message: %+v %v", got, ok) }
+    m.TraceID = "own"
+    if got, _ := MessageOf(bus.Event{Kind: bus.KindConsensus, Body: &m, TraceID: "tid123"}); got.TraceID != "own" { t.Fatalf("trace overwritten: %q", got.TraceID) }
+    if _, ok := MessageOf(bus.Event{Kind: bus.KindDuty}); ok { t.Fatalf("duty event accepted as message") }
+}
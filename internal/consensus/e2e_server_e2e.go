@@ -4,6 +4,7 @@ package consensus
 
 import (
     "encoding/json"
+    "io"
     "net/http"
     "time"
 
@@ -13,6 +14,8 @@ import (
 
 // startE2E launches a minimal HTTP server (0.0.0.0:4610) exposing /e2e/qbft
 // to inject qbft.Message directly into verifier/state for adversarial testing.
+// Bodies are JSON, or the binary wire encoding with Content-Type
+// application/octet-stream.
 // Compiled only in builds with -tags e2e. Production builds include a no-op.
 func startE2E(s *Service) {
     mux := http.NewServeMux()
@@ -20,7 +23,15 @@ func startE2E(s *Service) {
         begin := time.Now()
         defer r.Body.Close()
         var msg qbft.Message
-        if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+        if r.Header.Get("Content-Type") == "application/octet-stream" {
+            // canonical binary encoding (qbft.Marshal)
+            b, err := io.ReadAll(io.LimitReader(r.Body, 4<<20))
+            if err == nil { msg, err = qbft.Unmarshal(b) }
+            if err != nil {
+                http.Error(w, "bad message", http.StatusBadRequest)
+                return
+            }
+        } else if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
             http.Error(w, "bad json", http.StatusBadRequest)
             return
         }
@@ -0,0 +1,245 @@
+// Package invariants checks QBFT safety and liveness invariants over the
+// qbft_state and consensus_state JSON logs of several nodes:
+//
+//   - agreement: no two nodes decide different values for one instance, and
+//     no node decides one instance twice with different values;
+//   - prepare_quorum: no node prepares (and so commits) without a quorum of
+//     prepares, and none decides a value it has not prepared;
+//   - commit_quorum: no decision rests on fewer commits than a quorum;
+//   - liveness: every instance seen in the logs is decided by enough nodes.
+//
+// Instances are identified by (duty, height). Events are attributed to the
+// node named in their "node" field, or else to the log they were read from.
+package invariants
+
+import (
+    "bufio"
+    "encoding/json"
+    "fmt"
+    "io"
+    "sort"
+    "strings"
+)
+
+// Invariant names, as reported in Violation.Invariant.
+const (
+    Agreement     = "agreement"
+    PrepareQuorum = "prepare_quorum"
+    CommitQuorum  = "commit_quorum"
+    Liveness      = "liveness"
+)
+
+// Event is one consensus log line.
+type Event struct {
+    Node      string `json:"node"`
+    Source    string `json:"-"`
+    Line      int    `json:"-"`
+    Msg       string `json:"msg"`
+    Op        string `json:"op"`
+    EventType string `json:"event_type"`
+    Duty      string `json:"duty"`
+    Height    uint64 `json:"height"`
+    Round     uint64 `json:"round"`
+    Phase     string `json:"phase"`
+    Proposal  string `json:"proposal"`
+    Prepares  int    `json:"prepares"`
+    Commits   int    `json:"commits"`
+    Quorum    int    `json:"quorum"`
+    Result    string `json:"result"`
+    Note      string `json:"note"`
+    TraceID   string `json:"trace_id"`
+}
+
+// Violation is a broken invariant for one instance.
+type Violation struct {
+    Invariant string
+    Duty      string
+    Height    uint64
+    Nodes     []string
+    TraceIDs  []string
+    Detail    string
+}
+
+func (v Violation) String() string {
+    return fmt.Sprintf("%s %s/%d nodes=%s trace_ids=%s: %s", v.Invariant, v.Duty, v.Height, strings.Join(v.Nodes, ","), strings.Join(v.TraceIDs, ","), v.Detail)
+}
+
+// Config tunes the checks. Zero values select the defaults.
+type Config struct {
+    // Nodes is the cluster size (default: the number of nodes in the logs).
+    Nodes int
+    // Quorum overrides the quorum logged with each event, and the
+    // ceil(2n/3) fallback for events without one.
+    Quorum int
+    // MinDecided is how many nodes must decide each instance (default: the
+    // quorum). Negative disables the liveness check.
+    MinDecided int
+}
+
+// Log holds the events of one or more nodes, in read order per source.
+type Log struct {
+    events []Event
+    nodes  map[string]bool
+}
+
+// NewLog returns an empty log.
+func NewLog() *Log { return &Log{nodes: map[string]bool{}} }
+
+// Read adds the qbft_state and consensus_state events of r, read from
+// source. Other lines, JSON or not, are skipped.
+func (l *Log) Read(source string, r io.Reader) error {
+    sc := bufio.NewScanner(r)
+    sc.Buffer(nil, 1<<20)
+    line := 0
+    for sc.Scan() {
+        line++
+        b := sc.Bytes()
+        if len(b) == 0 || b[0] != '{' { continue }
+        var e Event
+        if err := json.Unmarshal(b, &e); err != nil { continue }
+        if e.Msg != "qbft_state" && e.Msg != "consensus_state" { continue }
+        e.Source, e.Line = source, line
+        if e.Node == "" { e.Node = source }
+        l.nodes[e.Node] = true
+        l.events = append(l.events, e)
+    }
+    return sc.Err()
+}
+
+// Events returns the events read so far.
+func (l *Log) Events() []Event { return append([]Event(nil), l.events...) }
+
+// Nodes returns the node names seen, sorted.
+func (l *Log) Nodes() []string {
+    out := make([]string, 0, len(l.nodes))
+    for n := range l.nodes { out = append(out, n) }
+    sort.Strings(out)
+    return out
+}
+
+type instance struct {
+    duty   string
+    height uint64
+}
+
+// Check runs every invariant over l and returns the violations, ordered by
+// instance and invariant.
+func Check(l *Log, cfg Config) []Violation {
+    n := cfg.Nodes
+    if n <= 0 { n = len(l.nodes) }
+    fallback := (2*n + 2) / 3
+    if cfg.Quorum > 0 { fallback = cfg.Quorum }
+    quorumOf := func(e Event) int {
+        if cfg.Quorum > 0 || e.Quorum <= 0 { return fallback }
+        return e.Quorum
+    }
+    minDecided := cfg.MinDecided
+    if minDecided == 0 { minDecided = fallback }
+
+    seen := map[instance]bool{}
+    traces := map[instance][]string{}
+    decided := map[instance][]Event{} // first decision per node
+    decidedBy := map[instance]map[string]Event{}
+    prepared := map[instance]map[string]map[string]bool{} // proposals prepared per node
+    var out []Violation
+    for _, e := range l.events {
+        k := instance{e.Duty, e.Height}
+        if e.TraceID != "" { traces[k] = append(traces[k], e.TraceID) }
+        if e.Msg == "consensus_state" {
+            // A node restored in a prepared or committed phase prepared before the restart.
+            if e.Op == "restore" && e.Result == "ok" && (e.Phase == "prepared" || e.Phase == "commit") { mark(prepared, k, e.Node, e.Proposal) }
+            continue
+        }
+        if e.Duty == "" && e.Height == 0 { continue }
+        switch {
+        case e.Op == "transition" && e.Note == "" && e.Phase != "":
+            seen[k] = true
+            if e.EventType == "prepare" && e.Phase == "prepared" {
+                mark(prepared, k, e.Node, e.Proposal)
+                if q := quorumOf(e); e.Prepares < q {
+                    out = append(out, violation(PrepareQuorum, k, []Event{e}, fmt.Sprintf("%s prepared %s with %d of %d prepares", e.Node, short(e.Proposal), e.Prepares, q)))
+                }
+            }
+        case e.Op == "decided":
+            seen[k] = true
+            if decidedBy[k] == nil { decidedBy[k] = map[string]Event{} }
+            // Deciding again after a restart is fine as long as the value is the same.
+            if first, ok := decidedBy[k][e.Node]; ok {
+                if first.Proposal != e.Proposal {
+                    out = append(out, violation(Agreement, k, []Event{first, e}, fmt.Sprintf("%s decided %s, then %s", e.Node, short(first.Proposal), short(e.Proposal))))
+                }
+                continue
+            }
+            decidedBy[k][e.Node] = e
+            decided[k] = append(decided[k], e)
+            if !prepared[k][e.Node][e.Proposal] {
+                out = append(out, violation(PrepareQuorum, k, []Event{e}, fmt.Sprintf("%s decided %s without a prepare quorum", e.Node, short(e.Proposal))))
+            }
+            if q := quorumOf(e); e.Commits < q {
+                out = append(out, violation(CommitQuorum, k, []Event{e}, fmt.Sprintf("%s decided %s with %d of %d commits", e.Node, short(e.Proposal), e.Commits, q)))
+            }
+        }
+    }
+    for k, ds := range decided {
+        values := map[string][]Event{}
+        for _, d := range ds { values[d.Proposal] = append(values[d.Proposal], d) }
+        if len(values) < 2 { continue }
+        var parts []string
+        for p, es := range values {
+            var ns []string
+            for _, e := range es { ns = append(ns, e.Node) }
+            sort.Strings(ns)
+            parts = append(parts, fmt.Sprintf("%s by %s", short(p), strings.Join(ns, ",")))
+        }
+        sort.Strings(parts)
+        out = append(out, violation(Agreement, k, ds, "conflicting decisions: "+strings.Join(parts, "; ")))
+    }
+    if minDecided > 0 {
+        for k := range seen {
+            if got := len(decided[k]); got < minDecided {
+                v := violation(Liveness, k, decided[k], fmt.Sprintf("decided by %d nodes, want %d", got, minDecided))
+                v.TraceIDs = uniq(traces[k])
+                out = append(out, v)
+            }
+        }
+    }
+    sort.SliceStable(out, func(i, j int) bool {
+        a, b := out[i], out[j]
+        if a.Duty != b.Duty { return a.Duty < b.Duty }
+        if a.Height != b.Height { return a.Height < b.Height }
+        return a.Invariant < b.Invariant
+    })
+    return out
+}
+
+func mark(m map[instance]map[string]map[string]bool, k instance, node, proposal string) {
+    if m[k] == nil { m[k] = map[string]map[string]bool{} }
+    if m[k][node] == nil { m[k][node] = map[string]bool{} }
+    m[k][node][proposal] = true
+}
+
+func violation(inv string, k instance, es []Event, detail string) Violation {
+    v := Violation{Invariant: inv, Duty: k.duty, Height: k.height, Detail: detail}
+    var nodes, traces []string
+    for _, e := range es {
+        nodes = append(nodes, e.Node)
+        if e.TraceID != "" { traces = append(traces, e.TraceID) }
+    }
+    v.Nodes, v.TraceIDs = uniq(nodes), uniq(traces)
+    return v
+}
+
+// uniq returns the distinct values of ss, sorted.
+func uniq(ss []string) []string {
+    set := map[string]bool{}
+    for _, s := range ss { set[s] = true }
+    out := make([]string, 0, len(set))
+    for s := range set { out = append(out, s) }
+    sort.Strings(out)
+    return out
+}
+
+func short(id string) string {
+    if len(id) > 12 { return id[:12] }
+    return id
+}
@@ -0,0 +1,103 @@
+package invariants
+
+import (
+    "fmt"
+    "strings"
+    "testing"
+)
+
+func prepared(node string, h uint64, prop string, n int) string {
+    return fmt.Sprintf(`{"msg":"qbft_state","op":"transition","event_type":"prepare","node":%q,"duty":"attester","height":%d,"round":1,"phase":"prepared","proposal":%q,"prepares":%d,"quorum":3,"trace_id":"t%d"}`, node, h, prop, n, h)
+}
+
+func decided(node string, h uint64, prop string, n int) string {
+    return fmt.Sprintf(`{"msg":"qbft_state","op":"decided","node":%q,"duty":"attester","height":%d,"round":1,"proposal":%q,"commits":%d,"quorum":3,"trace_id":"t%d"}`, node, h, prop, n, h)
+}
+
+func logOf(t *testing.T, lines ...string) *Log {
+    t.Helper()
+    l := NewLog()
+    if err := l.Read("all", strings.NewReader(strings.Join(lines, "\n"))); err != nil { t.Fatal(err) }
+    return l
+}
+
+func clean(h uint64) []string {
+    var ls []string
+    for _, n := range []string{"n0", "n1", "n2", "n3"} { ls = append(ls, prepared(n, h, "aa", 3), decided(n, h, "aa", 3)) }
+    return ls
+}
+
+func only(t *testing.T, vs []Violation, inv string) Violation {
+    t.Helper()
+    if len(vs) != 1 || vs[0].Invariant != inv { t.Fatalf("want one %s violation, got %v", inv, vs) }
+    return vs[0]
+}
+
+func TestCheck_CleanRun(t *testing.T) {
+    lines := append(clean(1), "2024-01-01T00:00:00Z INFO not json", `{"msg":"api_request","height":9}`)
+    if vs := Check(logOf(t, lines...), Config{}); len(vs) != 0 { t.Fatalf("unexpected violations: %v", vs) }
+}
+
+func TestCheck_ConflictingDecisions(t *testing.T) {
+    lines := clean(1)
+    lines[6], lines[7] = prepared("n3", 1, "bb", 3), decided("n3", 1, "bb", 3)
+    v := only(t, Check(logOf(t, lines...), Config{}), Agreement)
+    if v.Height != 1 || len(v.Nodes) != 4 || v.TraceIDs[0] != "t1" { t.Fatalf("violation %+v", v) }
+    if !strings.Contains(v.Detail, "bb by n3") { t.Fatalf("detail %q", v.Detail) }
+}
+
+func TestCheck_PrepareQuorum(t *testing.T) {
+    lines := clean(1)
+    lines[0] = prepared("n0", 1, "aa", 2)
+    v := only(t, Check(logOf(t, lines...), Config{}), PrepareQuorum)
+    if v.Nodes[0] != "n0" { t.Fatalf("violation %+v", v) }
+
+    lines = clean(1)
+    lines[2] = `{"msg":"qbft_state","op":"transition","event_type":"prepare","node":"n1","duty":"attester","height":1,"phase":"preprepared","note":"noop"}`
+    v = only(t, Check(logOf(t, lines...), Config{}), PrepareQuorum)
+    if v.Nodes[0] != "n1" || !strings.Contains(v.Detail, "without a prepare quorum") { t.Fatalf("violation %+v", v) }
+}
+
+func TestCheck_RestoredPhaseCountsAsPrepared(t *testing.T) {
+    lines := clean(1)
+    lines[2] = `{"msg":"consensus_state","op":"restore","result":"ok","node":"n1","duty":"attester","height":1,"phase":"prepared","proposal":"aa"}`
+    if vs := Check(logOf(t, lines...), Config{}); len(vs) != 0 { t.Fatalf("unexpected violations: %v", vs) }
+}
+
+// Having prepared one value does not cover deciding another.
+func TestCheck_DecidedValueNotPrepared(t *testing.T) {
+    lines := clean(1)
+    lines[2] = prepared("n1", 1, "bb", 3)
+    v := only(t, Check(logOf(t, lines...), Config{}), PrepareQuorum)
+    if v.Nodes[0] != "n1" || !strings.Contains(v.Detail, "decided aa without a prepare quorum") { t.Fatalf("violation %+v", v) }
+}
+
+// A node deciding an instance a second time is fine with the same value and
+// a violation with another one.
+func TestCheck_NodeDecidesTwice(t *testing.T) {
+    if vs := Check(logOf(t, append(clean(1), decided("n2", 1, "aa", 3))...), Config{}); len(vs) != 0 { t.Fatalf("unexpected violations: %v", vs) }
+    v := only(t, Check(logOf(t, append(clean(1), prepared("n2", 1, "bb", 3), decided("n2", 1, "bb", 3))...), Config{}), Agreement)
+    if len(v.Nodes) != 1 || v.Nodes[0] != "n2" || !strings.Contains(v.Detail, "n2 decided aa, then bb") { t.Fatalf("violation %+v", v) }
+}
+
+func TestCheck_CommitQuorum(t *testing.T) {
+    lines := clean(1)
+    lines[1] = decided("n0", 1, "aa", 1)
+    only(t, Check(logOf(t, lines...), Config{}), CommitQuorum)
+    // An explicit quorum overrides the logged one.
+    if vs := Check(logOf(t, clean(1)...), Config{Quorum: 4}); len(vs) != 8 { t.Fatalf("want 8 violations with quorum 4, got %v", vs) }
+}
+
+func TestCheck_Liveness(t *testing.T) {
+    lines := append(clean(1), prepared("n0", 2, "cc", 3), decided("n0", 2, "cc", 3), prepared("n1", 2, "cc", 3))
+    v := only(t, Check(logOf(t, lines...), Config{}), Liveness)
+    if v.Height != 2 || v.TraceIDs[0] != "t2" || !strings.Contains(v.Detail, "decided by 1 nodes, want 3") { t.Fatalf("violation %+v", v) }
+    if vs := Check(logOf(t, lines...), Config{MinDecided: -1}); len(vs) != 0 { t.Fatalf("liveness not disabled: %v", vs) }
+}
+
+func TestRead_NodeFromSource(t *testing.T) {
+    l := NewLog()
+    line := strings.Replace(decided("", 1, "aa", 3), `"node":"",`, "", 1)
+    if err := l.Read("node7", strings.NewReader(line)); err != nil { t.Fatal(err) }
+    if ns := l.Nodes(); len(ns) != 1 || ns[0] != "node7" { t.Fatalf("nodes %v", ns) }
+}
@@ -17,9 +17,10 @@ type Manager interface {
     MaxSize() int
 }
 
+// Errors returned by JSONManager; callers map them to rejection reasons.
 var (
-    errTooLarge    = errors.New("payload too large")
-    errInvalidJSON = errors.New("invalid json payload")
+    ErrTooLarge    = errors.New("payload too large")
+    ErrInvalidJSON = errors.New("invalid json payload")
 )
 
 // JSONManager performs basic size and JSON structural validation and produces
@@ -38,11 +39,11 @@ func (m *JSONManager) MaxSize() int { return m.max }
 // Validate enforces size and JSON structural validity.
 func (m *JSONManager) Validate(b []byte) error {
     if m.max > 0 && len(b) > m.max {
-        return errTooLarge
+        return ErrTooLarge
     }
     var v any
     if err := json.Unmarshal(b, &v); err != nil {
-        return errInvalidJSON
+        return ErrInvalidJSON
     }
     return nil
 }
@@ -57,7 +58,7 @@ func (m *JSONManager) Canonical(b []byte) ([]byte, error) {
     _ = json.Unmarshal(b, &v) // safe after Validate
     enc, err := json.Marshal(v)
     if err != nil {
-        return nil, errInvalidJSON
+        return nil, ErrInvalidJSON
     }
     return enc, nil
 }
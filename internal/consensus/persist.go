@@ -0,0 +1,71 @@
+package consensus
+
+import (
+    qbft "github.com/zmlAEQ/Aequa-network/internal/consensus/qbft"
+    "github.com/zmlAEQ/Aequa-network/internal/state"
+)
+
+// lastStateOf builds the persisted state after msg was processed: the
+// coordinates plus, when the processor supports it, the snapshot of the
+// instance msg belongs to.
+func lastStateOf(p qbft.Processor, msg qbft.Message) state.LastState {
+    sp, ok := p.(qbft.Snapshotter)
+    if !ok { return state.LastState{Height: msg.Height, Round: msg.Round} }
+    sn, ok := sp.SnapshotFor(msg)
+    if !ok { return state.LastState{Height: msg.Height, Round: msg.Round} }
+    return state.LastState{Height: sn.Height, Round: sn.Round, Instance: toInstanceState(sn)}
+}
+
+func toInstanceState(sn qbft.Snapshot) *state.InstanceState {
+    in := &state.InstanceState{
+        Duty:          sn.Duty,
+        Phase:         sn.Phase,
+        ProposalID:    sn.ProposalID,
+        Proposal:      sn.Proposal,
+        PreparedRound: sn.PreparedRound,
+        PreparedID:    sn.PreparedID,
+        PreparedValue: sn.PreparedValue,
+    }
+    for _, m := range sn.Own {
+        if b, err := qbft.Marshal(m); err == nil { in.OwnVotes = append(in.OwnVotes, b) }
+    }
+    for _, m := range sn.PreparedCert {
+        if b, err := qbft.Marshal(m); err == nil { in.PreparedCert = append(in.PreparedCert, b) }
+    }
+    for _, m := range sn.Votes {
+        if b, err := qbft.Marshal(m); err == nil { in.Votes = append(in.Votes, b) }
+    }
+    return in
+}
+
+// snapshotOf converts a persisted instance state back into an instance snapshot.
+func snapshotOf(ls state.LastState) (qbft.Snapshot, error) {
+    in := ls.Instance
+    sn := qbft.Snapshot{
+        Duty:          in.Duty,
+        Height:        ls.Height,
+        Round:         ls.Round,
+        Phase:         in.Phase,
+        ProposalID:    in.ProposalID,
+        Proposal:      in.Proposal,
+        PreparedRound: in.PreparedRound,
+        PreparedID:    in.PreparedID,
+        PreparedValue: in.PreparedValue,
+    }
+    for _, b := range in.OwnVotes {
+        m, err := qbft.Unmarshal(b)
+        if err != nil { return qbft.Snapshot{}, err }
+        sn.Own = append(sn.Own, m)
+    }
+    for _, b := range in.PreparedCert {
+        m, err := qbft.Unmarshal(b)
+        if err != nil { return qbft.Snapshot{}, err }
+        sn.PreparedCert = append(sn.PreparedCert, m)
+    }
+    for _, b := range in.Votes {
+        m, err := qbft.Unmarshal(b)
+        if err != nil { return qbft.Snapshot{}, err }
+        sn.Votes = append(sn.Votes, m)
+    }
+    return sn, nil
+}
@@ -0,0 +1,113 @@
+package consensus
+
+import (
+    "context"
+    "fmt"
+    "path/filepath"
+    "reflect"
+    "testing"
+    "time"
+
+    qbft "github.com/zmlAEQ/Aequa-network/internal/consensus/qbft"
+    "github.com/zmlAEQ/Aequa-network/internal/state"
+    "github.com/zmlAEQ/Aequa-network/pkg/bus"
+    "github.com/zmlAEQ/Aequa-network/pkg/config"
+)
+
+// The instance snapshot survives a FileStore round trip unchanged.
+func TestLastState_InstanceRoundTrip(t *testing.T) {
+    st := &qbft.State{Self: "b", Broadcast: func(qbft.Message) {}, Leader: "a"}
+    if err := st.Process(qbft.Message{ID: "p", ProposalID: "v1", From: "a", Type: qbft.MsgPreprepare, Height: 9, Payload: []byte("v1")}); err != nil {
+        t.Fatalf("preprepare: %v", err)
+    }
+    want := st.Snapshot()
+    ls := lastStateOf(st, qbft.Message{Height: 9})
+    if ls.Instance == nil || len(ls.Instance.OwnVotes) != 1 { t.Fatalf("instance not captured: %+v", ls) }
+
+    fs := state.NewFileStore(filepath.Join(t.TempDir(), "laststate.dat"))
+    if err := fs.SaveLastState(context.Background(), ls); err != nil { t.Fatalf("save: %v", err) }
+    got, err := fs.LoadLastState(context.Background())
+    if err != nil { t.Fatalf("load: %v", err) }
+    sn, err := snapshotOf(got)
+    if err != nil { t.Fatalf("decode: %v", err) }
+    if !reflect.DeepEqual(sn, want) { t.Fatalf("snapshot differs:\n got %+v\nwant %+v", sn, want) }
+}
+
+// testLock is a four-operator cluster lock for the persistence tests.
+var testLock = config.ClusterLock{Operators: []config.Operator{{Index: 0, PeerID: "a"}, {Index: 1, PeerID: "b"}, {Index: 2, PeerID: "c"}, {Index: 3, PeerID: "d"}}}
+
+// startPersistNode starts a service over store and returns it with the leader
+// function of testLock.
+func startPersistNode(t *testing.T, ctx context.Context, store state.Store) (*Service, qbft.LeaderFunc) {
+    t.Helper()
+    s := NewWithSub(bus.New(4).Subscribe())
+    s.SetClusterLock(testLock)
+    s.SetVerifier(okVerifier{})
+    s.SetStore(store)
+    if err := s.Start(ctx); err != nil { t.Fatalf("start: %v", err) }
+    return s, qbft.LeaderFromLock(testLock)
+}
+
+// feed processes msgs through the service's instances and persists each one
+// as the event loop does.
+func feed(t *testing.T, ctx context.Context, s *Service, msgs ...qbft.Message) {
+    t.Helper()
+    for _, m := range msgs {
+        if err := s.st.Process(m); err != nil { t.Fatalf("%s %s from %s: %v", m.Duty, m.Type, m.From, err) }
+        s.saveState(ctx, m, "")
+    }
+}
+
+// Interleaved instances each keep their own snapshot; a decided instance's
+// snapshot is dropped while the other one survives a restart.
+func TestService_PersistsOneSnapshotPerInstance(t *testing.T) {
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    store := state.NewMemoryStore()
+    s, leader := startPersistNode(t, ctx, store)
+    for _, h := range []uint64{7, 8} {
+        feed(t, ctx, s, qbft.Message{ID: fmt.Sprintf("pp%d", h), ProposalID: fmt.Sprintf("v%d", h), From: leader(h, 0), Type: qbft.MsgPreprepare, Duty: "attester", Height: h})
+    }
+    if lss, _ := store.LoadInstances(ctx); len(lss) != 2 { t.Fatalf("want two snapshots, got %d", len(lss)) }
+
+    for _, typ := range []qbft.Type{qbft.MsgPrepare, qbft.MsgCommit} {
+        for _, from := range []string{"a", "b", "c"} {
+            feed(t, ctx, s, qbft.Message{ID: from + string(typ), ProposalID: "v7", From: from, Type: typ, Duty: "attester", Height: 7})
+        }
+    }
+    if lss, _ := store.LoadInstances(ctx); len(lss) != 1 || lss[0].Height != 8 { t.Fatalf("decided snapshot not dropped: %+v", lss) }
+
+    s2, _ := startPersistNode(t, ctx, store)
+    m := s2.st.(*qbft.InstanceManager)
+    if st, ok := m.Instance(qbft.InstanceKey{Duty: "attester", Height: 8}); !ok || st.Phase != "preprepared" { t.Fatalf("height 8 not restored: %v", ok) }
+    if _, ok := m.Instance(qbft.InstanceKey{Duty: "attester", Height: 7}); ok { t.Fatalf("decided height 7 restored") }
+}
+
+// Without a WAL, prepares counted before a restart still count after it:
+// the rest of the quorum decides the restored instance.
+func TestService_RestoresCountedVotesWithoutWAL(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "laststate.dat")
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    store := state.NewFileStore(path)
+    s, leader := startPersistNode(t, ctx, store)
+    feed(t, ctx, s,
+        qbft.Message{ID: "pp", ProposalID: "v7", From: leader(7, 0), Type: qbft.MsgPreprepare, Duty: "attester", Height: 7},
+        qbft.Message{ID: "pa", ProposalID: "v7", From: "a", Type: qbft.MsgPrepare, Duty: "attester", Height: 7},
+        qbft.Message{ID: "pb", ProposalID: "v7", From: "b", Type: qbft.MsgPrepare, Duty: "attester", Height: 7},
+    )
+    if lss, _ := store.LoadInstances(ctx); len(lss) != 1 || len(lss[0].Instance.Votes) != 2 { t.Fatalf("votes not persisted: %+v", lss) }
+    _ = store.Close()
+
+    s2, _ := startPersistNode(t, ctx, state.NewFileStore(path))
+    decided := s2.SubscribeDecided(1)
+    feed(t, ctx, s2, qbft.Message{ID: "pc", ProposalID: "v7", From: "c", Type: qbft.MsgPrepare, Duty: "attester", Height: 7})
+    for _, from := range []string{"a", "b", "c"} {
+        feed(t, ctx, s2, qbft.Message{ID: "c" + from, ProposalID: "v7", From: from, Type: qbft.MsgCommit, Duty: "attester", Height: 7})
+    }
+    select {
+    case <-decided:
+    case <-time.After(time.Second):
+        t.Fatalf("restored instance did not decide")
+    }
+}
@@ -0,0 +1,162 @@
+package qbft
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/binary"
+    "errors"
+)
+
+// Wire/signing encoding of Message (version 1), all integers big endian:
+//
+//   [version u8]
+//   [type str][from str][duty str][height u64][round u64][id str]
+//   [proposal_id str][payload bytes][trace_id str][sig bytes]
+//   [prepared_round u64][prepared_id str]
+//   [justification count u16] { [len u32][encoded message] }*
+//
+// str/bytes are [len u32][data]. Justification messages may not carry
+// justifications themselves: a proposal embeds roundchanges without their
+// prepared certificates and lists the prepares it relies on alongside.
+// The encoding is canonical: Unmarshal accepts exactly the bytes Marshal
+// produces.
+const codecVersion byte = 1
+
+// Decoder limits guarding against oversized or hostile input.
+const (
+    MaxFieldSize     = 1 << 20
+    MaxJustification = 256
+)
+
+var (
+    errCodecVersion  = errors.New("codec: unsupported version")
+    errCodecShort    = errors.New("codec: truncated input")
+    errCodecTrailing = errors.New("codec: trailing bytes")
+    errCodecLimit    = errors.New("codec: field exceeds limit")
+    errCodecNested   = errors.New("codec: nested justification")
+)
+
+// Marshal returns the canonical binary encoding of m.
+func Marshal(m Message) ([]byte, error) {
+    if err := checkLimits(m, true); err != nil { return nil, err }
+    return appendMessage(nil, m), nil
+}
+
+// Unmarshal decodes a message produced by Marshal.
+func Unmarshal(b []byte) (Message, error) {
+    m, rest, err := decodeMessage(b, true)
+    if err != nil { return Message{}, err }
+    if len(rest) != 0 { return Message{}, errCodecTrailing }
+    return m, nil
+}
+
+// Digest returns sha256 over the signing encoding of m: the canonical
+// encoding with Sig and all trace ids cleared. A roundchange's digest leaves
+// out its prepared certificate, whose prepares are signed on their own, so it
+// stays valid once embedded in a proposal. Signatures, content ids and replay
+// protection are keyed on it.
+func (m Message) Digest() [32]byte {
+    m.Sig = nil
+    m.TraceID = ""
+    if m.Type == MsgRoundChange { m.Justification = nil }
+    if len(m.Justification) > 0 {
+        js := make([]Message, len(m.Justification))
+        for i, j := range m.Justification { j.TraceID = ""; js[i] = j }
+        m.Justification = js
+    }
+    return sha256.Sum256(appendMessage(nil, m))
+}
+
+func checkLimits(m Message, top bool) error {
+    for _, n := range []int{len(m.Type), len(m.From), len(m.Duty), len(m.ID), len(m.ProposalID), len(m.Payload), len(m.TraceID), len(m.Sig), len(m.PreparedID)} {
+        if n > MaxFieldSize { return errCodecLimit }
+    }
+    if len(m.Justification) > MaxJustification { return errCodecLimit }
+    if !top && len(m.Justification) > 0 { return errCodecNested }
+    for _, j := range m.Justification {
+        if err := checkLimits(j, false); err != nil { return err }
+    }
+    return nil
+}
+
+func appendMessage(b []byte, m Message) []byte {
+    b = append(b, codecVersion)
+    b = appendBytes(b, []byte(m.Type))
+    b = appendBytes(b, []byte(m.From))
+    b = appendBytes(b, []byte(m.Duty))
+    b = binary.BigEndian.AppendUint64(b, m.Height)
+    b = binary.BigEndian.AppendUint64(b, m.Round)
+    b = appendBytes(b, []byte(m.ID))
+    b = appendBytes(b, []byte(m.ProposalID))
+    b = appendBytes(b, m.Payload)
+    b = appendBytes(b, []byte(m.TraceID))
+    b = appendBytes(b, m.Sig)
+    b = binary.BigEndian.AppendUint64(b, m.PreparedRound)
+    b = appendBytes(b, []byte(m.PreparedID))
+    b = binary.BigEndian.AppendUint16(b, uint16(len(m.Justification)))
+    for _, j := range m.Justification { b = appendBytes(b, appendMessage(nil, j)) }
+    return b
+}
+
+func appendBytes(b, v []byte) []byte {
+    b = binary.BigEndian.AppendUint32(b, uint32(len(v)))
+    return append(b, v...)
+}
+
+type reader struct {
+    b   []byte
+    err error
+}
+
+func (r *reader) take(n int) []byte {
+    if r.err != nil { return nil }
+    if len(r.b) < n { r.err = errCodecShort; return nil }
+    v := r.b[:n]
+    r.b = r.b[n:]
+    return v
+}
+
+func (r *reader) u16() uint16 { v := r.take(2); if v == nil { return 0 }; return binary.BigEndian.Uint16(v) }
+func (r *reader) u32() uint32 { v := r.take(4); if v == nil { return 0 }; return binary.BigEndian.Uint32(v) }
+func (r *reader) u64() uint64 { v := r.take(8); if v == nil { return 0 }; return binary.BigEndian.Uint64(v) }
+
+func (r *reader) bytes() []byte {
+    n := r.u32()
+    if r.err == nil && n > MaxFieldSize { r.err = errCodecLimit; return nil }
+    v := r.take(int(n))
+    if len(v) == 0 { return nil }
+    return bytes.Clone(v)
+}
+
+func (r *reader) str() string { return string(r.bytes()) }
+
+func decodeMessage(b []byte, top bool) (Message, []byte, error) {
+    r := &reader{b: b}
+    if v := r.take(1); r.err == nil && v[0] != codecVersion { return Message{}, nil, errCodecVersion }
+    var m Message
+    m.Type = Type(r.str())
+    m.From = r.str()
+    m.Duty = r.str()
+    m.Height = r.u64()
+    m.Round = r.u64()
+    m.ID = r.str()
+    m.ProposalID = r.str()
+    m.Payload = r.bytes()
+    m.TraceID = r.str()
+    m.Sig = r.bytes()
+    m.PreparedRound = r.u64()
+    m.PreparedID = r.str()
+    n := int(r.u16())
+    if r.err != nil { return Message{}, nil, r.err }
+    if n > MaxJustification { return Message{}, nil, errCodecLimit }
+    if n > 0 && !top { return Message{}, nil, errCodecNested }
+    for i := 0; i < n; i++ {
+        raw := r.take(int(r.u32()))
+        if r.err != nil { return Message{}, nil, r.err }
+        j, rest, err := decodeMessage(raw, false)
+        if err != nil { return Message{}, nil, err }
+        if len(rest) != 0 { return Message{}, nil, errCodecTrailing }
+        m.Justification = append(m.Justification, j)
+    }
+    return m, r.b, nil
+}
@@ -0,0 +1,69 @@
+package qbft
+
+import (
+    "bytes"
+    "reflect"
+    "testing"
+)
+
+func sampleMessage() Message {
+    rc := Message{From: "q", Type: MsgRoundChange, Height: 9, Round: 2, ID: "rc", PreparedRound: 1, PreparedID: "blk", Payload: []byte("v"), Sig: []byte{1, 2}}
+    return Message{From: "p", Duty: "attester", Height: 9, Round: 2, Type: MsgPreprepare, Payload: []byte(`{"a":1}`), ID: "blk", TraceID: "t", Sig: []byte{9}, Justification: []Message{rc}}
+}
+
+func TestCodec_RoundTrip(t *testing.T) {
+    m := sampleMessage()
+    b, err := Marshal(m)
+    if err != nil { t.Fatalf("marshal: %v", err) }
+    got, err := Unmarshal(b)
+    if err != nil { t.Fatalf("unmarshal: %v", err) }
+    if !reflect.DeepEqual(got, m) { t.Fatalf("round trip mismatch:\n got %+v\nwant %+v", got, m) }
+    b2, _ := Marshal(got)
+    if !bytes.Equal(b, b2) { t.Fatalf("encoding not deterministic") }
+}
+
+func TestCodec_RejectsMalformed(t *testing.T) {
+    b, _ := Marshal(sampleMessage())
+    if _, err := Unmarshal(b[:len(b)-1]); err == nil { t.Fatalf("want truncated error") }
+    if _, err := Unmarshal(append(append([]byte(nil), b...), 0)); err == nil { t.Fatalf("want trailing error") }
+    bad := append([]byte(nil), b...)
+    bad[0] = 2
+    if _, err := Unmarshal(bad); err == nil { t.Fatalf("want version error") }
+    nested := sampleMessage()
+    nested.Justification[0].Justification = []Message{{ID: "x"}}
+    if _, err := Marshal(nested); err == nil { t.Fatalf("want nested justification error") }
+}
+
+func TestMessage_Digest_IgnoresSigAndTrace(t *testing.T) {
+    a := sampleMessage()
+    b := sampleMessage()
+    b.Sig, b.TraceID, b.Justification[0].TraceID = []byte{7, 7}, "other", "jt"
+    if a.Digest() != b.Digest() { t.Fatalf("digest must ignore sig and trace ids") }
+    b.Round++
+    if a.Digest() == b.Digest() { t.Fatalf("digest must cover round") }
+    c := sampleMessage()
+    c.Justification[0].Sig = []byte{3}
+    if a.Digest() == c.Digest() { t.Fatalf("digest must cover justification signatures") }
+    // A roundchange signs without its certificate, so it can be embedded stripped.
+    rc := Message{ID: "rc", From: "p", Type: MsgRoundChange, Round: 2, PreparedID: "x", Justification: preparesFor("x", 0, 1, "a", "b")}
+    stripped := rc
+    stripped.Justification = nil
+    if rc.Digest() != stripped.Digest() { t.Fatalf("roundchange digest must ignore its certificate") }
+}
+
+// FuzzUnmarshal ensures the decoder never panics and that any accepted input
+// is canonical (re-encodes to the same bytes).
+func FuzzUnmarshal(f *testing.F) {
+    b, _ := Marshal(sampleMessage())
+    f.Add(b)
+    e, _ := Marshal(Message{})
+    f.Add(e)
+    f.Add([]byte{1, 0, 0, 0, 9})
+    f.Fuzz(func(t *testing.T, in []byte) {
+        m, err := Unmarshal(in)
+        if err != nil { return }
+        out, err := Marshal(m)
+        if err != nil { t.Fatalf("re-marshal accepted message: %v", err) }
+        if !bytes.Equal(in, out) { t.Fatalf("non-canonical input accepted") }
+    })
+}
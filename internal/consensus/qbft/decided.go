@@ -0,0 +1,47 @@
+package qbft
+
+import "fmt"
+
+// CommitCertificate is the set of commits that formed the decision quorum.
+type CommitCertificate struct {
+    Duty       string
+    Height     uint64
+    Round      uint64
+    ProposalID string
+    Commits    []Message
+}
+
+// Decided is emitted once an instance reaches a commit quorum.
+type Decided struct {
+    Duty        string
+    Height      uint64
+    Round       uint64
+    ProposalID  string
+    Value       []byte
+    Certificate CommitCertificate
+    TraceID     string
+}
+
+// Verify checks that the certificate holds commits for its proposal from a
+// quorum of distinct operators of vals, all at the certificate's coordinates.
+// When vals carries operator keys every commit signature is checked too.
+func (c CommitCertificate) Verify(vals Validators) error {
+    if vals.Size() == 0 { return fmt.Errorf("no validators") }
+    if c.ProposalID == "" { return fmt.Errorf("empty proposal") }
+    seen := make(map[string]struct{}, len(c.Commits))
+    for _, m := range c.Commits {
+        if m.Type != MsgCommit { return fmt.Errorf("not a commit: %s", m.Type) }
+        if m.Duty != c.Duty || m.Height != c.Height || m.Round != c.Round {
+            return fmt.Errorf("commit from %s at wrong coordinates", m.From)
+        }
+        if ProposalRef(m) != c.ProposalID { return fmt.Errorf("commit from %s for other proposal", m.From) }
+        if !vals.Contains(m.From) { return fmt.Errorf("commit from non-operator %s", m.From) }
+        if vals.HasKeys() {
+            if k, ok := vals.Key(m.From); !ok || !VerifySig(k, m) { return fmt.Errorf("commit from %s: bad signature", m.From) }
+        }
+        if _, dup := seen[m.From]; dup { return fmt.Errorf("duplicate commit from %s", m.From) }
+        seen[m.From] = struct{}{}
+    }
+    if len(seen) < vals.Quorum() { return fmt.Errorf("commits %d below quorum %d", len(seen), vals.Quorum()) }
+    return nil
+}
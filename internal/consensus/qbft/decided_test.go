@@ -0,0 +1,49 @@
+package qbft
+
+import "testing"
+
+func decideFourNodes(t *testing.T) (*State, []Decided) {
+    t.Helper()
+    var got []Decided
+    st := &State{Leader: "a", Validators: NewValidators([]string{"a", "b", "c", "d"}, 0), OnDecided: func(d Decided) { got = append(got, d) }}
+    msgs := []Message{{ID: "blk", From: "a", Type: MsgPreprepare, Height: 3, Payload: []byte("v")}}
+    for _, f := range []string{"a", "b", "c"} { msgs = append(msgs, Message{ID: "blk", From: f, Type: MsgPrepare, Height: 3}) }
+    for _, f := range []string{"a", "b", "c", "d"} { msgs = append(msgs, Message{ID: "blk", From: f, Type: MsgCommit, Height: 3}) }
+    for _, m := range msgs {
+        if err := st.Process(m); err != nil { t.Fatalf("%s from %s: %v", m.Type, m.From, err) }
+    }
+    return st, got
+}
+
+func TestState_Decided_EmittedOnceWithCertificate(t *testing.T) {
+    _, got := decideFourNodes(t)
+    if len(got) != 1 { t.Fatalf("want exactly one decision, got %d", len(got)) }
+    d := got[0]
+    if d.Height != 3 || d.Round != 0 || d.ProposalID != "blk" || string(d.Value) != "v" {
+        t.Fatalf("unexpected decision: %+v", d)
+    }
+    if n := len(d.Certificate.Commits); n != 3 { t.Fatalf("certificate should hold the quorum (3), got %d", n) }
+    if err := d.Certificate.Verify(NewValidators([]string{"a", "b", "c", "d"}, 0)); err != nil {
+        t.Fatalf("certificate verify: %v", err)
+    }
+}
+
+func TestCommitCertificate_Verify_Rejects(t *testing.T) {
+    _, got := decideFourNodes(t)
+    vals := NewValidators([]string{"a", "b", "c", "d"}, 0)
+    cert := got[0].Certificate
+
+    short := cert
+    short.Commits = cert.Commits[:2]
+    if err := short.Verify(vals); err == nil { t.Fatalf("want below-quorum error") }
+
+    dup := cert
+    dup.Commits = append(append([]Message(nil), cert.Commits[:2]...), cert.Commits[0])
+    if err := dup.Verify(vals); err == nil { t.Fatalf("want duplicate error") }
+
+    other := cert
+    other.ProposalID = "evil"
+    if err := other.Verify(vals); err == nil { t.Fatalf("want proposal mismatch error") }
+
+    if err := cert.Verify(NewValidators([]string{"x", "y", "z"}, 0)); err == nil { t.Fatalf("want non-operator error") }
+}
@@ -0,0 +1,48 @@
+package qbft
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+)
+
+// Evidence records two conflicting messages of the same type sent by one
+// operator for the same (duty, height, round). With signed messages it is
+// self-contained proof of equivocation.
+type Evidence struct {
+    Offender string
+    Duty     string
+    Height   uint64
+    Round    uint64
+    Type     Type
+    First    Message
+    Second   Message
+}
+
+// ID is a stable identifier independent of the order the pair was observed in.
+func (e Evidence) ID() string {
+    a, b := e.First.Digest(), e.Second.Digest()
+    if bytes.Compare(a[:], b[:]) > 0 { a, b = b, a }
+    h := sha256.New()
+    h.Write(a[:])
+    h.Write(b[:])
+    return hex.EncodeToString(h.Sum(nil))
+}
+
+// Verify checks that both messages come from the offender, share type and
+// coordinates, reference different proposals and, when vals carries keys,
+// are validly signed.
+func (e Evidence) Verify(vals Validators) error {
+    for _, m := range []Message{e.First, e.Second} {
+        if m.From != e.Offender { return fmt.Errorf("message from %s, not offender %s", m.From, e.Offender) }
+        if m.Type != e.Type || m.Duty != e.Duty || m.Height != e.Height || m.Round != e.Round {
+            return fmt.Errorf("message at wrong coordinates")
+        }
+        if vals.HasKeys() {
+            if k, ok := vals.Key(m.From); !ok || !VerifySig(k, m) { return fmt.Errorf("bad signature from %s", m.From) }
+        }
+    }
+    if ProposalRef(e.First) == ProposalRef(e.Second) { return fmt.Errorf("messages do not conflict") }
+    return nil
+}
@@ -0,0 +1,56 @@
+package qbft
+
+import (
+    "crypto/ed25519"
+    "strings"
+    "testing"
+
+    "github.com/zmlAEQ/Aequa-network/pkg/metrics"
+)
+
+func TestState_Equivocation_ConflictingCommitsProduceEvidence(t *testing.T) {
+    metrics.Reset()
+    var got []Evidence
+    st := &State{Leader: "a", Validators: NewValidators([]string{"a", "b", "c", "d"}, 0), OnEvidence: func(e Evidence) { got = append(got, e) }}
+    if err := st.Start(4); err != nil { t.Fatalf("start: %v", err) }
+    if err := st.Process(Message{ID: "blk", From: "a", Type: MsgPreprepare, Height: 4}); err != nil { t.Fatalf("preprepare: %v", err) }
+    if err := st.Process(Message{ProposalID: "blk", From: "b", Type: MsgPrepare, Height: 4}); err != nil { t.Fatalf("prepare: %v", err) }
+    if err := st.Process(Message{ProposalID: "blk", From: "b", Type: MsgPrepare, Height: 4}); err != nil { t.Fatalf("duplicate is not equivocation: %v", err) }
+    for i := 0; i < 2; i++ {
+        if err := st.Process(Message{ProposalID: "other", From: "b", Type: MsgPrepare, Height: 4}); err == nil || err.Error() != "equivocation" {
+            t.Fatalf("want equivocation error, got %v", err)
+        }
+    }
+    if len(got) != 1 { t.Fatalf("want evidence reported once, got %d", len(got)) }
+    ev := got[0]
+    if ev.Offender != "b" || ev.Type != MsgPrepare || ProposalRef(ev.First) != "blk" || ProposalRef(ev.Second) != "other" {
+        t.Fatalf("unexpected evidence: %+v", ev)
+    }
+    if err := ev.Verify(NewValidators([]string{"a", "b", "c", "d"}, 0)); err != nil { t.Fatalf("verify: %v", err) }
+    if swapped := (Evidence{Offender: "b", Height: 4, Round: 1, Type: MsgPrepare, First: ev.Second, Second: ev.First}); swapped.ID() != ev.ID() {
+        t.Fatalf("evidence id must not depend on order")
+    }
+    if dump := metrics.DumpProm(); !strings.Contains(dump, `qbft_equivocations_total{type="prepare"} 1`) {
+        t.Fatalf("missing equivocation metric: %q", dump)
+    }
+}
+
+func TestEvidence_Verify_Rejects(t *testing.T) {
+    pub, priv, _ := ed25519.GenerateKey(nil)
+    sg := NewKeySigner("b", priv)
+    a := sg.Sign(Message{From: "b", Type: MsgCommit, Height: 1, Round: 1, ProposalID: "x"})
+    b := sg.Sign(Message{From: "b", Type: MsgCommit, Height: 1, Round: 1, ProposalID: "y"})
+    vals := NewValidators([]string{"a", "b", "c", "d"}, 0).WithKeys(map[string]ed25519.PublicKey{"b": pub})
+    ok := Evidence{Offender: "b", Height: 1, Round: 1, Type: MsgCommit, First: a, Second: b}
+    if err := ok.Verify(vals); err != nil { t.Fatalf("valid evidence: %v", err) }
+
+    same := ok
+    same.Second = a
+    if same.Verify(vals) == nil { t.Fatalf("identical messages are not evidence") }
+    forged := ok
+    forged.Second.Sig = append([]byte(nil), a.Sig...)
+    if forged.Verify(vals) == nil { t.Fatalf("want signature error") }
+    framed := ok
+    framed.Offender = "c"
+    if framed.Verify(vals) == nil { t.Fatalf("want offender mismatch") }
+}
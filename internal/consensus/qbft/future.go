@@ -0,0 +1,75 @@
+package qbft
+
+import (
+    "github.com/zmlAEQ/Aequa-network/pkg/logger"
+    "github.com/zmlAEQ/Aequa-network/pkg/metrics"
+)
+
+// FutureLimits bounds the future-message buffer of a State. Zero values
+// select the defaults.
+type FutureLimits struct {
+    PerSender int
+    Max       int
+}
+
+const (
+    DefaultFuturePerSender = 8
+    DefaultFutureMax       = 128
+)
+
+// futureBuffer holds messages for rounds or heights the instance has not
+// reached yet (and votes that arrived ahead of their proposal) until they
+// can be replayed. Depth is reported process-wide in qbft_future_buffer_depth.
+type futureBuffer struct {
+    limits   FutureLimits
+    entries  []Message
+    bySender map[string]int
+}
+
+func newFutureBuffer(l FutureLimits) *futureBuffer {
+    if l.PerSender <= 0 { l.PerSender = DefaultFuturePerSender }
+    if l.Max <= 0 { l.Max = DefaultFutureMax }
+    return &futureBuffer{limits: l, bySender: map[string]int{}}
+}
+
+// add buffers msg unless the sender or buffer cap is reached.
+func (b *futureBuffer) add(msg Message, reason string) bool {
+    evict := ""
+    switch {
+    case b.bySender[msg.From] >= b.limits.PerSender:
+        evict = "sender_cap"
+    case len(b.entries) >= b.limits.Max:
+        evict = "full"
+    }
+    if evict != "" {
+        metrics.Inc("qbft_future_evictions_total", map[string]string{"reason": evict})
+        logger.ErrorJ("qbft_future", map[string]any{"op": "buffer", "result": "drop", "reason": evict, "from": msg.From, "type": string(msg.Type), "height": msg.Height, "round": msg.Round, "trace_id": msg.TraceID})
+        return false
+    }
+    b.entries = append(b.entries, msg)
+    b.bySender[msg.From]++
+    metrics.Inc("qbft_future_buffered_total", map[string]string{"reason": reason})
+    metrics.AddGauge("qbft_future_buffer_depth", nil, 1)
+    return true
+}
+
+// take removes and returns (in arrival order) the messages matching keep.
+func (b *futureBuffer) take(match func(Message) bool) []Message {
+    var out []Message
+    kept := b.entries[:0]
+    for _, m := range b.entries {
+        if match(m) { out = append(out, m); b.bySender[m.From]-- } else { kept = append(kept, m) }
+    }
+    b.entries = kept
+    if len(out) > 0 { metrics.AddGauge("qbft_future_buffer_depth", nil, -int64(len(out))) }
+    return out
+}
+
+// evict drops the messages matching stale and counts them.
+func (b *futureBuffer) evict(stale func(Message) bool, reason string) {
+    if n := len(b.take(stale)); n > 0 {
+        for i := 0; i < n; i++ { metrics.Inc("qbft_future_evictions_total", map[string]string{"reason": reason}) }
+    }
+}
+
+func (b *futureBuffer) len() int { return len(b.entries) }
@@ -0,0 +1,60 @@
+package qbft
+
+import (
+    "strings"
+    "testing"
+
+    "github.com/zmlAEQ/Aequa-network/pkg/metrics"
+)
+
+// Votes that arrive before the proposal are held and count once it lands.
+func TestState_Future_EarlyVotesReplayedAfterPreprepare(t *testing.T) {
+    metrics.Reset()
+    st := &State{Leader: "a", Validators: NewValidators([]string{"a", "b", "c", "d"}, 0)}
+    if err := st.Start(2); err != nil { t.Fatalf("start: %v", err) }
+    for _, f := range []string{"b", "c"} {
+        if err := st.Process(Message{ID: "blk", From: f, Type: MsgPrepare, Height: 2}); err == nil {
+            t.Fatalf("early prepare from %s must still report an error", f)
+        }
+    }
+    if st.future.len() != 2 { t.Fatalf("want 2 buffered, got %d", st.future.len()) }
+    if err := st.Process(Message{ID: "blk", From: "a", Type: MsgPreprepare, Height: 2}); err != nil { t.Fatalf("preprepare: %v", err) }
+    if err := st.Process(Message{ID: "blk", From: "d", Type: MsgPrepare, Height: 2}); err != nil { t.Fatalf("prepare: %v", err) }
+    if st.Phase != "prepared" { t.Fatalf("replayed votes should reach quorum, phase=%q", st.Phase) }
+    if dump := metrics.DumpProm(); !strings.Contains(dump, `qbft_future_replayed_total{type="prepare"} 2`) {
+        t.Fatalf("missing replay counter: %q", dump)
+    }
+}
+
+// A message for a later height is held and replayed once the instance moves there.
+func TestState_Future_HeightBufferedUntilStart(t *testing.T) {
+    metrics.Reset()
+    st := &State{Leader: "a"}
+    if err := st.Start(5); err != nil { t.Fatalf("start: %v", err) }
+    if err := st.Process(Message{ID: "x", From: "a", Type: MsgPreprepare, Height: 6}); err != nil { t.Fatalf("future: %v", err) }
+    if st.Height != 5 || st.Phase != "" { t.Fatalf("future height applied early: h=%d phase=%q", st.Height, st.Phase) }
+    if err := st.Start(6); err != nil { t.Fatalf("start: %v", err) }
+    if st.Phase != "preprepared" || st.proposalID != "x" { t.Fatalf("not replayed: phase=%q", st.Phase) }
+    if dump := metrics.DumpProm(); !strings.Contains(dump, "qbft_future_buffer_depth 0") {
+        t.Fatalf("depth should drain to zero: %q", dump)
+    }
+}
+
+func TestState_Future_PerSenderCapAndStaleEviction(t *testing.T) {
+    metrics.Reset()
+    st := &State{Leader: "a", Future: FutureLimits{PerSender: 2}}
+    if err := st.Start(1); err != nil { t.Fatalf("start: %v", err) }
+    for h := uint64(2); h <= 4; h++ {
+        _ = st.Process(Message{ID: "x", From: "a", Type: MsgPreprepare, Height: h})
+    }
+    dump := metrics.DumpProm()
+    if !strings.Contains(dump, `qbft_future_evictions_total{reason="sender_cap"} 1`) || !strings.Contains(dump, "qbft_future_buffer_depth 2") {
+        t.Fatalf("sender cap not enforced: %q", dump)
+    }
+    // Jumping past the buffered heights evicts them as stale.
+    if err := st.Start(9); err != nil { t.Fatalf("start: %v", err) }
+    dump = metrics.DumpProm()
+    if !strings.Contains(dump, `qbft_future_evictions_total{reason="stale"} 2`) || !strings.Contains(dump, "qbft_future_buffer_depth 0") {
+        t.Fatalf("stale entries not evicted: %q", dump)
+    }
+}
@@ -69,17 +69,17 @@ func FuzzState_NoPanic(f *testing.F) {
             _ = st.Process(Message{ID: "blk", From: "L", Type: MsgPreprepare, Height: 10, Round: 0})
         }
         // First prepare (may be before preprepare to exercise error path)
-        _ = st.Process(Message{ID: "blk", From: "P1", Type: MsgPrepare, Height: 10, Round: 1})
+        _ = st.Process(Message{ID: "blk", From: "P1", Type: MsgPrepare, Height: 10})
         // Optional duplicate or second distinct prepare to reach threshold when preprepared
         if b%2 == 0 { // distinct second
-            _ = st.Process(Message{ID: "blk", From: "P2", Type: MsgPrepare, Height: 10, Round: 1})
+            _ = st.Process(Message{ID: "blk", From: "P2", Type: MsgPrepare, Height: 10})
         } else { // duplicate
-            _ = st.Process(Message{ID: "blk", From: "P1", Type: MsgPrepare, Height: 10, Round: 1})
+            _ = st.Process(Message{ID: "blk", From: "P1", Type: MsgPrepare, Height: 10})
         }
         // Commit with matched or mismatched proposal ID
         id := "blk"
         if c%2 == 1 { id = "blkX" }
-        _ = st.Process(Message{ID: id, From: "C1", Type: MsgCommit, Height: 10, Round: 1})
+        _ = st.Process(Message{ID: id, From: "C1", Type: MsgCommit, Height: 10})
 
         // Ensure phase remains one of known labels (empty, preprepared, prepared, commit)
         switch st.Phase {
@@ -0,0 +1,45 @@
+package qbft
+
+import (
+    "crypto/sha256"
+    "encoding/binary"
+    "encoding/hex"
+
+    "github.com/zmlAEQ/Aequa-network/internal/consensus/payload"
+)
+
+// ProposalIDOf derives the id of a proposed value from its payload hash. With
+// a payload manager the hash covers the canonical payload; without one it is
+// sha256 over the raw bytes.
+func ProposalIDOf(mgr payload.Manager, b []byte) (string, error) {
+    if mgr == nil {
+        h := sha256.Sum256(b)
+        return hex.EncodeToString(h[:]), nil
+    }
+    h, err := mgr.Hash(b)
+    if err != nil { return "", err }
+    return hex.EncodeToString(h[:]), nil
+}
+
+// ContentID derives a message id from (type, duty, height, round, proposal
+// reference, sender, prepared round). Roundchanges reference their prepared
+// proposal.
+func ContentID(m Message) string {
+    ref := ProposalRef(m)
+    if m.Type == MsgRoundChange { ref = m.PreparedID }
+    h := sha256.New()
+    var u [8]byte
+    for _, s := range []string{string(m.Type), m.Duty} { binary.BigEndian.PutUint64(u[:], uint64(len(s))); h.Write(u[:]); h.Write([]byte(s)) }
+    binary.BigEndian.PutUint64(u[:], m.Height); h.Write(u[:])
+    binary.BigEndian.PutUint64(u[:], m.Round); h.Write(u[:])
+    for _, s := range []string{ref, m.From} { binary.BigEndian.PutUint64(u[:], uint64(len(s))); h.Write(u[:]); h.Write([]byte(s)) }
+    binary.BigEndian.PutUint64(u[:], m.PreparedRound); h.Write(u[:])
+    return hex.EncodeToString(h.Sum(nil))
+}
+
+// ProposalRef returns the proposal a message refers to: ProposalID when set,
+// otherwise the legacy free-form ID.
+func ProposalRef(m Message) string {
+    if m.ProposalID != "" { return m.ProposalID }
+    return m.ID
+}
@@ -0,0 +1,108 @@
+package qbft
+
+import (
+    "strings"
+    "testing"
+
+    "github.com/zmlAEQ/Aequa-network/internal/consensus/payload"
+    "github.com/zmlAEQ/Aequa-network/pkg/metrics"
+)
+
+func contentPreprepare(t *testing.T, mgr payload.Manager, body string) Message {
+    t.Helper()
+    pid, err := ProposalIDOf(mgr, []byte(body))
+    if err != nil { t.Fatalf("proposal id: %v", err) }
+    m := Message{From: "p", Type: MsgPreprepare, Height: 5, Payload: []byte(body), ProposalID: pid}
+    m.ID = ContentID(m)
+    return m
+}
+
+func TestContentID_CoversFields(t *testing.T) {
+    base := Message{From: "p", Type: MsgPrepare, Height: 1, Round: 1, ProposalID: "x"}
+    for _, mut := range []func(*Message){
+        func(m *Message) { m.From = "q" },
+        func(m *Message) { m.Type = MsgCommit },
+        func(m *Message) { m.Height = 2 },
+        func(m *Message) { m.Round = 2 },
+        func(m *Message) { m.ProposalID = "y" },
+        func(m *Message) { m.Duty = "attester" },
+    } {
+        m := base
+        mut(&m)
+        if ContentID(m) == ContentID(base) { t.Fatalf("content id ignores a field: %+v", m) }
+    }
+    rc := Message{From: "p", Type: MsgRoundChange, Height: 1, Round: 2, PreparedRound: 0, PreparedID: "x"}
+    for _, mut := range []func(*Message){
+        func(m *Message) { m.PreparedRound = 1 },
+        func(m *Message) { m.PreparedID = "y" },
+    } {
+        m := rc
+        mut(&m)
+        if ContentID(m) == ContentID(rc) { t.Fatalf("content id ignores a prepared field: %+v", m) }
+    }
+}
+
+// Canonically equal payloads share a proposal id through the payload manager.
+func TestProposalIDOf_UsesManagerHash(t *testing.T) {
+    mgr := payload.NewJSONManager(1 << 10)
+    a, _ := ProposalIDOf(mgr, []byte(`{"x": 1}`))
+    b, _ := ProposalIDOf(mgr, []byte(`{"x":1}`))
+    if a != b || a == "" { t.Fatalf("want equal ids for canonical-equal payloads: %q %q", a, b) }
+    if _, err := ProposalIDOf(mgr, []byte("not json")); err == nil { t.Fatalf("want invalid payload error") }
+}
+
+func TestBasicVerifier_ContentIDs(t *testing.T) {
+    metrics.Reset()
+    mgr := payload.NewJSONManager(1 << 10)
+    v := NewBasicVerifierWithPolicy(Policy{ContentIDs: true, Payloads: mgr})
+    pp := contentPreprepare(t, mgr, `{"slot":1}`)
+    if err := v.Verify(pp); err != nil { t.Fatalf("content-addressed preprepare: %v", err) }
+
+    forged := contentPreprepare(t, mgr, `{"slot":2}`)
+    forged.ProposalID = pp.ProposalID // claims another payload's id
+    forged.ID = ContentID(forged)
+    if err := v.Verify(forged); err == nil { t.Fatalf("want proposal_id_mismatch") }
+
+    vote := Message{From: "q", Type: MsgPrepare, Height: 5, Round: 1, ProposalID: pp.ProposalID}
+    vote.ID = ContentID(vote)
+    if err := v.Verify(vote); err != nil { t.Fatalf("content-addressed prepare: %v", err) }
+    reused := vote
+    reused.Round = 2 // same id, different content
+    if err := v.Verify(reused); err == nil { t.Fatalf("want id_mismatch for reused id") }
+    if err := v.Verify(Message{ID: "free-form", From: "q", Type: MsgCommit, Round: 1, ProposalID: "x"}); err == nil {
+        t.Fatalf("want id_mismatch for free-form id")
+    }
+    dump := metrics.DumpProm()
+    if !strings.Contains(dump, `qbft_msg_verified_total{result="error"} 3`) {
+        t.Fatalf("want error=3, got %q", dump)
+    }
+}
+
+// Votes bind to the proposal through ProposalID, so distinct voters no longer
+// share (and replay-collide on) the proposal's id.
+func TestState_ContentAddressedVotes(t *testing.T) {
+    st := &State{Leader: "p"}
+    pp := contentPreprepare(t, nil, "v")
+    if err := st.Process(pp); err != nil { t.Fatalf("preprepare: %v", err) }
+    for _, from := range []string{"a", "b"} {
+        m := Message{From: from, Type: MsgPrepare, Height: 5, ProposalID: pp.ProposalID}
+        m.ID = ContentID(m)
+        if err := st.Process(m); err != nil { t.Fatalf("prepare %s: %v", from, err) }
+    }
+    if st.Phase != "prepared" { t.Fatalf("want prepared, got %q", st.Phase) }
+    bad := Message{From: "c", Type: MsgCommit, Height: 5, ProposalID: "other"}
+    bad.ID = ContentID(bad)
+    if err := st.Process(bad); err == nil { t.Fatalf("want proposal mismatch") }
+}
+
+// A roundchange's PreparedID must hash its carried value.
+func TestBasicVerifier_ContentIDs_PreparedID(t *testing.T) {
+    mgr := payload.NewJSONManager(1 << 10)
+    v := NewBasicVerifierWithPolicy(Policy{ContentIDs: true, Payloads: mgr, Order: []string{RuleContentID}})
+    pid, _ := ProposalIDOf(mgr, []byte(`{"slot":1}`))
+    rc := Message{From: "p", Type: MsgRoundChange, Height: 5, Round: 1, PreparedID: pid, Payload: []byte(`{"slot":1}`)}
+    rc.ID = ContentID(rc)
+    if err := v.Verify(rc); err != nil { t.Fatalf("matching prepared id: %v", err) }
+    rc.Payload = []byte(`{"slot":2}`)
+    if err := v.Verify(rc); err == nil || !strings.Contains(err.Error(), "prepared_id_mismatch") { t.Fatalf("want prepared_id_mismatch, got %v", err) }
+}
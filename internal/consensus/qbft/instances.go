@@ -0,0 +1,265 @@
+package qbft
+
+import (
+    "fmt"
+    "sort"
+    "sync"
+    "time"
+
+    "github.com/zmlAEQ/Aequa-network/pkg/logger"
+    "github.com/zmlAEQ/Aequa-network/pkg/metrics"
+)
+
+// InstanceKey identifies one QBFT instance.
+type InstanceKey struct {
+    Duty   string
+    Height uint64
+}
+
+func (k InstanceKey) String() string { return fmt.Sprintf("%s/%d", k.Duty, k.Height) }
+
+// KeyOf returns the instance key a message belongs to.
+func KeyOf(msg Message) InstanceKey { return InstanceKey{Duty: msg.Duty, Height: msg.Height} }
+
+// Defaults for InstanceManager limits.
+const (
+    DefaultMaxInstances   = 64
+    DefaultInstanceTTL    = 5 * time.Minute
+    DefaultInstanceWindow = 16
+)
+
+type instance struct {
+    st      *State
+    created time.Time
+}
+
+// InstanceManager runs one independent State per (duty, height) and routes
+// each message to its instance. Peers' messages only create instances within
+// a window of the current height (the highest one started locally or
+// decided); at capacity the instance furthest from it is evicted. Decided or
+// expired instances are garbage-collected. Decided keys are remembered until
+// the TTL elapses so late messages do not resurrect finished instances.
+// Each instance buffers its own out-of-order messages; instances for other
+// heights are independent, so height ordering only matters within a State.
+// State.Broadcast must not synchronously re-enter the manager.
+type InstanceManager struct {
+    mu        sync.Mutex
+    factory   func(InstanceKey) *State
+    max       int
+    ttl       time.Duration
+    now       func() time.Time
+    instances map[InstanceKey]*instance
+    done      map[InstanceKey]time.Time
+    onRemove  func(InstanceKey, string)
+    window    uint64
+    current   uint64
+}
+
+// NewInstanceManager constructs a manager; factory builds the State for a new
+// instance (nil yields a bare State). Non-positive limits select defaults.
+func NewInstanceManager(max int, ttl time.Duration, factory func(InstanceKey) *State) *InstanceManager {
+    if max <= 0 { max = DefaultMaxInstances }
+    if ttl <= 0 { ttl = DefaultInstanceTTL }
+    if factory == nil { factory = func(k InstanceKey) *State { return &State{Duty: k.Duty} } }
+    return &InstanceManager{factory: factory, max: max, ttl: ttl, window: DefaultInstanceWindow, now: time.Now, instances: map[InstanceKey]*instance{}, done: map[InstanceKey]time.Time{}}
+}
+
+// SetWindow sets how far from the current height peers' messages may open
+// instances (0 keeps the default).
+func (m *InstanceManager) SetWindow(w uint64) {
+    if w == 0 { w = DefaultInstanceWindow }
+    m.window = w
+}
+
+// SetClock overrides the clock used for expiry (tests/simulation).
+func (m *InstanceManager) SetClock(now func() time.Time) { m.now = now }
+
+// SetOnRemove registers f to run, under the manager lock, whenever an
+// instance is removed, with the reason ("decided", "expired", "evicted").
+func (m *InstanceManager) SetOnRemove(f func(key InstanceKey, reason string)) { m.onRemove = f }
+
+// Start creates (if needed) and starts the instance for key.
+func (m *InstanceManager) Start(key InstanceKey) (*State, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    m.advance(key.Height)
+    in, err := m.get(key, true)
+    if err != nil { return nil, err }
+    return in.st, in.st.Start(key.Height)
+}
+
+// Propose implements Proposer: it creates (if needed) the instance for key
+// and starts it with value as this node's input.
+func (m *InstanceManager) Propose(key InstanceKey, value []byte) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    m.advance(key.Height)
+    in, err := m.get(key, true)
+    if err != nil {
+        logger.ErrorJ("qbft_instances", map[string]any{"op": "propose", "result": "drop", "reason": err.Error(), "duty": key.Duty, "height": key.Height, "trace_id": ""})
+        return err
+    }
+    return in.st.Propose(key, value)
+}
+
+// Process routes msg to its instance, creating it on first use.
+func (m *InstanceManager) Process(msg Message) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    in, err := m.get(KeyOf(msg), false)
+    if err != nil {
+        logger.ErrorJ("qbft_instances", map[string]any{"op": "route", "result": "drop", "reason": err.Error(), "duty": msg.Duty, "height": msg.Height, "trace_id": msg.TraceID})
+        return err
+    }
+    return in.st.Process(msg)
+}
+
+// Tick drives round timers of all live instances, in key order so runs are
+// reproducible, and collects garbage.
+func (m *InstanceManager) Tick(now time.Time) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    for _, k := range m.sortedKeys() { _ = m.instances[k].st.Tick(now) }
+    m.gc(now)
+    return nil
+}
+
+// Instance returns the live instance for key, if any.
+func (m *InstanceManager) Instance(key InstanceKey) (*State, bool) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    in, ok := m.instances[key]
+    if !ok { return nil, false }
+    return in.st, true
+}
+
+// Len returns the number of live instances.
+func (m *InstanceManager) Len() int {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    return len(m.instances)
+}
+
+// get returns the instance for key, creating it if key is local (this node's
+// duty schedule or a restored instance) or within the window of the current
+// height. At capacity the live instance furthest from the current height is
+// evicted, unless key is a peer's and itself further off.
+func (m *InstanceManager) get(key InstanceKey, local bool) (*instance, error) {
+    if in, ok := m.instances[key]; ok { return in, nil }
+    if _, ok := m.done[key]; ok {
+        metrics.Inc("qbft_instances_dropped_total", map[string]string{"reason": "done"})
+        return nil, fmt.Errorf("instance done")
+    }
+    if !local && m.current > 0 && m.distance(key.Height) > m.window {
+        metrics.Inc("qbft_instances_dropped_total", map[string]string{"reason": "window"})
+        return nil, fmt.Errorf("instance out of window")
+    }
+    if len(m.instances) >= m.max {
+        m.gc(m.now())
+        if len(m.instances) >= m.max {
+            far, ok := m.furthest()
+            if !ok || (!local && m.distance(far.Height) <= m.distance(key.Height)) {
+                metrics.Inc("qbft_instances_dropped_total", map[string]string{"reason": "capacity"})
+                return nil, fmt.Errorf("instance capacity")
+            }
+            m.remove(far, "evicted")
+            metrics.SetGauge("qbft_instances_active", nil, int64(len(m.instances)))
+        }
+    }
+    st := m.factory(key)
+    st.Duty = key.Duty
+    in := &instance{st: st, created: m.now()}
+    m.instances[key] = in
+    metrics.Inc("qbft_instances_created_total", nil)
+    metrics.SetGauge("qbft_instances_active", nil, int64(len(m.instances)))
+    logger.InfoJ("qbft_instances", map[string]any{"op": "create", "duty": key.Duty, "height": key.Height, "active": len(m.instances), "trace_id": ""})
+    return in, nil
+}
+
+// gc removes decided instances and those older than the TTL, and forgets
+// decided keys once they expire.
+func (m *InstanceManager) gc(now time.Time) {
+    for _, k := range m.sortedKeys() {
+        in := m.instances[k]
+        reason := ""
+        switch {
+        case in.st.Phase == "commit":
+            reason = "decided"
+            m.done[k] = now
+        case now.Sub(in.created) > m.ttl:
+            reason = "expired"
+        default:
+            continue
+        }
+        if reason == "decided" { m.advance(k.Height) }
+        m.remove(k, reason)
+    }
+    for k, at := range m.done {
+        if now.Sub(at) > m.ttl { delete(m.done, k) }
+    }
+    metrics.SetGauge("qbft_instances_active", nil, int64(len(m.instances)))
+}
+
+// remove tears down the live instance k.
+func (m *InstanceManager) remove(k InstanceKey, reason string) {
+    m.instances[k].st.discardFuture()
+    delete(m.instances, k)
+    if m.onRemove != nil { m.onRemove(k, reason) }
+    metrics.Inc("qbft_instances_gc_total", map[string]string{"reason": reason})
+    logger.InfoJ("qbft_instances", map[string]any{"op": "gc", "reason": reason, "duty": k.Duty, "height": k.Height, "trace_id": ""})
+}
+
+// advance raises the current height to h.
+func (m *InstanceManager) advance(h uint64) {
+    if h > m.current { m.current = h }
+}
+
+// distance returns how far h is from the current height.
+func (m *InstanceManager) distance(h uint64) uint64 {
+    if h > m.current { return h - m.current }
+    return m.current - h
+}
+
+// furthest returns the live instance furthest from the current height (ties
+// go to the later key in sortedKeys order).
+func (m *InstanceManager) furthest() (InstanceKey, bool) {
+    var far InstanceKey
+    found := false
+    for _, k := range m.sortedKeys() {
+        if !found || m.distance(k.Height) >= m.distance(far.Height) { far, found = k, true }
+    }
+    return far, found
+}
+
+// sortedKeys returns the live instance keys ordered by duty, then height.
+func (m *InstanceManager) sortedKeys() []InstanceKey {
+    keys := make([]InstanceKey, 0, len(m.instances))
+    for k := range m.instances { keys = append(keys, k) }
+    sort.Slice(keys, func(i, j int) bool { if keys[i].Duty != keys[j].Duty { return keys[i].Duty < keys[j].Duty }; return keys[i].Height < keys[j].Height })
+    return keys
+}
+
+// SnapshotFor implements Snapshotter for the instance msg is routed to.
+func (m *InstanceManager) SnapshotFor(msg Message) (Snapshot, bool) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    in, ok := m.instances[KeyOf(msg)]
+    if !ok { return Snapshot{}, false }
+    return in.st.SnapshotFor(msg)
+}
+
+// Restore recreates the instance of sn (subject to capacity) and resumes it.
+func (m *InstanceManager) Restore(sn Snapshot) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    in, err := m.get(InstanceKey{Duty: sn.Duty, Height: sn.Height}, true)
+    if err != nil { return err }
+    return in.st.Restore(sn)
+}
+
+var (
+    _ Snapshotter = (*State)(nil)
+    _ Snapshotter = (*InstanceManager)(nil)
+    _ Proposer    = (*State)(nil)
+    _ Proposer    = (*InstanceManager)(nil)
+)
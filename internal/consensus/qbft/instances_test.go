@@ -0,0 +1,111 @@
+package qbft
+
+import (
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/zmlAEQ/Aequa-network/pkg/metrics"
+)
+
+// Overlapping duties at the same height run in independent instances.
+func TestInstanceManager_RoutesByDutyAndHeight(t *testing.T) {
+    m := NewInstanceManager(0, 0, func(k InstanceKey) *State { return &State{Leader: "L"} })
+    if err := m.Process(Message{ID: "a", From: "L", Duty: "attester", Type: MsgPreprepare, Height: 5}); err != nil { t.Fatalf("attester: %v", err) }
+    if err := m.Process(Message{ID: "b", From: "L", Duty: "proposer", Type: MsgPreprepare, Height: 5}); err != nil { t.Fatalf("proposer: %v", err) }
+    if err := m.Process(Message{ID: "a", From: "P1", Duty: "attester", Type: MsgPrepare, Height: 5}); err != nil {
+        t.Fatalf("attester prepare must match its own proposal: %v", err)
+    }
+    att, _ := m.Instance(InstanceKey{Duty: "attester", Height: 5})
+    pro, _ := m.Instance(InstanceKey{Duty: "proposer", Height: 5})
+    if id, _ := att.Proposal(); id != "a" { t.Fatalf("attester proposal: %q", id) }
+    if id, _ := pro.Proposal(); id != "b" { t.Fatalf("proposer proposal: %q", id) }
+    if m.Len() != 2 { t.Fatalf("want 2 instances, got %d", m.Len()) }
+}
+
+func TestInstanceManager_CapacityAndGC(t *testing.T) {
+    metrics.Reset()
+    now := time.Unix(100, 0)
+    m := NewInstanceManager(2, time.Minute, func(k InstanceKey) *State { return &State{Leader: "L"} })
+    m.SetClock(func() time.Time { return now })
+    var removed []string
+    m.SetOnRemove(func(k InstanceKey, reason string) { removed = append(removed, k.String()+":"+reason) })
+    _ = m.Process(Message{ID: "1", From: "L", Type: MsgPreprepare, Height: 1})
+    _ = m.Process(Message{ID: "2", From: "L", Type: MsgPreprepare, Height: 2})
+    if err := m.Process(Message{ID: "3", From: "L", Type: MsgPreprepare, Height: 3}); err == nil {
+        t.Fatalf("want capacity rejection")
+    }
+    // Decide height 1 (unconfigured thresholds: 2 prepares, 1 commit).
+    for _, msg := range []Message{
+        {ID: "1", From: "P1", Type: MsgPrepare, Height: 1},
+        {ID: "1", From: "P2", Type: MsgPrepare, Height: 1},
+        {ID: "1", From: "C1", Type: MsgCommit, Height: 1},
+    } {
+        if err := m.Process(msg); err != nil { t.Fatalf("decide: %v", err) }
+    }
+    _ = m.Tick(now)
+    if m.Len() != 1 { t.Fatalf("decided instance not collected: %d", m.Len()) }
+    if err := m.Process(Message{ID: "1", From: "C2", Type: MsgCommit, Height: 1}); err == nil {
+        t.Fatalf("late message must not resurrect a decided instance")
+    }
+    now = now.Add(2 * time.Minute)
+    _ = m.Tick(now)
+    if m.Len() != 0 { t.Fatalf("expired instance not collected: %d", m.Len()) }
+    if strings.Join(removed, ",") != "/1:decided,/2:expired" { t.Fatalf("removals: %v", removed) }
+    dump := metrics.DumpProm()
+    for _, want := range []string{
+        `qbft_instances_gc_total{reason="decided"} 1`,
+        `qbft_instances_gc_total{reason="expired"} 1`,
+        `qbft_instances_dropped_total{reason="capacity"} 1`,
+        `qbft_instances_active 0`,
+    } {
+        if !strings.Contains(dump, want) { t.Fatalf("missing %s in %q", want, dump) }
+    }
+}
+
+// Peers only open instances near the current height; at capacity the
+// instance furthest from it makes room.
+func TestInstanceManager_WindowAndEviction(t *testing.T) {
+    metrics.Reset()
+    m := NewInstanceManager(3, time.Minute, func(k InstanceKey) *State { return &State{Leader: "L"} })
+    m.SetWindow(4)
+    var removed []string
+    m.SetOnRemove(func(k InstanceKey, reason string) { removed = append(removed, k.String()+":"+reason) })
+    if _, err := m.Start(InstanceKey{Height: 100}); err != nil { t.Fatalf("local start: %v", err) }
+    if err := m.Process(Message{ID: "far", From: "L", Type: MsgPreprepare, Height: 1 << 40}); err == nil { t.Fatalf("want out of window") }
+    if err := m.Process(Message{ID: "old", From: "L", Type: MsgPreprepare, Height: 90}); err == nil { t.Fatalf("want out of window below") }
+    _ = m.Process(Message{ID: "a", From: "L", Type: MsgPreprepare, Height: 104})
+    _ = m.Process(Message{ID: "b", From: "L", Type: MsgPreprepare, Height: 101})
+    // Full: a closer peer instance evicts the furthest one, a further one is refused.
+    if err := m.Process(Message{ID: "c", From: "L", Type: MsgPreprepare, Height: 102}); err != nil { t.Fatalf("closer instance: %v", err) }
+    if _, ok := m.Instance(InstanceKey{Height: 104}); ok { t.Fatalf("furthest instance not evicted") }
+    if err := m.Process(Message{ID: "d", From: "L", Type: MsgPreprepare, Height: 103}); err == nil { t.Fatalf("want capacity refusal for a further instance") }
+    // The local schedule always gets its instance.
+    if _, err := m.Start(InstanceKey{Height: 110}); err != nil { t.Fatalf("local start at capacity: %v", err) }
+    if m.Len() != 3 || strings.Join(removed, ",") != "/104:evicted,/100:evicted" { t.Fatalf("len=%d removals=%v", m.Len(), removed) }
+    dump := metrics.DumpProm()
+    for _, want := range []string{`qbft_instances_dropped_total{reason="window"} 2`, `qbft_instances_gc_total{reason="evicted"} 2`} {
+        if !strings.Contains(dump, want) { t.Fatalf("missing %s in %q", want, dump) }
+    }
+}
+
+// Propose starts an instance with this node's input; on a running instance it
+// keeps the progress made so far.
+func TestInstanceManager_Propose(t *testing.T) {
+    var out []Message
+    m := NewInstanceManager(0, 0, func(k InstanceKey) *State {
+        return &State{Self: "a", Broadcast: func(msg Message) { out = append(out, msg) }, LeaderFn: RoundRobin("a", "b", "c", "d")}
+    })
+    if err := m.Propose(InstanceKey{Duty: "attester", Height: 4}, []byte("v4")); err != nil { t.Fatalf("propose: %v", err) }
+    if len(out) != 1 || out[0].Type != MsgPreprepare || string(out[0].Payload) != "v4" || out[0].Duty != "attester" { t.Fatalf("leader did not propose: %+v", out) }
+
+    // Another operator leads height 5; its preprepare arrives before the duty does.
+    out = nil
+    key := InstanceKey{Duty: "attester", Height: 5}
+    leader := RoundRobin("a", "b", "c", "d")(5, 0)
+    if err := m.Process(Message{ID: "p", ProposalID: "v5", From: leader, Type: MsgPreprepare, Duty: "attester", Height: 5, Payload: []byte("v5")}); err != nil { t.Fatalf("preprepare: %v", err) }
+    if err := m.Propose(key, []byte("other")); err != nil { t.Fatalf("propose: %v", err) }
+    st, _ := m.Instance(key)
+    if id, _ := st.Proposal(); id != "v5" || st.Phase != "preprepared" { t.Fatalf("running instance was reset: id=%q phase=%q", id, st.Phase) }
+    for _, msg := range out { if msg.Type == MsgPreprepare { t.Fatalf("follower proposed: %+v", msg) } }
+}
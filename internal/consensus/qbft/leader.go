@@ -0,0 +1,58 @@
+package qbft
+
+import (
+    "sort"
+
+    "github.com/zmlAEQ/Aequa-network/pkg/config"
+)
+
+// LeaderFunc selects the proposer for a given height and round. It must be
+// deterministic so every operator derives the same leader.
+type LeaderFunc func(height, round uint64) string
+
+// RoundRobin rotates the leader over ids by height+round.
+func RoundRobin(ids ...string) LeaderFunc {
+    return func(height, round uint64) string {
+        if len(ids) == 0 { return "" }
+        return ids[(height+round)%uint64(len(ids))]
+    }
+}
+
+// WeightedRoundRobin rotates over ids giving each weights[i] consecutive
+// slots per cycle. Non-positive weights count as 1.
+func WeightedRoundRobin(ids []string, weights []int) LeaderFunc {
+    slots := make([]int, len(ids))
+    var total uint64
+    for i := range ids {
+        w := 1
+        if i < len(weights) && weights[i] > 0 { w = weights[i] }
+        slots[i] = w
+        total += uint64(w)
+    }
+    return func(height, round uint64) string {
+        if total == 0 { return "" }
+        pos := (height + round) % total
+        for i, w := range slots {
+            if pos < uint64(w) { return ids[i] }
+            pos -= uint64(w)
+        }
+        return ""
+    }
+}
+
+// LeaderFromLock returns the leader selection for a cluster lock: round-robin
+// over operators in index order, weighted when any operator declares a weight.
+func LeaderFromLock(l config.ClusterLock) LeaderFunc {
+    ops := append([]config.Operator(nil), l.Operators...)
+    sort.SliceStable(ops, func(i, j int) bool { return ops[i].Index < ops[j].Index })
+    ids := make([]string, 0, len(ops))
+    weights := make([]int, 0, len(ops))
+    weighted := false
+    for _, op := range ops {
+        ids = append(ids, op.PeerID)
+        weights = append(weights, op.Weight)
+        if op.Weight > 0 { weighted = true }
+    }
+    if weighted { return WeightedRoundRobin(ids, weights) }
+    return RoundRobin(ids...)
+}
@@ -0,0 +1,62 @@
+package qbft
+
+import (
+    "strings"
+    "testing"
+
+    "github.com/zmlAEQ/Aequa-network/pkg/config"
+    "github.com/zmlAEQ/Aequa-network/pkg/metrics"
+)
+
+func TestRoundRobin_RotatesByHeightAndRound(t *testing.T) {
+    f := RoundRobin("a", "b", "c")
+    if f(0, 0) != "a" || f(0, 1) != "b" || f(1, 0) != "b" || f(1, 2) != "a" {
+        t.Fatalf("unexpected rotation: %s %s %s %s", f(0, 0), f(0, 1), f(1, 0), f(1, 2))
+    }
+    if RoundRobin()(1, 1) != "" { t.Fatalf("empty set must yield no leader") }
+}
+
+func TestWeightedRoundRobin_Slots(t *testing.T) {
+    f := WeightedRoundRobin([]string{"a", "b"}, []int{3, 1})
+    var got []string
+    for r := uint64(0); r < 8; r++ { got = append(got, f(0, r)) }
+    if s := strings.Join(got, ""); s != "aaabaaab" { t.Fatalf("weighted sequence: %s", s) }
+}
+
+func TestLeaderFromLock_IndexOrderAndWeights(t *testing.T) {
+    l := config.ClusterLock{Operators: []config.Operator{{Index: 1, PeerID: "n1"}, {Index: 0, PeerID: "n0"}}}
+    if f := LeaderFromLock(l); f(0, 0) != "n0" || f(0, 1) != "n1" { t.Fatalf("round-robin by index") }
+    l.Operators[0].Weight = 2
+    if f := LeaderFromLock(l); f(0, 0) != "n0" || f(0, 1) != "n1" || f(0, 2) != "n1" { t.Fatalf("weighted by index") }
+}
+
+// The state sets its leader at every new height and round.
+func TestState_LeaderFn_SetsLeaderPerRound(t *testing.T) {
+    st := &State{LeaderFn: RoundRobin("a", "b", "c", "d")}
+    _ = st.Start(2)
+    if st.Leader != "c" { t.Fatalf("height 2 round 0: %q", st.Leader) }
+    if err := st.Process(Message{ID: "x", From: "a", Type: MsgPreprepare, Height: 2}); err == nil {
+        t.Fatalf("want unauthorized leader")
+    }
+    _ = st.OnTimeout(0)
+    if st.Leader != "d" { t.Fatalf("height 2 round 1: %q", st.Leader) }
+}
+
+func TestBasicVerifier_Preprepare_NotLeader(t *testing.T) {
+    metrics.Reset()
+    v := NewBasicVerifierWithPolicy(Policy{Leader: RoundRobin("a", "b")})
+    if err := v.Verify(Message{ID: "pp", From: "b", Type: MsgPreprepare, Height: 2}); err == nil {
+        t.Fatalf("want not_leader rejection")
+    }
+    if err := v.Verify(Message{ID: "pp-ok", From: "a", Type: MsgPreprepare, Height: 2}); err != nil {
+        t.Fatalf("leader preprepare: %v", err)
+    }
+    // votes are not subject to the leader check
+    if err := v.Verify(Message{ID: "pr", From: "b", Type: MsgPrepare, Height: 2, Round: 1}); err != nil {
+        t.Fatalf("prepare from non-leader: %v", err)
+    }
+    dump := metrics.DumpProm()
+    if !strings.Contains(dump, `qbft_msg_verified_total{result="unauthorized"} 1`) {
+        t.Fatalf("want unauthorized=1, got %q", dump)
+    }
+}
@@ -3,20 +3,36 @@ package qbft
 type Type string
 
 const (
-    MsgPreprepare Type = "preprepare"
-    MsgPrepare   Type = "prepare"
-    MsgCommit    Type = "commit"
+    MsgPreprepare  Type = "preprepare"
+    MsgPrepare     Type = "prepare"
+    MsgCommit      Type = "commit"
+    MsgRoundChange Type = "roundchange"
 )
 
 type Message struct {
     From    string
+    // Duty identifies the validator duty an instance decides on (e.g.
+    // "attester"); together with Height it selects the QBFT instance.
+    Duty    string
     Height  uint64
     Round   uint64
     Type    Type
     Payload []byte
     ID      string
+    // ProposalID references the proposed value (hex payload hash). Preprepares
+    // carry the id of their own payload; votes the id they vote for. When empty,
+    // ID doubles as the proposal reference (legacy free-form ids).
+    ProposalID string
     TraceID string
-    // Sig is a placeholder for a signature/aggregate signature byte slice.
-    // For now, the verifier仅检查形状（长度阈值），不做密码学验真。
+    // Sig is the sender's ed25519 signature over Digest().
     Sig     []byte
-}
\ No newline at end of file
+
+    // PreparedRound/PreparedID describe the highest round in which the sender
+    // prepared a proposal (roundchange only; an empty PreparedID means not
+    // prepared). The prepared value itself travels in Payload.
+    PreparedRound uint64
+    PreparedID    string
+    // Justification carries the roundchange quorum that allows a preprepare
+    // for a round above 0.
+    Justification []Message
+}
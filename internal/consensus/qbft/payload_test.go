@@ -0,0 +1,49 @@
+package qbft
+
+import (
+    "strings"
+    "testing"
+
+    "github.com/zmlAEQ/Aequa-network/internal/consensus/payload"
+    "github.com/zmlAEQ/Aequa-network/pkg/metrics"
+)
+
+func TestBasicVerifier_Payload_Reasons(t *testing.T) {
+    metrics.Reset()
+    v := NewBasicVerifierWithPolicy(Policy{Payloads: payload.NewJSONManager(16)})
+    if err := v.Verify(Message{ID: "big", From: "p", Type: MsgPreprepare, Payload: []byte(`{"k":"0123456789abcdef"}`)}); err == nil || err.Error() != "payload_oversize" {
+        t.Fatalf("want payload_oversize, got %v", err)
+    }
+    if err := v.Verify(Message{ID: "bad", From: "p", Type: MsgPreprepare, Payload: []byte(`{`)}); err == nil || err.Error() != "payload_invalid" {
+        t.Fatalf("want payload_invalid, got %v", err)
+    }
+    if err := v.Verify(Message{ID: "rc", From: "p", Type: MsgRoundChange, Round: 1, PreparedID: "x", Payload: []byte(`nope`)}); err == nil {
+        t.Fatalf("prepared roundchange values are validated too")
+    }
+    if err := v.Verify(Message{ID: "ok", From: "p", Type: MsgPreprepare, Payload: []byte(`{"a":1}`)}); err != nil { t.Fatalf("unexpected: %v", err) }
+    dump := metrics.DumpProm()
+    for _, want := range []string{`qbft_payload_rejected_total{reason="payload_oversize"} 1`, `qbft_payload_rejected_total{reason="payload_invalid"} 2`} {
+        if !strings.Contains(dump, want) { t.Fatalf("missing %s in %q", want, dump) }
+    }
+}
+
+// Semantically equal payloads yield one canonical value and digest.
+func TestState_Payload_CanonicalDigest(t *testing.T) {
+    mgr := payload.NewJSONManager(1 << 10)
+    canon, _ := mgr.Canonical([]byte(`{"slot":1,"root":"0xab"}`))
+    want, _ := ProposalIDOf(mgr, canon)
+    for _, raw := range []string{`{"slot":1,"root":"0xab"}`, "{ \"root\" : \"0xab\",\n \"slot\": 1 }"} {
+        st := &State{Leader: "a", Payloads: mgr}
+        if err := st.Process(Message{ID: "pp", From: "a", Type: MsgPreprepare, Height: 1, Payload: []byte(raw)}); err != nil { t.Fatalf("preprepare: %v", err) }
+        id, value := st.Proposal()
+        if id != want || string(value) != string(canon) { t.Fatalf("got (%s, %s), want (%s, %s)", id, value, want, canon) }
+    }
+    st := &State{Leader: "a", Payloads: mgr}
+    if err := st.Process(Message{ID: "pp", ProposalID: "forged", From: "a", Type: MsgPreprepare, Height: 1, Payload: canon}); err == nil || err.Error() != "proposal_id_mismatch" {
+        t.Fatalf("want proposal_id_mismatch, got %v", err)
+    }
+    if err := st.Process(Message{ID: "pp", From: "a", Type: MsgPreprepare, Height: 1, Payload: []byte("x")}); err == nil || err.Error() != "payload_invalid" {
+        t.Fatalf("want payload_invalid, got %v", err)
+    }
+    if st.Phase != "" { t.Fatalf("rejected proposals must not advance: %q", st.Phase) }
+}
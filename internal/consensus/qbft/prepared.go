@@ -0,0 +1,60 @@
+package qbft
+
+import "sort"
+
+// Prepared certificates. A roundchange that claims a prepared value carries,
+// as its Justification, the quorum of prepares that made its sender prepare
+// it. A preprepare above round 0 carries its roundchange quorum (without
+// their certificates) followed by the prepares certifying the highest
+// prepared value among them, which it must re-propose.
+
+// splitJustification separates the roundchanges and prepares of a proposal's
+// justification; other message types are ignored.
+func splitJustification(just []Message) (rcs, prepares []Message) {
+    for _, j := range just {
+        switch j.Type {
+        case MsgRoundChange:
+            rcs = append(rcs, j)
+        case MsgPrepare:
+            prepares = append(prepares, j)
+        }
+    }
+    return rcs, prepares
+}
+
+// highestPrepared returns the roundchange for (duty, height, round) among rcs
+// that claims the highest prepared round, or nil when none claims a prepared
+// value.
+func highestPrepared(rcs []Message, duty string, height, round uint64) *Message {
+    var best *Message
+    for i := range rcs {
+        rc := &rcs[i]
+        if rc.Duty != duty || rc.Height != height || rc.Round != round || rc.PreparedID == "" { continue }
+        if best == nil || rc.PreparedRound > best.PreparedRound { best = rc }
+    }
+    return best
+}
+
+// preparedVotes counts the distinct senders among prepares that prepared id
+// at (duty, height, round). member, if set, restricts the senders counted.
+func preparedVotes(prepares []Message, duty string, height, round uint64, id string, member func(string) bool) int {
+    seen := make(map[string]struct{}, len(prepares))
+    for _, p := range prepares {
+        if p.Type != MsgPrepare || p.Duty != duty || p.Height != height || p.Round != round || ProposalRef(p) != id { continue }
+        if member != nil && !member(p.From) { continue }
+        seen[p.From] = struct{}{}
+    }
+    return len(seen)
+}
+
+// stripCertificates returns rcs ordered by sender without their prepared
+// certificates, as embedded in a proposal's justification.
+func stripCertificates(rcs []Message) []Message {
+    out := make([]Message, 0, len(rcs))
+    for _, rc := range rcs {
+        rc.Justification = nil
+        out = append(out, rc)
+    }
+    sort.Slice(out, func(i, j int) bool { return out[i].From < out[j].From })
+    return out
+}
@@ -0,0 +1,134 @@
+package qbft
+
+import (
+    "sync"
+    "time"
+)
+
+// Rate is a token-bucket limit: PerSecond tokens refill continuously up to
+// Burst. A zero PerSecond disables the limit.
+type Rate struct {
+    PerSecond float64
+    Burst     int
+}
+
+func (r Rate) enabled() bool { return r.PerSecond > 0 }
+
+// RateLimits configures per-sender consensus message limits.
+type RateLimits struct {
+    // PerSender limits all messages from one From.
+    PerSender Rate
+    // PerSenderType limits each (From, Type) pair, so a flood of one type
+    // cannot starve the sender's other messages.
+    PerSenderType Rate
+    // Exempt lists senders never limited, typically this node's own id: its
+    // messages loop back through the verifier and must not throttle its votes.
+    Exempt []string
+}
+
+func (l RateLimits) enabled() bool { return l.PerSender.enabled() || l.PerSenderType.enabled() }
+
+// DefaultRateLimits returns limits well above what an honest operator sends
+// for a few concurrent duties (one message per type and round per instance),
+// while bounding a flood from a single sender.
+func DefaultRateLimits() RateLimits { return NewRateLimits(100, 50) }
+
+// rateBurstSeconds is how many seconds of tokens NewRateLimits allows in a burst.
+const rateBurstSeconds = 4
+
+// NewRateLimits returns limits of perSender and perSenderType messages per
+// second with bursts of a few seconds' worth. A zero rate disables that limit.
+func NewRateLimits(perSender, perSenderType float64) RateLimits {
+    rate := func(r float64) Rate {
+        if r <= 0 { return Rate{} }
+        return Rate{PerSecond: r, Burst: int(r*rateBurstSeconds + 0.5)}
+    }
+    return RateLimits{PerSender: rate(perSender), PerSenderType: rate(perSenderType)}
+}
+
+// maxBuckets bounds limiter memory when senders are not allowlisted; idle
+// (refilled) buckets are dropped first.
+const maxBuckets = 4096
+
+type bucket struct {
+    rate   Rate
+    tokens float64
+    last   time.Time
+}
+
+func (b *bucket) burst() float64 {
+    if b.rate.Burst < 1 { return 1 }
+    return float64(b.rate.Burst)
+}
+
+type rateLimiter struct {
+    mu      sync.Mutex
+    limits  RateLimits
+    exempt  map[string]struct{}
+    now     func() time.Time
+    buckets map[string]*bucket
+}
+
+func newRateLimiter(l RateLimits, now func() time.Time) *rateLimiter {
+    if now == nil { now = time.Now }
+    rl := &rateLimiter{limits: l, now: now, buckets: make(map[string]*bucket)}
+    if len(l.Exempt) > 0 {
+        rl.exempt = make(map[string]struct{}, len(l.Exempt))
+        for _, id := range l.Exempt { rl.exempt[id] = struct{}{} }
+    }
+    return rl
+}
+
+// allow takes one token from every applicable bucket, or none if any is
+// empty. It returns the reason of the first exhausted bucket.
+func (l *rateLimiter) allow(msg Message) (string, bool) {
+    if _, ok := l.exempt[msg.From]; ok { return "", true }
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    now := l.now()
+    type take struct {
+        b      *bucket
+        reason string
+    }
+    var takes []take
+    if r := l.limits.PerSender; r.enabled() {
+        takes = append(takes, take{l.refill("s|"+msg.From, r, now), "sender_rate"})
+    }
+    if r := l.limits.PerSenderType; r.enabled() {
+        takes = append(takes, take{l.refill("t|"+msg.From+"|"+string(msg.Type), r, now), "sender_type_rate"})
+    }
+    for _, t := range takes {
+        if t.b.tokens < 1 { return t.reason, false }
+    }
+    for _, t := range takes { t.b.tokens-- }
+    return "", true
+}
+
+func (l *rateLimiter) refill(key string, r Rate, now time.Time) *bucket {
+    b, ok := l.buckets[key]
+    if !ok {
+        if len(l.buckets) >= maxBuckets { l.gc(now) }
+        b = &bucket{rate: r, last: now}
+        b.tokens = b.burst()
+        l.buckets[key] = b
+        return b
+    }
+    if now.After(b.last) {
+        b.tokens += now.Sub(b.last).Seconds() * r.PerSecond
+        if b.tokens > b.burst() { b.tokens = b.burst() }
+        b.last = now
+    }
+    return b
+}
+
+// gc drops buckets that have refilled completely (forgetting them is
+// lossless), then arbitrary ones if the map is still too large.
+func (l *rateLimiter) gc(now time.Time) {
+    for k, b := range l.buckets {
+        if b.tokens+now.Sub(b.last).Seconds()*b.rate.PerSecond >= b.burst() { delete(l.buckets, k) }
+    }
+    for k := range l.buckets {
+        if len(l.buckets) < maxBuckets/2 { return }
+        delete(l.buckets, k)
+    }
+}
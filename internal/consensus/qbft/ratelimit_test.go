@@ -0,0 +1,105 @@
+package qbft
+
+import (
+    "errors"
+    "strconv"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/zmlAEQ/Aequa-network/pkg/metrics"
+)
+
+func TestBasicVerifier_RateLimit_PerSender(t *testing.T) {
+    metrics.Reset()
+    now := time.Unix(0, 0)
+    v := NewBasicVerifier()
+    v.SetRateLimits(RateLimits{PerSender: Rate{PerSecond: 1, Burst: 2}}, func() time.Time { return now })
+    send := func(from, id string) error { return v.Verify(Message{ID: id, From: from, Type: MsgPrepare, Round: 1}) }
+    if send("p", "1") != nil || send("p", "2") != nil { t.Fatalf("burst should pass") }
+    if err := send("p", "3"); err == nil { t.Fatalf("want rate_limited") }
+    if err := send("q", "4"); err != nil { t.Fatalf("other senders unaffected: %v", err) }
+    now = now.Add(time.Second)
+    if err := send("p", "5"); err != nil { t.Fatalf("bucket should refill: %v", err) }
+    dump := metrics.DumpProm()
+    if !strings.Contains(dump, `qbft_msg_verified_total{result="rate_limited"} 1`) || !strings.Contains(dump, `qbft_rule_rejected_total{rule="rate_limit"} 1`) {
+        t.Fatalf("missing rate_limited metrics: %q", dump)
+    }
+}
+
+// A flood of one type does not starve the sender's other message types.
+func TestBasicVerifier_RateLimit_PerSenderType(t *testing.T) {
+    metrics.Reset()
+    now := time.Unix(0, 0)
+    limits := RateLimits{PerSender: Rate{PerSecond: 10, Burst: 10}, PerSenderType: Rate{PerSecond: 1, Burst: 1}}
+    if NewBasicVerifierWithPolicy(Policy{RateLimits: limits}).limiter == nil { t.Fatalf("policy limits not applied") }
+    v := NewBasicVerifier()
+    v.SetRateLimits(limits, func() time.Time { return now })
+    for i := 0; i < 5; i++ { _ = v.Verify(Message{ID: "p" + strconv.Itoa(i), From: "x", Type: MsgPrepare, Round: 1}) }
+    if err := v.Verify(Message{ID: "c", From: "x", Type: MsgCommit, Round: 1}); err != nil { t.Fatalf("commit starved: %v", err) }
+    if !strings.Contains(metrics.DumpProm(), `qbft_msg_verified_total{result="rate_limited"} 4`) { t.Fatalf("want 4 prepares limited") }
+}
+
+// Replays are rejected before they reach the limiter, so they cost no tokens.
+func TestBasicVerifier_RateLimit_AfterReplay(t *testing.T) {
+    metrics.Reset()
+    now := time.Unix(0, 0)
+    v := NewBasicVerifier()
+    v.SetRateLimits(RateLimits{PerSender: Rate{PerSecond: 1, Burst: 2}}, func() time.Time { return now })
+    m := Message{ID: "1", From: "p", Type: MsgPrepare}
+    if err := v.Verify(m); err != nil { t.Fatalf("first: %v", err) }
+    for i := 0; i < 5; i++ { _ = v.Verify(m) }
+    if err := v.Verify(Message{ID: "2", From: "p", Type: MsgPrepare}); err != nil { t.Fatalf("replays drained the bucket: %v", err) }
+    dump := metrics.DumpProm()
+    if !strings.Contains(dump, `qbft_msg_verified_total{result="replay"} 5`) || strings.Contains(dump, `result="rate_limited"`) {
+        t.Fatalf("want 5 replays and no rate limiting: %q", dump)
+    }
+}
+
+// A throttled message was never accepted, so its resend is not a replay.
+func TestBasicVerifier_RateLimit_ResendNotReplay(t *testing.T) {
+    metrics.Reset()
+    now := time.Unix(0, 0)
+    v := NewBasicVerifier()
+    v.SetRateLimits(RateLimits{PerSender: Rate{PerSecond: 1, Burst: 1}}, func() time.Time { return now })
+    if err := v.Verify(Message{ID: "1", From: "p", Type: MsgPrepare}); err != nil { t.Fatalf("first: %v", err) }
+    m := Message{ID: "2", From: "p", Type: MsgPrepare}
+    if err := v.Verify(m); err == nil { t.Fatalf("want rate_limited") }
+    now = now.Add(time.Second)
+    if err := v.Verify(m); err != nil { t.Fatalf("resend after refill: %v", err) }
+    if err := v.Verify(m); err == nil { t.Fatalf("accepted message must replay") }
+    if strings.Contains(metrics.DumpProm(), `qbft_msg_verified_total{result="replay"} 2`) { t.Fatalf("resend counted as replay") }
+}
+
+// Ids rejected by a custom rule after replay are not remembered either.
+func TestBasicVerifier_CustomRuleRejection_NotRecorded(t *testing.T) {
+    v := NewBasicVerifier()
+    block := true
+    v.Use(RuleFunc("custom", func(Message) error {
+        if block { return errors.New("blocked") }
+        return nil
+    }))
+    m := Message{ID: "1", From: "p", Type: MsgPrepare}
+    if err := v.Verify(m); err == nil { t.Fatalf("want custom rejection") }
+    block = false
+    if err := v.Verify(m); err != nil { t.Fatalf("resend rejected: %v", err) }
+}
+
+func TestRateLimiter_BoundedBuckets(t *testing.T) {
+    now := time.Unix(0, 0)
+    l := newRateLimiter(RateLimits{PerSender: Rate{PerSecond: 1, Burst: 1}}, func() time.Time { return now })
+    for i := 0; i < 3*maxBuckets; i++ { l.allow(Message{From: "r" + strconv.Itoa(i)}) }
+    if n := len(l.buckets); n > maxBuckets { t.Fatalf("buckets unbounded: %d", n) }
+}
+
+// Exempt senders (the node itself) are never throttled; others still are.
+func TestBasicVerifier_RateLimit_ExemptSelf(t *testing.T) {
+    now := time.Unix(0, 0)
+    v := NewBasicVerifier()
+    v.SetRateLimits(RateLimits{PerSender: Rate{PerSecond: 1, Burst: 1}, Exempt: []string{"self"}}, func() time.Time { return now })
+    for i := 0; i < 10; i++ {
+        if err := v.Verify(Message{ID: "s" + strconv.Itoa(i), From: "self", Type: MsgPrepare}); err != nil { t.Fatalf("own message %d limited: %v", i, err) }
+    }
+    _ = v.Verify(Message{ID: "p1", From: "p", Type: MsgPrepare})
+    if err := v.Verify(Message{ID: "p2", From: "p", Type: MsgPrepare}); err == nil { t.Fatalf("peer not limited") }
+}
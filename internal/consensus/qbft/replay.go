@@ -0,0 +1,267 @@
+package qbft
+
+import (
+    "container/heap"
+    "sort"
+    "sync"
+
+    "github.com/zmlAEQ/Aequa-network/pkg/metrics"
+)
+
+const (
+    // DefaultReplayMaxEntries caps the ids an AntiReplay cache remembers.
+    DefaultReplayMaxEntries = 1 << 16
+    // DefaultReplayRetain is how many heights below the watermark are
+    // remembered before they are pruned.
+    DefaultReplayRetain = 64
+)
+
+// AntiReplay remembers recently seen message ids with the height they were
+// seen at. Memory is bounded two ways: ids more than Retain heights below the
+// watermark are pruned, and once MaxEntries is reached the heights furthest
+// from the watermark are evicted first, so a flood of unique ids at far-future
+// heights evicts itself rather than the in-flight messages of the current
+// height. Only Advance (decisions) and Restore move the watermark, so a
+// message claiming a far-future height cannot prune the window either. Size
+// and evictions are exported as qbft_replay_entries and
+// qbft_replay_evictions_total{reason}.
+type AntiReplay struct {
+    mu      sync.Mutex
+    max     int
+    retain  uint64
+    high    uint64
+    ids     map[string]uint64
+    buckets map[uint64]map[string]struct{}
+    // low and top index bucket heights from both ends. Entries of buckets
+    // removed from the other end are skipped lazily.
+    low     heightHeap
+    top     maxHeightHeap
+}
+
+// NewAntiReplay returns a cache with the default bounds.
+func NewAntiReplay() *AntiReplay { return NewAntiReplayWithLimits(0, 0) }
+
+// NewAntiReplayWithLimits returns a cache holding at most max ids within
+// retain heights of the watermark (zero selects the defaults).
+func NewAntiReplayWithLimits(max int, retain uint64) *AntiReplay {
+    if max <= 0 { max = DefaultReplayMaxEntries }
+    if retain == 0 { retain = DefaultReplayRetain }
+    return &AntiReplay{max: max, retain: retain, ids: make(map[string]uint64), buckets: make(map[uint64]map[string]struct{})}
+}
+
+// Seen returns true if id already seen; otherwise records it at the current
+// watermark and returns false.
+func (r *AntiReplay) Seen(id string) bool {
+    if id == "" { return false }
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    return r.seenAt(id, r.high)
+}
+
+// SeenAt returns true if id was already seen; otherwise records it at height h.
+func (r *AntiReplay) SeenAt(id string, h uint64) bool {
+    if id == "" { return false }
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    return r.seenAt(id, h)
+}
+
+// SeenWithin returns true if id was seen within the given height window.
+// Otherwise it (re)records id at height h.
+func (r *AntiReplay) SeenWithin(id string, h, window uint64) bool {
+    if id == "" || window == 0 { return false }
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    if last, ok := r.ids[id]; ok {
+        if h >= last && h-last <= window { return true }
+        r.remove(id, last)
+    }
+    r.record(id, h)
+    return false
+}
+
+// Known reports whether id was seen, without recording it. With a non-zero
+// window only a sighting within window heights below h counts, matching
+// SeenWithin.
+func (r *AntiReplay) Known(id string, h, window uint64) bool {
+    if id == "" { return false }
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    last, ok := r.ids[id]
+    if !ok { return false }
+    return window == 0 || (h >= last && h-last <= window)
+}
+
+// Advance raises the height watermark to a decided height and prunes ids
+// that fell out of the retained range.
+func (r *AntiReplay) Advance(h uint64) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    if h > r.high { r.high = h }
+    r.prune()
+    metrics.SetGauge("qbft_replay_entries", nil, int64(len(r.ids)))
+}
+
+// Len returns the number of remembered ids.
+func (r *AntiReplay) Len() int {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    return len(r.ids)
+}
+
+// Snapshot returns the remembered ids and their heights.
+func (r *AntiReplay) Snapshot() map[string]uint64 {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    out := make(map[string]uint64, len(r.ids))
+    for id, h := range r.ids { out[id] = h }
+    return out
+}
+
+// Watermark returns the highest height advanced to.
+func (r *AntiReplay) Watermark() uint64 {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    return r.high
+}
+
+// Restore loads a persisted window (see Snapshot and Watermark). Entries are
+// re-recorded lowest height first, so the cache bounds still apply.
+func (r *AntiReplay) Restore(ids map[string]uint64, high uint64) {
+    type entry struct {
+        id string
+        h  uint64
+    }
+    es := make([]entry, 0, len(ids))
+    for id, h := range ids { es = append(es, entry{id, h}) }
+    sort.Slice(es, func(i, j int) bool {
+        if es[i].h != es[j].h { return es[i].h < es[j].h }
+        return es[i].id < es[j].id
+    })
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    if high > r.high { r.high = high }
+    for _, e := range es {
+        if _, ok := r.ids[e.id]; !ok { r.record(e.id, e.h) }
+    }
+    r.prune()
+    metrics.SetGauge("qbft_replay_entries", nil, int64(len(r.ids)))
+}
+
+func (r *AntiReplay) seenAt(id string, h uint64) bool {
+    if _, ok := r.ids[id]; ok { return true }
+    r.record(id, h)
+    return false
+}
+
+func (r *AntiReplay) record(id string, h uint64) {
+    // Ids already below the watermark would be pruned immediately.
+    if h < r.high && r.high-h > r.retain { return }
+    b, ok := r.buckets[h]
+    if !ok {
+        b = make(map[string]struct{})
+        r.buckets[h] = b
+        heap.Push(&r.low, h)
+        heap.Push(&r.top, h)
+        r.compact()
+    }
+    b[id] = struct{}{}
+    r.ids[id] = h
+    r.prune()
+    for len(r.ids) > r.max { r.evictFurthest() }
+    metrics.SetGauge("qbft_replay_entries", nil, int64(len(r.ids)))
+}
+
+func (r *AntiReplay) remove(id string, h uint64) {
+    delete(r.ids, id)
+    if b, ok := r.buckets[h]; ok { delete(b, id) }
+}
+
+// prune drops whole buckets below the watermark.
+func (r *AntiReplay) prune() {
+    for {
+        h, ok := r.lowest()
+        if !ok || h >= r.high || r.high-h <= r.retain { return }
+        heap.Pop(&r.low)
+        n := 0
+        for id := range r.buckets[h] {
+            if r.ids[id] == h { delete(r.ids, id); n++ }
+        }
+        delete(r.buckets, h)
+        for ; n > 0; n-- { metrics.Inc("qbft_replay_evictions_total", map[string]string{"reason": "height"}) }
+    }
+}
+
+// evictFurthest drops one id from the remembered height furthest from the
+// watermark, preferring heights above it on a tie.
+func (r *AntiReplay) evictFurthest() {
+    lo, ok := r.lowest()
+    if !ok { return }
+    hi, _ := r.highest()
+    h := hi
+    if r.distance(lo) > r.distance(hi) { h = lo }
+    b := r.buckets[h]
+    for id := range b {
+        delete(b, id)
+        delete(r.ids, id)
+        metrics.Inc("qbft_replay_evictions_total", map[string]string{"reason": "capacity"})
+        break
+    }
+    if len(b) == 0 { delete(r.buckets, h) }
+}
+
+// distance returns how far h is from the watermark.
+func (r *AntiReplay) distance(h uint64) uint64 {
+    if h >= r.high { return h - r.high }
+    return r.high - h
+}
+
+// lowest returns the lowest bucket height, dropping stale heap entries.
+func (r *AntiReplay) lowest() (uint64, bool) {
+    for r.low.Len() > 0 {
+        if h := r.low[0]; r.buckets[h] != nil { return h, true }
+        heap.Pop(&r.low)
+    }
+    return 0, false
+}
+
+// highest returns the highest bucket height, dropping stale heap entries.
+func (r *AntiReplay) highest() (uint64, bool) {
+    for r.top.Len() > 0 {
+        if h := r.top.heightHeap[0]; r.buckets[h] != nil { return h, true }
+        heap.Pop(&r.top)
+    }
+    return 0, false
+}
+
+// compact rebuilds both heaps once stale entries outnumber live buckets, so
+// buckets repeatedly created and evicted cannot grow them without bound.
+func (r *AntiReplay) compact() {
+    if r.low.Len()+r.top.Len() <= 4*len(r.buckets)+16 { return }
+    r.low, r.top.heightHeap = r.low[:0], r.top.heightHeap[:0]
+    for h := range r.buckets {
+        r.low = append(r.low, h)
+        r.top.heightHeap = append(r.top.heightHeap, h)
+    }
+    heap.Init(&r.low)
+    heap.Init(&r.top)
+}
+
+// heightHeap is a min-heap of bucket heights.
+type heightHeap []uint64
+
+func (h heightHeap) Len() int            { return len(h) }
+func (h heightHeap) Less(i, j int) bool  { return h[i] < h[j] }
+func (h heightHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *heightHeap) Push(x any)         { *h = append(*h, x.(uint64)) }
+func (h *heightHeap) Pop() any {
+    old := *h
+    x := old[len(old)-1]
+    *h = old[:len(old)-1]
+    return x
+}
+
+// maxHeightHeap is a max-heap of bucket heights.
+type maxHeightHeap struct{ heightHeap }
+
+func (h maxHeightHeap) Less(i, j int) bool { return h.heightHeap[i] > h.heightHeap[j] }
@@ -0,0 +1,113 @@
+package qbft
+
+import (
+    "runtime"
+    "strconv"
+    "strings"
+    "testing"
+
+    "github.com/zmlAEQ/Aequa-network/pkg/metrics"
+)
+
+func TestAntiReplay_PrunesBelowHeightWatermark(t *testing.T) {
+    metrics.Reset()
+    r := NewAntiReplayWithLimits(0, 2)
+    if r.SeenAt("a", 1) || !r.SeenAt("a", 1) { t.Fatalf("first sighting must record, second must hit") }
+    r.SeenAt("b", 2)
+    r.SeenAt("c", 4)
+    r.Advance(4) // watermark 4 retains heights 2..4
+    if r.Len() != 2 { t.Fatalf("want height-1 id pruned, len=%d", r.Len()) }
+    if r.SeenAt("x", 1) || r.Len() != 2 { t.Fatalf("ids below the watermark are not remembered") }
+    r.Advance(10)
+    if r.Len() != 0 { t.Fatalf("advance should prune everything, len=%d", r.Len()) }
+    dump := metrics.DumpProm()
+    if !strings.Contains(dump, `qbft_replay_evictions_total{reason="height"} 3`) || !strings.Contains(dump, "qbft_replay_entries 0") {
+        t.Fatalf("missing replay metrics: %q", dump)
+    }
+}
+
+func TestAntiReplay_CapacityEvictsFurthestHeightFirst(t *testing.T) {
+    metrics.Reset()
+    r := NewAntiReplayWithLimits(3, 100)
+    r.Advance(5)
+    r.SeenAt("old", 1)
+    for i := 0; i < 3; i++ { r.SeenAt("new"+strconv.Itoa(i), 5) }
+    if r.Len() != 3 { t.Fatalf("cap not enforced: %d", r.Len()) }
+    if _, ok := r.Snapshot()["old"]; ok { t.Fatalf("height furthest from the watermark should be evicted first") }
+    if !strings.Contains(metrics.DumpProm(), `qbft_replay_evictions_total{reason="capacity"} 1`) {
+        t.Fatalf("missing capacity eviction metric")
+    }
+}
+
+// A flood of unique ids at far-future heights, at capacity, evicts itself and
+// not the in-flight ids of the current height, so their replays stay rejected.
+func TestAntiReplay_FutureFloodAtCapacityKeepsCurrentHeight(t *testing.T) {
+    r := NewAntiReplayWithLimits(8, 4)
+    r.Advance(10)
+    current := []string{"pp-11", "prep-11-a", "prep-11-b", "commit-11-a"}
+    for _, id := range current { r.SeenAt(id, 11) }
+    for i := 0; i < 10000; i++ { r.SeenAt("flood-"+strconv.Itoa(i), 12+uint64(i%50)) }
+    if r.Len() > 8 { t.Fatalf("cap not enforced: %d", r.Len()) }
+    for _, id := range current {
+        if !r.SeenAt(id, 11) { t.Fatalf("replay of current-height %s accepted after flood", id) }
+    }
+    if n := r.low.Len() + r.top.Len(); n > 4*8+16+2 { t.Fatalf("height heaps grew under churn: %d", n) }
+}
+
+func TestAntiReplay_SeenWithinKeepsWindowSemantics(t *testing.T) {
+    r := NewAntiReplay()
+    if r.SeenWithin("m", 10, 2) { t.Fatalf("first sighting") }
+    if !r.SeenWithin("m", 12, 2) { t.Fatalf("within window") }
+    if r.SeenWithin("m", 13, 2) { t.Fatalf("outside window re-records") }
+    if !r.SeenWithin("m", 14, 2) || r.Len() != 1 { t.Fatalf("re-recorded at 13, len=%d", r.Len()) }
+}
+
+// A sustained stream of unique ids (the attacker's pattern) stays bounded.
+func TestAntiReplay_BoundedUnderUniqueIDStream(t *testing.T) {
+    r := NewAntiReplayWithLimits(1000, 8)
+    for i := 0; i < 50000; i++ {
+        r.SeenAt("rand-"+strconv.Itoa(i), uint64(i/5000))
+        if r.Len() > 1000 { t.Fatalf("cache grew past cap at %d: %d", i, r.Len()) }
+    }
+}
+
+func benchmarkUniqueStream(b *testing.B, perHeight int) {
+    r := NewAntiReplayWithLimits(4096, 16)
+    var before, after runtime.MemStats
+    runtime.GC()
+    runtime.ReadMemStats(&before)
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ { r.SeenAt("id-"+strconv.Itoa(i), uint64(i/perHeight)) }
+    b.StopTimer()
+    runtime.GC()
+    runtime.ReadMemStats(&after)
+    b.ReportMetric(float64(r.Len()), "entries")
+    b.ReportMetric(float64(int64(after.HeapAlloc)-int64(before.HeapAlloc))/1024, "heap-KiB")
+}
+
+// Entries and retained heap stay flat as b.N grows; compare runs with -benchtime.
+func BenchmarkAntiReplay_UniqueIDs_SameHeight(b *testing.B)      { benchmarkUniqueStream(b, 1<<30) }
+func BenchmarkAntiReplay_UniqueIDs_AdvancingHeight(b *testing.B) { benchmarkUniqueStream(b, 100) }
+
+func TestAntiReplay_RestoreAppliesBounds(t *testing.T) {
+    src := NewAntiReplay()
+    for i := 0; i < 10; i++ { src.SeenAt("id"+strconv.Itoa(i), uint64(i)) }
+    src.Advance(9)
+    dst := NewAntiReplayWithLimits(0, 3)
+    dst.Restore(src.Snapshot(), src.Watermark())
+    if dst.Watermark() != 9 || dst.Len() != 4 { t.Fatalf("restore: high=%d len=%d", dst.Watermark(), dst.Len()) }
+    if !dst.SeenAt("id9", 9) || dst.SeenAt("id1", 1) { t.Fatalf("restored window mismatch") }
+}
+
+// A message claiming a far-future height neither moves the watermark nor
+// prunes the ids it protects.
+func TestAntiReplay_FarFutureHeightKeepsWindow(t *testing.T) {
+    r := NewAntiReplayWithLimits(0, 2)
+    r.Advance(5)
+    r.SeenAt("a", 5)
+    if r.SeenAt("far", 1<<62) { t.Fatalf("first sighting") }
+    if r.Watermark() != 5 { t.Fatalf("watermark moved to %d", r.Watermark()) }
+    if !r.SeenAt("a", 5) || !r.SeenAt("far", 1<<62) { t.Fatalf("ids must stay remembered") }
+    r.Advance(8)
+    if r.SeenAt("a", 5) || r.Len() != 1 { t.Fatalf("decided watermark still prunes, len=%d", r.Len()) }
+}
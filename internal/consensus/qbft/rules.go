@@ -0,0 +1,128 @@
+package qbft
+
+import (
+    "errors"
+    "fmt"
+    "sync"
+
+    "github.com/zmlAEQ/Aequa-network/pkg/logger"
+    "github.com/zmlAEQ/Aequa-network/pkg/metrics"
+)
+
+// Rule is one named verification step. Check returns nil to accept msg. A
+// *RuleError selects the result label and log reason; any other error is
+// reported as result="error" with the rule name as reason.
+type Rule interface {
+    Name() string
+    Check(msg Message) error
+}
+
+// RuleError is a rule rejection with its metric result label and log reason.
+type RuleError struct {
+    Result string
+    Reason string
+    Msg    string
+    Fields map[string]any
+}
+
+func (e *RuleError) Error() string { return e.Msg }
+
+func reject(result, reason, msg string, fields map[string]any) *RuleError {
+    return &RuleError{Result: result, Reason: reason, Msg: msg, Fields: fields}
+}
+
+type ruleFunc struct {
+    name string
+    fn   func(Message) error
+}
+
+func (r ruleFunc) Name() string            { return r.name }
+func (r ruleFunc) Check(msg Message) error { return r.fn(msg) }
+
+// RuleFunc adapts a function into a named Rule.
+func RuleFunc(name string, fn func(Message) error) Rule { return ruleFunc{name: name, fn: fn} }
+
+// PayloadSizeRule rejects messages whose payload exceeds max bytes.
+func PayloadSizeRule(max int) Rule {
+    return RuleFunc("payload_size", func(msg Message) error {
+        if len(msg.Payload) > max {
+            return reject("error", "payload_too_large", "payload too large", map[string]any{"size": len(msg.Payload), "max": max})
+        }
+        return nil
+    })
+}
+
+// Pipeline runs rules in order and stops at the first rejection. Every
+// rejection is counted in qbft_msg_verified_total{result} and in
+// qbft_rule_rejected_total{rule}, whose label is the stable rule name.
+type Pipeline struct {
+    mu    sync.RWMutex
+    rules []Rule
+}
+
+// NewPipeline returns a pipeline running rules in the given order.
+func NewPipeline(rules ...Rule) *Pipeline { return &Pipeline{rules: append([]Rule(nil), rules...)} }
+
+// Use appends r to the end of the pipeline.
+func (p *Pipeline) Use(r Rule) {
+    p.mu.Lock()
+    p.rules = append(p.rules, r)
+    p.mu.Unlock()
+}
+
+// InsertBefore places r before the rule called name.
+func (p *Pipeline) InsertBefore(name string, r Rule) error {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    for i, x := range p.rules {
+        if x.Name() == name {
+            p.rules = append(p.rules[:i], append([]Rule{r}, p.rules[i:]...)...)
+            return nil
+        }
+    }
+    return fmt.Errorf("unknown rule %q", name)
+}
+
+// Names returns the rule names in execution order.
+func (p *Pipeline) Names() []string {
+    p.mu.RLock()
+    defer p.mu.RUnlock()
+    out := make([]string, len(p.rules))
+    for i, r := range p.rules { out[i] = r.Name() }
+    return out
+}
+
+// Without returns a copy of the pipeline that skips the named rules.
+func (p *Pipeline) Without(names ...string) *Pipeline {
+    p.mu.RLock()
+    defer p.mu.RUnlock()
+    out := NewPipeline()
+    for _, r := range p.rules {
+        skip := false
+        for _, n := range names { if r.Name() == n { skip = true; break } }
+        if !skip { out.rules = append(out.rules, r) }
+    }
+    return out
+}
+
+// Verify runs the pipeline on msg.
+func (p *Pipeline) Verify(msg Message) error {
+    p.mu.RLock()
+    rules := p.rules
+    p.mu.RUnlock()
+    for _, r := range rules {
+        err := r.Check(msg)
+        if err == nil { continue }
+        var re *RuleError
+        if !errors.As(err, &re) { re = reject("error", r.Name(), err.Error(), nil) }
+        metrics.Inc("qbft_msg_verified_total", map[string]string{"result": re.Result})
+        metrics.Inc("qbft_rule_rejected_total", map[string]string{"rule": r.Name()})
+        fields := map[string]any{"result": re.Result, "reason": re.Reason, "rule": r.Name(), "id": msg.ID, "from": msg.From, "type": string(msg.Type), "height": msg.Height, "round": msg.Round, "trace_id": msg.TraceID}
+        for k, v := range re.Fields { fields[k] = v }
+        logger.ErrorJ("qbft_verify", fields)
+        return err
+    }
+    metrics.Inc("qbft_msg_verified_total", map[string]string{"type": string(msg.Type)})
+    logger.InfoJ("qbft_verify", map[string]any{"result":"ok", "id": msg.ID, "type": string(msg.Type), "trace_id": msg.TraceID})
+    return nil
+}
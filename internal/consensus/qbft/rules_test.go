@@ -0,0 +1,49 @@
+package qbft
+
+import (
+    "errors"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/zmlAEQ/Aequa-network/pkg/metrics"
+)
+
+func TestPipeline_DefaultOrderHasEachRuleOnce(t *testing.T) {
+    names := NewBasicVerifier().Pipeline().Names()
+    want := "structure,allowlist,payload,content_id,leader,signature,preprepare_round,prepared_cert,min_height,round_window,replay,vote_round,type_min_height,type_round_max,rate_limit"
+    if strings.Join(names, ",") != want || strings.Join(DefaultRuleOrder, ",") != want { t.Fatalf("unexpected order: %v", names) }
+}
+
+// Custom rules plug in without forking the verifier and report their own label.
+func TestPipeline_CustomRules(t *testing.T) {
+    metrics.Reset()
+    now := time.Unix(100, 0)
+    fresh := RuleFunc("timestamp", func(m Message) error {
+        if len(m.Payload) == 0 { return nil }
+        if time.Unix(int64(m.Payload[0]), 0).Before(now.Add(-time.Minute)) { return errors.New("stale timestamp") }
+        return nil
+    })
+    v := NewBasicVerifierWithPolicy(Policy{Rules: []Rule{PayloadSizeRule(4), fresh}})
+    if err := v.Verify(Message{ID: "big", From: "p", Type: MsgPreprepare, Payload: []byte("12345")}); err == nil { t.Fatalf("want payload_size rejection") }
+    if err := v.Verify(Message{ID: "old", From: "p", Type: MsgPreprepare, Payload: []byte{1}}); err == nil || err.Error() != "stale timestamp" {
+        t.Fatalf("want timestamp rejection, got %v", err)
+    }
+    if err := v.Verify(Message{ID: "ok", From: "p", Type: MsgPreprepare, Payload: []byte{99}}); err != nil { t.Fatalf("unexpected: %v", err) }
+    dump := metrics.DumpProm()
+    for _, want := range []string{`qbft_rule_rejected_total{rule="payload_size"} 1`, `qbft_rule_rejected_total{rule="timestamp"} 1`, `qbft_msg_verified_total{result="error"} 2`} {
+        if !strings.Contains(dump, want) { t.Fatalf("missing %s in %q", want, dump) }
+    }
+}
+
+func TestPipeline_OrderOverride(t *testing.T) {
+    metrics.Reset()
+    // Replay before the structural check and without the vote-round rule.
+    v := NewBasicVerifierWithPolicy(Policy{Order: []string{RuleReplay, RuleStructure, "no_such_rule"}})
+    if got := strings.Join(v.Pipeline().Names(), ","); got != "replay,structure" { t.Fatalf("order: %s", got) }
+    if err := v.Verify(Message{ID: "c0", From: "p", Type: MsgCommit}); err != nil { t.Fatalf("vote_round not configured: %v", err) }
+    if err := v.Verify(Message{ID: "c0", From: "p", Type: MsgCommit}); err == nil { t.Fatalf("want replay") }
+    if err := v.Pipeline().InsertBefore(RuleReplay, PayloadSizeRule(0)); err != nil { t.Fatalf("insert: %v", err) }
+    if err := v.Verify(Message{ID: "c1", From: "p", Type: MsgCommit, Payload: []byte{1}}); err == nil { t.Fatalf("inserted rule not applied") }
+    if v.Pipeline().InsertBefore("missing", PayloadSizeRule(0)) == nil { t.Fatalf("want unknown rule error") }
+}
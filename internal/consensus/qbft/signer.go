@@ -0,0 +1,74 @@
+package qbft
+
+import (
+    "crypto/ed25519"
+    "encoding/hex"
+    "fmt"
+    "os"
+    "strings"
+
+    "github.com/zmlAEQ/Aequa-network/pkg/config"
+)
+
+// Signer signs messages originated by this node.
+type Signer interface {
+    ID() string
+    Sign(msg Message) Message
+}
+
+// KeySigner signs with an ed25519 private key on behalf of operator id.
+type KeySigner struct {
+    id  string
+    key ed25519.PrivateKey
+}
+
+// NewKeySigner returns a signer for operator id.
+func NewKeySigner(id string, key ed25519.PrivateKey) *KeySigner { return &KeySigner{id: id, key: key} }
+
+func (s *KeySigner) ID() string { return s.id }
+
+// Sign sets From to the signer id and Sig to the signature over msg.Digest().
+func (s *KeySigner) Sign(msg Message) Message {
+    msg.From = s.id
+    d := msg.Digest()
+    msg.Sig = ed25519.Sign(s.key, d[:])
+    return msg
+}
+
+// VerifySig reports whether msg.Sig is a valid signature by key.
+func VerifySig(key ed25519.PublicKey, msg Message) bool {
+    if len(key) != ed25519.PublicKeySize || len(msg.Sig) != ed25519.SignatureSize { return false }
+    d := msg.Digest()
+    return ed25519.Verify(key, d[:], msg.Sig)
+}
+
+// KeysFromLock parses the hex-encoded ed25519 public keys of the lock's
+// operators. Operators without a key are skipped.
+func KeysFromLock(l config.ClusterLock) (map[string]ed25519.PublicKey, error) {
+    keys := make(map[string]ed25519.PublicKey, len(l.Operators))
+    for _, op := range l.Operators {
+        if op.PubKey == "" { continue }
+        b, err := hex.DecodeString(strings.TrimPrefix(op.PubKey, "0x"))
+        if err != nil || len(b) != ed25519.PublicKeySize {
+            return nil, fmt.Errorf("operator %s: invalid pubkey", op.PeerID)
+        }
+        keys[op.PeerID] = ed25519.PublicKey(b)
+    }
+    return keys, nil
+}
+
+// LoadPrivateKey reads a hex-encoded ed25519 seed (32 bytes) or private key
+// (64 bytes) from path.
+func LoadPrivateKey(path string) (ed25519.PrivateKey, error) {
+    b, err := os.ReadFile(path)
+    if err != nil { return nil, err }
+    raw, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(string(b)), "0x"))
+    if err != nil { return nil, fmt.Errorf("invalid key encoding") }
+    switch len(raw) {
+    case ed25519.SeedSize:
+        return ed25519.NewKeyFromSeed(raw), nil
+    case ed25519.PrivateKeySize:
+        return ed25519.PrivateKey(raw), nil
+    }
+    return nil, fmt.Errorf("invalid key length %d", len(raw))
+}
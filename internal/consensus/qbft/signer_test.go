@@ -0,0 +1,79 @@
+package qbft
+
+import (
+    "crypto/ed25519"
+    "encoding/hex"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+
+    "github.com/zmlAEQ/Aequa-network/pkg/config"
+    "github.com/zmlAEQ/Aequa-network/pkg/metrics"
+)
+
+func testKey(seed byte) ed25519.PrivateKey {
+    s := make([]byte, ed25519.SeedSize)
+    for i := range s { s[i] = seed }
+    return ed25519.NewKeyFromSeed(s)
+}
+
+func TestKeySigner_SignVerify(t *testing.T) {
+    k := testKey(1)
+    msg := NewKeySigner("p", k).Sign(Message{ID: "m", Type: MsgPrepare, Height: 3, Round: 1, TraceID: "t"})
+    if msg.From != "p" { t.Fatalf("from not stamped: %q", msg.From) }
+    if !VerifySig(k.Public().(ed25519.PublicKey), msg) { t.Fatalf("valid signature rejected") }
+    // trace ids are not signed; consensus fields are
+    msg.TraceID = "other"
+    if !VerifySig(k.Public().(ed25519.PublicKey), msg) { t.Fatalf("trace id must not affect signature") }
+    msg.Round = 2
+    if VerifySig(k.Public().(ed25519.PublicKey), msg) { t.Fatalf("tampered message accepted") }
+}
+
+func TestBasicVerifier_SignatureCrypto(t *testing.T) {
+    metrics.Reset()
+    kp, kq := testKey(1), testKey(2)
+    v := NewBasicVerifierWithPolicy(Policy{Keys: map[string]ed25519.PublicKey{"p": kp.Public().(ed25519.PublicKey), "q": kq.Public().(ed25519.PublicKey)}})
+    good := NewKeySigner("p", kp).Sign(Message{ID: "g", Type: MsgPrepare, Round: 1})
+    if err := v.Verify(good); err != nil { t.Fatalf("signed message: %v", err) }
+    if err := v.Verify(Message{ID: "u", From: "p", Type: MsgPrepare, Round: 1}); err == nil { t.Fatalf("want unsigned rejection") }
+    forged := NewKeySigner("p", kq).Sign(Message{ID: "f", Type: MsgPrepare, Round: 1})
+    if err := v.Verify(forged); err == nil { t.Fatalf("want forged rejection") }
+    // justification signatures are checked as well
+    rc := NewKeySigner("q", kp).Sign(Message{ID: "rc", Type: MsgRoundChange, Round: 1})
+    pp := NewKeySigner("p", kp).Sign(Message{ID: "pp", Type: MsgPreprepare, Round: 1, Justification: []Message{rc}})
+    if err := v.Verify(pp); err == nil { t.Fatalf("want forged justification rejection") }
+    dump := metrics.DumpProm()
+    if !strings.Contains(dump, `qbft_msg_verified_total{result="sig_invalid"} 3`) {
+        t.Fatalf("want sig_invalid=3, got %q", dump)
+    }
+}
+
+func TestKeysFromLock_AndLoadPrivateKey(t *testing.T) {
+    k := testKey(7)
+    pub := hex.EncodeToString(k.Public().(ed25519.PublicKey))
+    keys, err := KeysFromLock(config.ClusterLock{Operators: []config.Operator{{PeerID: "a", PubKey: pub}, {PeerID: "b"}}})
+    if err != nil || len(keys) != 1 { t.Fatalf("keys: %v %v", keys, err) }
+    if _, err := KeysFromLock(config.ClusterLock{Operators: []config.Operator{{PeerID: "a", PubKey: "zz"}}}); err == nil {
+        t.Fatalf("want invalid pubkey error")
+    }
+    path := filepath.Join(t.TempDir(), "node.key")
+    if err := os.WriteFile(path, []byte(hex.EncodeToString(k.Seed())+"\n"), 0o600); err != nil { t.Fatalf("write: %v", err) }
+    got, err := LoadPrivateKey(path)
+    if err != nil || !got.Equal(k) { t.Fatalf("load key: %v", err) }
+}
+
+func TestCommitCertificate_Verify_Signatures(t *testing.T) {
+    ids := []string{"a", "b", "c", "d"}
+    keys := map[string]ed25519.PublicKey{}
+    cert := CommitCertificate{Height: 1, Round: 1, ProposalID: "blk"}
+    for i, id := range ids {
+        k := testKey(byte(i + 1))
+        keys[id] = k.Public().(ed25519.PublicKey)
+        if i < 3 { cert.Commits = append(cert.Commits, NewKeySigner(id, k).Sign(Message{ID: "blk", Type: MsgCommit, Height: 1, Round: 1})) }
+    }
+    vals := NewValidators(ids, 0).WithKeys(keys)
+    if err := cert.Verify(vals); err != nil { t.Fatalf("signed certificate: %v", err) }
+    cert.Commits[1].Sig = cert.Commits[0].Sig
+    if err := cert.Verify(vals); err == nil { t.Fatalf("want bad signature error") }
+}
@@ -0,0 +1,135 @@
+package qbft
+
+import "sort"
+
+// Snapshot is the durable part of a State: enough for a restarted node to
+// resume an instance without voting inconsistently, and with the votes it
+// had counted, so a restore does not depend on peers re-sending them.
+type Snapshot struct {
+    Duty          string
+    Height        uint64
+    Round         uint64
+    Phase         string
+    ProposalID    string
+    Proposal      []byte
+    PreparedRound uint64
+    PreparedID    string
+    PreparedValue []byte
+    // PreparedCert is the quorum of prepares behind PreparedID; roundchanges
+    // claiming the prepared value carry it.
+    PreparedCert []Message
+    // Own holds the messages this node sent at Height, in send order.
+    Own []Message
+    // Votes holds the prepares and commits counted for the current proposal
+    // and the roundchanges received at Height (own ones included), in the
+    // order they were accepted for prepares and commits.
+    Votes []Message
+}
+
+// Snapshotter is implemented by processors whose instances can be persisted
+// and restored (State and InstanceManager).
+type Snapshotter interface {
+    // SnapshotFor returns the snapshot of the instance msg belongs to.
+    SnapshotFor(msg Message) (Snapshot, bool)
+    Restore(sn Snapshot) error
+}
+
+// Snapshot captures the durable state of the instance.
+func (s *State) Snapshot() Snapshot {
+    return Snapshot{
+        Duty:          s.Duty,
+        Height:        s.Height,
+        Round:         s.Round,
+        Phase:         s.Phase,
+        ProposalID:    s.proposalID,
+        Proposal:      s.proposal,
+        PreparedRound: s.preparedRound,
+        PreparedID:    s.preparedID,
+        PreparedValue: s.preparedValue,
+        PreparedCert:  append([]Message(nil), s.preparedCert...),
+        Own:           append([]Message(nil), s.own...),
+        Votes:         s.votes(),
+    }
+}
+
+// votes returns the counted prepares and commits followed by the
+// roundchanges ordered by round, then sender.
+func (s *State) votes() []Message {
+    out := append(append([]Message(nil), s.prepares...), s.commits...)
+    rounds := make([]uint64, 0, len(s.roundChanges))
+    for r := range s.roundChanges { rounds = append(rounds, r) }
+    sort.Slice(rounds, func(i, j int) bool { return rounds[i] < rounds[j] })
+    for _, r := range rounds {
+        from := make([]string, 0, len(s.roundChanges[r]))
+        for f := range s.roundChanges[r] { from = append(from, f) }
+        sort.Strings(from)
+        for _, f := range from { out = append(out, s.roundChanges[r][f]) }
+    }
+    return out
+}
+
+// SnapshotFor implements Snapshotter for a single State.
+func (s *State) SnapshotFor(msg Message) (Snapshot, bool) {
+    if !s.started || msg.Height != s.Height { return Snapshot{}, false }
+    return s.Snapshot(), true
+}
+
+// Restore resumes the instance from sn. The round timer restarts from now;
+// own messages are remembered so the node never contradicts them, and the
+// snapshot's votes count again towards their quorums.
+func (s *State) Restore(sn Snapshot) error {
+    s.resetHeight(sn.Height)
+    if sn.Duty != "" { s.Duty = sn.Duty }
+    s.Round = sn.Round
+    if s.LeaderFn != nil { s.Leader = s.LeaderFn(s.Height, s.Round) }
+    s.Phase = sn.Phase
+    s.proposalID, s.proposal = sn.ProposalID, sn.Proposal
+    s.preparedRound, s.preparedID, s.preparedValue = sn.PreparedRound, sn.PreparedID, sn.PreparedValue
+    s.preparedCert = append([]Message(nil), sn.PreparedCert...)
+    if s.proposalID != "" {
+        s.prepareVotes = make(map[string]struct{})
+        s.commitVotes = make(map[string]struct{})
+    }
+    for _, m := range sn.Own {
+        s.own = append(s.own, m)
+        if m.Type == MsgPreprepare { s.proposed[m.Round] = true }
+    }
+    for _, m := range sn.Votes { s.restoreVote(m) }
+    s.roundStart = s.now()
+    return nil
+}
+
+// restoreVote counts a vote from a snapshot as Process accepted it.
+func (s *State) restoreVote(m Message) {
+    k := equivKey{from: m.From, typ: m.Type, round: m.Round}
+    if _, ok := s.sent[k]; !ok { s.sent[k] = m }
+    switch m.Type {
+    case MsgPrepare:
+        if s.prepareVotes == nil { return }
+        s.prepareVotes[m.From] = struct{}{}
+        s.prepares = append(s.prepares, m)
+    case MsgCommit:
+        if s.commitVotes == nil { return }
+        s.commitVotes[m.From] = struct{}{}
+        s.commits = append(s.commits, m)
+    case MsgRoundChange:
+        votes := s.roundChanges[m.Round]
+        if votes == nil {
+            votes = make(map[string]Message)
+            s.roundChanges[m.Round] = votes
+        }
+        votes[m.From] = m
+    }
+}
+
+// ownSent reports whether this node already sent msg, or something that
+// contradicts it for the same type and round (e.g. before a restart).
+func (s *State) ownSent(msg Message) (sent, conflict bool) {
+    for _, m := range s.own {
+        if m.Type != msg.Type || m.Round != msg.Round { continue }
+        if m.ID != msg.ID { return false, true }
+        sent = true
+    }
+    return sent, false
+}
+
@@ -2,22 +2,82 @@ package qbft
 
 import (
     "fmt"
+    "sort"
+    "time"
+
+    "github.com/zmlAEQ/Aequa-network/internal/consensus/payload"
     "github.com/zmlAEQ/Aequa-network/pkg/logger"
     "github.com/zmlAEQ/Aequa-network/pkg/metrics"
 )
 
+// Minimal thresholds used when no operator set is configured.
+const (
+    minPrepareVotes     = 2
+    minCommitVotes      = 1
+    minRoundChangeVotes = 2
+)
+
 // State represents a minimal QBFT state snapshot.
 // This is a skeleton for M3: it carries only coordinates and a textual phase.
 type State struct {
     Height uint64
     Round  uint64
-    Phase  string // e.g., "idle|preprepared|prepared|commit" (placeholder)
+    Phase  string // e.g., "idle|preprepared|prepared|commit|roundchange" (placeholder)
     Leader string // placeholder leader id for current round
+    Duty   string // duty this instance decides on; stamped on own messages
+
+    // Self and Broadcast enable active mode: the state emits its own
+    // prepare/commit/roundchange (and preprepare when it leads a round).
+    // Broadcast is expected to deliver to all nodes, including Self.
+    Self      string
+    Broadcast func(Message)
+    // Signer, if set, signs own messages before they are broadcast.
+    Signer Signer
+    // LeaderFn, if set, selects the leader whenever a new round starts.
+    LeaderFn LeaderFunc
+    // Validators sizes quorums from the cluster operator set; when empty the
+    // minimal thresholds above apply.
+    Validators Validators
+    // Timer drives round changes via Tick; the zero value disables timeouts.
+    Timer RoundTimer
+    // OnDecided, if set, receives the decision once a commit quorum is reached.
+    OnDecided func(Decided)
+    // OnEvidence, if set, receives proof of any operator equivocating.
+    OnEvidence func(Evidence)
+    // Payloads, if set, validates and canonicalises proposed values; the
+    // proposal digest is then the hash of the canonical bytes.
+    Payloads payload.Manager
+    // ValueValidator, if set, must accept a proposed value before this node
+    // votes for it.
+    ValueValidator ValueValidator
+    // Future bounds the buffer of messages held for later rounds/heights.
+    Future FutureLimits
+    // Now overrides the clock used to stamp round starts (tests/simulation).
+    Now func() time.Time
 
     // Minimal aggregation placeholders for M3
     proposalID   string
     prepareVotes map[string]struct{} // by From
     commitVotes  map[string]struct{} // by From
+    commits      []Message           // distinct commits backing the certificate
+    prepares     []Message           // distinct prepares backing the prepared certificate
+
+    proposal      []byte
+    preparedRound uint64
+    preparedID    string
+    preparedValue []byte
+    preparedCert  []Message // quorum of prepares for preparedID at preparedRound
+    roundChanges  map[uint64]map[string]Message // by Round, then From
+    proposed      map[uint64]bool
+    inputID       string
+    input         []byte
+    roundStart    time.Time
+    started       bool
+    sent          map[equivKey]Message
+    own           []Message
+    reported      map[equivKey]bool
+    future        *futureBuffer
+    draining      bool
 }
 
 // Processor defines the minimal interface for driving state transitions.
@@ -25,19 +85,141 @@ type Processor interface {
     Process(msg Message) error
 }
 
-// Process triggers a placeholder state transition based on the incoming message.
-// It does not enforce any real QBFT rules; it only updates coordinates,
-// emits a log, and increments a Prometheus counter for observability.
+// SetInput sets the value this node proposes when it leads a round without
+// a prepared value to carry over.
+func (s *State) SetInput(id string, value []byte) { s.inputID, s.input = id, value }
+
+// Proposal returns the current proposal id and value.
+func (s *State) Proposal() (string, []byte) { return s.proposalID, s.proposal }
+
+// Start begins an instance at the given height in round 0. In active mode the
+// round-0 leader broadcasts its input as preprepare.
+func (s *State) Start(height uint64) error {
+    s.resetHeight(height)
+    s.enterRound(0)
+    s.maybePropose(0, nil)
+    s.drainFuture()
+    return nil
+}
+
+// Proposer is implemented by processors that start an instance for a duty.
+type Proposer interface {
+    // Propose sets this node's input for the instance and starts it: the
+    // leader of the round proposes the input, everyone else waits for the
+    // leader's preprepare (and proposes the input if it leads a later round).
+    Propose(key InstanceKey, value []byte) error
+}
+
+// Propose implements Proposer for a single State. If the instance is already
+// running at key.Height (e.g. peers' messages arrived first) it is not reset;
+// the input is proposed right away when this node leads the current round.
+func (s *State) Propose(key InstanceKey, value []byte) error {
+    id, err := ProposalIDOf(s.Payloads, value)
+    if err != nil { return err }
+    if s.Duty == "" { s.Duty = key.Duty }
+    s.SetInput(id, value)
+    if !s.started || s.Height != key.Height { return s.Start(key.Height) }
+    if s.Phase == "commit" { return nil }
+    if rcs := s.roundChanges[s.Round]; s.Round == 0 || len(rcs) >= s.quorum(minRoundChangeVotes) {
+        s.maybePropose(s.Round, rcs)
+    }
+    s.drainFuture()
+    return nil
+}
+
+// Tick fires the round timer when the current round has been running longer
+// than its timeout. It is a no-op when the timer is disabled or the instance
+// has committed.
+func (s *State) Tick(now time.Time) error {
+    if !s.Timer.Enabled() || s.Phase == "commit" || s.roundStart.IsZero() { return nil }
+    if now.Sub(s.roundStart) < s.Timer.Duration(s.Round) { return nil }
+    return s.OnTimeout(s.Round)
+}
+
+// OnTimeout moves to round+1 and, in active mode, broadcasts a roundchange
+// carrying the highest prepared proposal. Stale timeouts are ignored.
+func (s *State) OnTimeout(round uint64) error {
+    if round != s.Round || s.Phase == "commit" { return nil }
+    s.changeRound("timeout")
+    s.drainFuture()
+    return nil
+}
+
+// changeRound abandons the current round for the next one.
+func (s *State) changeRound(reason string) {
+    next := s.Round + 1
+    s.enterRound(next)
+    metrics.Inc("qbft_round_changes_total", map[string]string{"reason": reason})
+    logger.InfoJ("qbft_state", map[string]any{
+        "op":        "round_change",
+        "reason":    reason,
+        "node":      s.Self,
+        "duty":      s.Duty,
+        "height":    s.Height,
+        "round":     s.Round,
+        "leader":    s.Leader,
+        "trace_id":  "",
+    })
+    s.sendRoundChange(next)
+}
+
+// sendRoundChange broadcasts a roundchange for round r carrying the lock.
+func (s *State) sendRoundChange(r uint64) {
+    s.send(Message{
+        Type:          MsgRoundChange,
+        Round:         r,
+        PreparedRound: s.preparedRound,
+        PreparedID:    s.preparedID,
+        Payload:       s.preparedValue,
+        Justification: s.preparedCert,
+    })
+}
+
+// Process applies msg to the state machine, then replays buffered messages
+// that became applicable. Messages for a later round or height are buffered
+// (returning nil) rather than applied out of order.
 func (s *State) Process(msg Message) error {
-    // Lightweight, non-authoritative update of coordinates for visibility.
-    s.Height = msg.Height
-    s.Round = msg.Round
+    h, r, ph := s.Height, s.Round, s.Phase
+    err := s.process(msg)
+    if s.Height != h || s.Round != r || s.Phase != ph { s.drainFuture() }
+    return err
+}
+
+func (s *State) process(msg Message) error {
+    // The first message adopts its height; later ones must match or are held.
+    if !s.started {
+        s.resetHeight(msg.Height)
+    }
+    if s.Validators.Size() > 0 && !s.Validators.Contains(msg.From) {
+        return s.reject(msg, "unknown_sender", map[string]any{"from": msg.From})
+    }
+    if msg.Height > s.Height {
+        s.hold(msg, "future_height")
+        return nil
+    }
+    if msg.Height < s.Height {
+        return s.reject(msg, "stale_height", nil)
+    }
+    if (msg.Type == MsgPrepare || msg.Type == MsgCommit) && msg.Round > s.Round {
+        s.hold(msg, "future_round")
+        return nil
+    }
+    if msg.Type == MsgPrepare || msg.Type == MsgCommit {
+        if err := s.checkEquivocation(msg); err != nil { return err }
+    }
     var ok bool
     changed := false // only count/log transition when state actually changes
+    // Decided is terminal: later proposals, prepares and roundchanges for this
+    // height change nothing, so the instance never decides twice. Commits
+    // still go through their checks; they can only extend the certificate.
+    if s.Phase == "commit" && msg.Type != MsgCommit { goto END }
     switch msg.Type {
     case MsgPreprepare:
+        if msg.Round < s.Round {
+            return s.reject(msg, "stale_round", nil)
+        }
         // Placeholder leader validation: if Leader is set, only accept from that id
-        if s.Leader != "" && msg.From != s.Leader {
+        if expect := s.leaderFor(msg.Round); expect != "" && msg.From != expect {
             logger.ErrorJ("qbft_state", map[string]any{
                 "op":        "transition",
                 "event_type": string(msg.Type),
@@ -45,16 +227,32 @@ func (s *State) Process(msg Message) error {
                 "round":     s.Round,
                 "reason":    "unauthorized_leader",
                 "from":      msg.From,
-                "expect":    s.Leader,
+                "expect":    expect,
                 "trace_id":  msg.TraceID,
             })
             return fmt.Errorf("unauthorized leader")
         }
+        if msg.Round > 0 {
+            if err := s.checkJustification(msg); err != nil { return err }
+        }
+        pid, value, err := s.canonicalProposal(msg)
+        if err != nil { return err }
+        if err := s.checkEquivocation(msg); err != nil { return err }
+        if msg.Round > s.Round { s.enterRound(msg.Round) }
+        if err := s.validateValue(value); err != nil {
+            // Never vote for an unacceptable value; move on to the next leader.
+            rerr := s.reject(msg, "invalid_value", map[string]any{"err": err.Error(), "proposal": pid})
+            s.changeRound("invalid_value")
+            return rerr
+        }
         s.Phase = "preprepared"
-        s.proposalID = msg.ID
+        s.proposalID = pid
+        s.proposal = value
         s.prepareVotes = make(map[string]struct{})
         s.commitVotes = make(map[string]struct{})
+        s.commits, s.prepares = nil, nil
         changed = true
+        s.send(Message{Type: MsgPrepare, Round: s.Round, ProposalID: s.proposalID})
     case MsgPrepare:
         // Strict: require preprepare for this proposal first.
         if s.proposalID == "" || (s.Phase != "preprepared" && s.Phase != "prepared") {
@@ -66,16 +264,20 @@ func (s *State) Process(msg Message) error {
                 "reason":    "not_preprepared",
                 "trace_id":  msg.TraceID,
             })
+            s.hold(msg, "early")
             return fmt.Errorf("prepare before preprepared")
         }
-        if msg.ID != s.proposalID {
+        if msg.Round < s.Round {
+            return s.reject(msg, "stale_round", nil)
+        }
+        if ProposalRef(msg) != s.proposalID {
             logger.ErrorJ("qbft_state", map[string]any{
                 "op":        "transition",
                 "event_type": string(msg.Type),
                 "height":    s.Height,
                 "round":     s.Round,
                 "reason":    "proposal_mismatch",
-                "got":       msg.ID,
+                "got":       ProposalRef(msg),
                 "expect":    s.proposalID,
                 "trace_id":  msg.TraceID,
             })
@@ -87,9 +289,15 @@ func (s *State) Process(msg Message) error {
             goto END
         }
         s.prepareVotes[msg.From] = struct{}{}
-        if s.Phase == "preprepared" && len(s.prepareVotes) >= 2 { // minimal threshold
+        s.prepares = append(s.prepares, msg)
+        if s.Phase == "preprepared" && len(s.prepareVotes) >= s.quorum(minPrepareVotes) {
             s.Phase = "prepared"
+            s.preparedRound = s.Round
+            s.preparedID = s.proposalID
+            s.preparedValue = s.proposal
+            s.preparedCert = append([]Message(nil), s.prepares...)
             changed = true
+            s.send(Message{Type: MsgCommit, Round: s.Round, ProposalID: s.proposalID})
             break
         }
         // counted as processed but no phase change if still below threshold
@@ -106,16 +314,20 @@ func (s *State) Process(msg Message) error {
                 "reason":    "not_prepared",
                 "trace_id":  msg.TraceID,
             })
+            if s.Phase == "preprepared" || s.Phase == "" || s.Phase == "roundchange" { s.hold(msg, "early") }
             return fmt.Errorf("commit before prepared")
         }
-        if msg.ID != s.proposalID {
+        if msg.Round < s.Round {
+            return s.reject(msg, "stale_round", nil)
+        }
+        if ProposalRef(msg) != s.proposalID {
             logger.ErrorJ("qbft_state", map[string]any{
                 "op":        "transition",
                 "event_type": string(msg.Type),
                 "height":    s.Height,
                 "round":     s.Round,
                 "reason":    "proposal_mismatch",
-                "got":       msg.ID,
+                "got":       ProposalRef(msg),
                 "expect":    s.proposalID,
                 "trace_id":  msg.TraceID,
             })
@@ -127,11 +339,46 @@ func (s *State) Process(msg Message) error {
             goto END
         }
         s.commitVotes[msg.From] = struct{}{}
-        // Minimal rule: first distinct commit advances to commit phase.
-        if s.Phase != "commit" && len(s.commitVotes) >= 1 {
+        s.commits = append(s.commits, msg)
+        // Commit quorum decides; unconfigured states keep the first-commit rule.
+        if s.Phase != "commit" && len(s.commitVotes) >= s.quorum(minCommitVotes) {
             s.Phase = "commit"
             changed = true
+            s.decide(msg.TraceID)
+        }
+    case MsgRoundChange:
+        if msg.Round < s.Round || msg.Round == 0 {
+            return s.reject(msg, "stale_round", nil)
+        }
+        if msg.PreparedID != "" {
+            if err := s.checkPrepared(msg); err != nil { return err }
+        }
+        votes := s.roundChanges[msg.Round]
+        if votes == nil {
+            votes = make(map[string]Message)
+            s.roundChanges[msg.Round] = votes
+        }
+        if _, ok = votes[msg.From]; ok {
+            goto END
+        }
+        votes[msg.From] = msg
+        if len(votes) < s.quorum(minRoundChangeVotes) {
+            // f+1 roundchanges for higher rounds prove a correct node moved on: skip ahead.
+            if r, ok := s.skipRound(); ok {
+                s.enterRound(r)
+                metrics.Inc("qbft_round_changes_total", map[string]string{"reason": "skip"})
+                changed = true
+                s.sendRoundChange(r)
+            }
+            goto END
+        }
+        // Round-change quorum: move to that round and let its leader propose.
+        if msg.Round > s.Round {
+            s.enterRound(msg.Round)
+            metrics.Inc("qbft_round_changes_total", map[string]string{"reason": "quorum"})
+            changed = true
         }
+        s.maybePropose(msg.Round, votes)
     default:
         // Keep previous phase for unknown types; still record observability.
     }
@@ -142,9 +389,14 @@ END:
         logger.InfoJ("qbft_state", map[string]any{
             "op":        "transition",
             "event_type": string(msg.Type),
+            "node":      s.Self,
+            "duty":      s.Duty,
             "height":    s.Height,
             "round":     s.Round,
             "phase":     s.Phase,
+            "proposal":  s.proposalID,
+            "prepares":  len(s.prepareVotes),
+            "quorum":    s.quorum(minPrepareVotes),
             "trace_id":  msg.TraceID,
         })
     } else {
@@ -161,3 +413,313 @@ END:
     metrics.Inc("qbft_state_transitions_total", map[string]string{"type": string(msg.Type)})
     return nil
 }
+
+// quorum returns the operator-set quorum, or fallback when unconfigured.
+func (s *State) quorum(fallback int) int {
+    if s.Validators.Size() > 0 { return s.Validators.Quorum() }
+    return fallback
+}
+
+// skipRound returns the lowest round above the current one once roundchanges
+// for higher rounds come from f+1 distinct senders, at least one of them honest.
+// f is the BFT bound of the operator set, not n minus the (possibly raised)
+// lock threshold. Unconfigured and decided states never skip.
+func (s *State) skipRound() (uint64, bool) {
+    if s.Validators.Size() == 0 || s.Phase == "commit" { return 0, false }
+    senders := map[string]struct{}{}
+    var lowest uint64
+    for r, votes := range s.roundChanges {
+        if r <= s.Round { continue }
+        for from := range votes { senders[from] = struct{}{} }
+        if lowest == 0 || r < lowest { lowest = r }
+    }
+    if len(senders) < s.Validators.Faulty()+1 { return 0, false }
+    return lowest, true
+}
+
+func (s *State) now() time.Time {
+    if s.Now != nil { return s.Now() }
+    return time.Now()
+}
+
+func (s *State) leaderFor(round uint64) string {
+    if s.LeaderFn != nil { return s.LeaderFn(s.Height, round) }
+    return s.Leader
+}
+
+// resetHeight clears all per-instance state and moves to the given height.
+func (s *State) resetHeight(h uint64) {
+    s.started = true
+    s.Height = h
+    s.Round = 0
+    s.Phase = ""
+    s.proposalID, s.proposal = "", nil
+    s.prepareVotes, s.commitVotes, s.commits, s.prepares = nil, nil, nil, nil
+    s.preparedRound, s.preparedID, s.preparedValue, s.preparedCert = 0, "", nil, nil
+    s.roundChanges = make(map[uint64]map[string]Message)
+    s.proposed = make(map[uint64]bool)
+    s.sent = make(map[equivKey]Message)
+    s.own = nil
+    s.reported = make(map[equivKey]bool)
+    s.roundStart = s.now()
+    if s.LeaderFn != nil { s.Leader = s.LeaderFn(h, 0) }
+}
+
+// validateValue consults the value validator, if any, before voting.
+func (s *State) validateValue(value []byte) error {
+    if s.ValueValidator == nil { return nil }
+    return s.ValueValidator.ValidateValue(s.Duty, s.Height, value)
+}
+
+// canonicalProposal returns the proposal digest and value of a preprepare.
+// With a payload manager the value is canonicalised and the digest derived
+// from it; a declared ProposalID must match.
+func (s *State) canonicalProposal(msg Message) (string, []byte, error) {
+    if s.Payloads == nil { return ProposalRef(msg), msg.Payload, nil }
+    if reason := payloadReason(s.Payloads, msg.Payload); reason != "" {
+        return "", nil, s.reject(msg, reason, map[string]any{"size": len(msg.Payload)})
+    }
+    value, err := s.Payloads.Canonical(msg.Payload)
+    if err != nil { return "", nil, s.reject(msg, "payload_invalid", nil) }
+    pid, err := ProposalIDOf(s.Payloads, value)
+    if err != nil { return "", nil, s.reject(msg, "payload_invalid", nil) }
+    if msg.ProposalID != "" && msg.ProposalID != pid {
+        return "", nil, s.reject(msg, "proposal_id_mismatch", map[string]any{"got": msg.ProposalID, "expect": pid})
+    }
+    return pid, value, nil
+}
+
+// equivKey identifies the single message an operator may send per type and round.
+type equivKey struct {
+    from  string
+    typ   Type
+    round uint64
+}
+
+// checkEquivocation remembers the first proposal/vote of each sender per
+// round and reports a conflicting one as Evidence (once per key).
+func (s *State) checkEquivocation(msg Message) error {
+    k := equivKey{from: msg.From, typ: msg.Type, round: msg.Round}
+    first, ok := s.sent[k]
+    if !ok {
+        s.sent[k] = msg
+        return nil
+    }
+    if ProposalRef(first) == ProposalRef(msg) { return nil }
+    ev := Evidence{Offender: msg.From, Duty: msg.Duty, Height: msg.Height, Round: msg.Round, Type: msg.Type, First: first, Second: msg}
+    if !s.reported[k] {
+        s.reported[k] = true
+        metrics.Inc("qbft_equivocations_total", map[string]string{"type": string(msg.Type)})
+        logger.ErrorJ("qbft_state", map[string]any{
+            "op":        "equivocation",
+            "event_type": string(msg.Type),
+            "height":    s.Height,
+            "round":     msg.Round,
+            "from":      msg.From,
+            "first":     ProposalRef(first),
+            "second":    ProposalRef(msg),
+            "evidence":  ev.ID(),
+            "trace_id":  msg.TraceID,
+        })
+        if s.OnEvidence != nil { s.OnEvidence(ev) }
+    }
+    return s.reject(msg, "equivocation", map[string]any{"from": msg.From})
+}
+
+// hold buffers msg for replay once the instance reaches its height/round.
+func (s *State) hold(msg Message, reason string) {
+    if s.future == nil { s.future = newFutureBuffer(s.Future) }
+    if s.future.add(msg, reason) {
+        logger.InfoJ("qbft_state", map[string]any{
+            "op":        "buffer",
+            "event_type": string(msg.Type),
+            "height":    s.Height,
+            "round":     s.Round,
+            "msg_height": msg.Height,
+            "msg_round": msg.Round,
+            "reason":    reason,
+            "trace_id":  msg.TraceID,
+        })
+    }
+}
+
+// drainFuture evicts stale buffered messages and replays those matching the
+// current height and round until the state stops advancing.
+func (s *State) drainFuture() {
+    if s.future == nil || s.draining { return }
+    s.draining = true
+    defer func() { s.draining = false }()
+    for {
+        s.future.evict(func(m Message) bool {
+            return m.Height < s.Height || ((m.Type == MsgPrepare || m.Type == MsgCommit) && m.Height == s.Height && m.Round < s.Round)
+        }, "stale")
+        h, r, ph := s.Height, s.Round, s.Phase
+        msgs := s.future.take(func(m Message) bool { return m.Height == s.Height && m.Round <= s.Round })
+        if len(msgs) == 0 { return }
+        for _, m := range msgs {
+            metrics.Inc("qbft_future_replayed_total", map[string]string{"type": string(m.Type)})
+            _ = s.process(m)
+        }
+        if s.Height == h && s.Round == r && s.Phase == ph { return }
+    }
+}
+
+// discardFuture drops all buffered messages (instance teardown).
+func (s *State) discardFuture() {
+    if s.future != nil { s.future.evict(func(Message) bool { return true }, "discard") }
+}
+
+// enterRound switches to round r, dropping the current proposal and votes but
+// keeping the prepared proposal (lock) for future roundchange messages.
+func (s *State) enterRound(r uint64) {
+    if r != s.Round {
+        s.Phase = "roundchange"
+        s.proposalID, s.proposal = "", nil
+        s.prepareVotes, s.commitVotes, s.commits, s.prepares = nil, nil, nil, nil
+    }
+    s.Round = r
+    if s.LeaderFn != nil { s.Leader = s.LeaderFn(s.Height, r) }
+    s.roundStart = s.now()
+}
+
+// checkJustification validates that a preprepare for round > 0 carries a
+// roundchange quorum for its duty and round and re-proposes the highest prepared value
+// among them, backed by a quorum of prepares for it.
+func (s *State) checkJustification(msg Message) error {
+    rcs, prepares := splitJustification(msg.Justification)
+    senders := map[string]struct{}{}
+    var valid []Message
+    for _, j := range rcs {
+        if j.Duty != msg.Duty || j.Height != msg.Height || j.Round != msg.Round || !s.member(j) { continue }
+        senders[j.From] = struct{}{}
+        valid = append(valid, j)
+    }
+    if len(senders) < s.quorum(minRoundChangeVotes) {
+        return s.reject(msg, "unjustified", map[string]any{"count": len(senders)})
+    }
+    best := highestPrepared(valid, msg.Duty, msg.Height, msg.Round)
+    if best == nil { return nil }
+    if ProposalRef(msg) != best.PreparedID {
+        return s.reject(msg, "unjustified_value", map[string]any{"got": ProposalRef(msg), "expect": best.PreparedID})
+    }
+    if !s.certified(prepares, msg.Duty, msg.Height, best.PreparedRound, best.PreparedID) {
+        return s.reject(msg, "unjustified_prepared", map[string]any{"prepared_round": best.PreparedRound})
+    }
+    return nil
+}
+
+// checkPrepared validates the prepared certificate of a roundchange that
+// claims a prepared value.
+func (s *State) checkPrepared(rc Message) error {
+    if rc.PreparedRound >= rc.Round || !s.certified(rc.Justification, rc.Duty, rc.Height, rc.PreparedRound, rc.PreparedID) {
+        return s.reject(rc, "unjustified_prepared", map[string]any{"prepared_round": rc.PreparedRound})
+    }
+    return nil
+}
+
+// certified reports whether prepares hold a quorum of prepares for id at
+// (duty, height, round) from operators, validly signed when keys are known.
+func (s *State) certified(prepares []Message, duty string, height, round uint64, id string) bool {
+    var valid []Message
+    for _, p := range prepares {
+        if s.member(p) { valid = append(valid, p) }
+    }
+    return preparedVotes(valid, duty, height, round, id, nil) >= s.quorum(minPrepareVotes)
+}
+
+// member reports whether msg comes from an operator and, when operator keys
+// are configured, carries that operator's valid signature.
+func (s *State) member(msg Message) bool {
+    if s.Validators.Size() > 0 && !s.Validators.Contains(msg.From) { return false }
+    if s.Validators.HasKeys() {
+        k, ok := s.Validators.Key(msg.From)
+        return ok && VerifySig(k, msg)
+    }
+    return true
+}
+
+// maybePropose broadcasts a preprepare for round r when this node leads it.
+// The highest prepared value among rcs takes precedence over the local input;
+// its certificate travels in the justification.
+func (s *State) maybePropose(r uint64, rcs map[string]Message) {
+    if s.Self == "" || s.Broadcast == nil || s.proposed[r] || s.leaderFor(r) != s.Self { return }
+    id, value := s.inputID, s.input
+    list := make([]Message, 0, len(rcs))
+    for _, rc := range rcs { list = append(list, rc) }
+    // Sender order keeps the proposal (and its digest) independent of map order.
+    just := stripCertificates(list)
+    if best := highestPrepared(list, s.Duty, s.Height, r); best != nil {
+        id, value = best.PreparedID, best.Payload
+        cert := append([]Message(nil), best.Justification...)
+        sort.Slice(cert, func(i, j int) bool { return cert[i].From < cert[j].From })
+        just = append(just, cert...)
+    }
+    if id == "" { return }
+    if s.Payloads != nil {
+        canon, err := s.Payloads.Canonical(value)
+        if err == nil { id, err = ProposalIDOf(s.Payloads, canon) }
+        if err != nil {
+            logger.ErrorJ("qbft_state", map[string]any{"op": "propose", "height": s.Height, "round": r, "reason": "payload_invalid", "err": err.Error(), "trace_id": ""})
+            return
+        }
+        value = canon
+    }
+    s.proposed[r] = true
+    s.send(Message{Type: MsgPreprepare, Round: r, ProposalID: id, Payload: value, Justification: just})
+}
+
+// Certificate returns the commit certificate of the current proposal.
+func (s *State) Certificate() CommitCertificate {
+    return CommitCertificate{Duty: s.Duty, Height: s.Height, Round: s.Round, ProposalID: s.proposalID, Commits: append([]Message(nil), s.commits...)}
+}
+
+// decide records the decision and hands it to OnDecided.
+func (s *State) decide(traceID string) {
+    metrics.Inc("qbft_decided_total", nil)
+    logger.InfoJ("qbft_state", map[string]any{
+        "op":        "decided",
+        "node":      s.Self,
+        "duty":      s.Duty,
+        "height":    s.Height,
+        "round":     s.Round,
+        "proposal":  s.proposalID,
+        "commits":   len(s.commits),
+        "quorum":    s.quorum(minCommitVotes),
+        "trace_id":  traceID,
+    })
+    if s.OnDecided == nil { return }
+    s.OnDecided(Decided{Duty: s.Duty, Height: s.Height, Round: s.Round, ProposalID: s.proposalID, Value: s.proposal, Certificate: s.Certificate(), TraceID: traceID})
+}
+
+// send stamps (content id, signature) and broadcasts an own message in active mode.
+func (s *State) send(msg Message) {
+    if s.Self == "" || s.Broadcast == nil { return }
+    msg.From = s.Self
+    msg.Duty = s.Duty
+    msg.Height = s.Height
+    msg.ID = ContentID(msg)
+    sent, conflict := s.ownSent(msg)
+    if conflict {
+        logger.ErrorJ("qbft_state", map[string]any{"op": "send", "event_type": string(msg.Type), "height": s.Height, "round": msg.Round, "reason": "own_conflict", "trace_id": ""})
+        return
+    }
+    if s.Signer != nil { msg = s.Signer.Sign(msg) }
+    if !sent { s.own = append(s.own, msg) }
+    s.Broadcast(msg)
+}
+
+// reject logs a refused message with the given reason and returns an error.
+func (s *State) reject(msg Message, reason string, extra map[string]any) error {
+    fields := map[string]any{
+        "op":        "transition",
+        "event_type": string(msg.Type),
+        "node":      s.Self,
+        "height":    s.Height,
+        "round":     s.Round,
+        "reason":    reason,
+        "trace_id":  msg.TraceID,
+    }
+    for k, v := range extra { fields[k] = v }
+    logger.ErrorJ("qbft_state", fields)
+    return fmt.Errorf("%s", reason)
+}
@@ -17,22 +17,22 @@ func TestState_Commit_Duplicate_NoOpCounts(t *testing.T) {
     if err := st.Process(Message{ID: "blk1", From: "L", Type: MsgPreprepare, Height: 6, Round: 0}); err != nil {
         t.Fatalf("preprepare: %v", err)
     }
-    if err := st.Process(Message{ID: "blk1", From: "P1", Type: MsgPrepare, Height: 6, Round: 1}); err != nil {
+    if err := st.Process(Message{ID: "blk1", From: "P1", Type: MsgPrepare, Height: 6}); err != nil {
         t.Fatalf("prepare1: %v", err)
     }
-    if err := st.Process(Message{ID: "blk1", From: "P2", Type: MsgPrepare, Height: 6, Round: 1}); err != nil {
+    if err := st.Process(Message{ID: "blk1", From: "P2", Type: MsgPrepare, Height: 6}); err != nil {
         t.Fatalf("prepare2: %v", err)
     }
     if st.Phase != "prepared" { t.Fatalf("want prepared, got %q", st.Phase) }
 
     // First commit advances to commit.
-    if err := st.Process(Message{ID: "blk1", From: "C1", Type: MsgCommit, Height: 6, Round: 1}); err != nil {
+    if err := st.Process(Message{ID: "blk1", From: "C1", Type: MsgCommit, Height: 6}); err != nil {
         t.Fatalf("commit1: %v", err)
     }
     if st.Phase != "commit" { t.Fatalf("want commit, got %q", st.Phase) }
 
     // Duplicate commit is a no-op but still counts as processed.
-    if err := st.Process(Message{ID: "blk1", From: "C1", Type: MsgCommit, Height: 6, Round: 1}); err != nil {
+    if err := st.Process(Message{ID: "blk1", From: "C1", Type: MsgCommit, Height: 6}); err != nil {
         t.Fatalf("duplicate commit must not error: %v", err)
     }
     if st.Phase != "commit" { t.Fatalf("duplicate must not change phase: %q", st.Phase) }
@@ -53,20 +53,20 @@ func TestState_Commit_Mismatch_NoCount(t *testing.T) {
     if err := st.Process(Message{ID: "blk1", From: "L", Type: MsgPreprepare, Height: 7, Round: 0}); err != nil {
         t.Fatalf("preprepare: %v", err)
     }
-    if err := st.Process(Message{ID: "blk1", From: "P1", Type: MsgPrepare, Height: 7, Round: 1}); err != nil {
+    if err := st.Process(Message{ID: "blk1", From: "P1", Type: MsgPrepare, Height: 7}); err != nil {
         t.Fatalf("prepare1: %v", err)
     }
-    if err := st.Process(Message{ID: "blk1", From: "P2", Type: MsgPrepare, Height: 7, Round: 1}); err != nil {
+    if err := st.Process(Message{ID: "blk1", From: "P2", Type: MsgPrepare, Height: 7}); err != nil {
         t.Fatalf("prepare2: %v", err)
     }
     if st.Phase != "prepared" { t.Fatalf("want prepared, got %q", st.Phase) }
 
     // First commit (ok)
-    if err := st.Process(Message{ID: "blk1", From: "C1", Type: MsgCommit, Height: 7, Round: 1}); err != nil {
+    if err := st.Process(Message{ID: "blk1", From: "C1", Type: MsgCommit, Height: 7}); err != nil {
         t.Fatalf("commit1: %v", err)
     }
     // Mismatched commit (error)
-    if err := st.Process(Message{ID: "blkX", From: "C2", Type: MsgCommit, Height: 7, Round: 1}); err == nil {
+    if err := st.Process(Message{ID: "blkX", From: "C2", Type: MsgCommit, Height: 7}); err == nil {
         t.Fatalf("expected error for mismatched proposal id on commit")
     }
     if st.Phase != "commit" { t.Fatalf("phase changed unexpectedly: %q", st.Phase) }
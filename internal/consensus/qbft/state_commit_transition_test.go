@@ -22,13 +22,13 @@ func TestState_Commit_AfterPrepared(t *testing.T) {
     }
 
     // Two prepares reach threshold -> prepared (as per simplified model).
-    if err := st.Process(Message{ID: "blk1", From: "P1", Type: MsgPrepare, Height: 6, Round: 1}); err != nil {
+    if err := st.Process(Message{ID: "blk1", From: "P1", Type: MsgPrepare, Height: 6}); err != nil {
         t.Fatalf("prepare1: %v", err)
     }
     if st.Phase != "preprepared" {
         t.Fatalf("still preprepared after first prepare, got %q", st.Phase)
     }
-    if err := st.Process(Message{ID: "blk1", From: "P2", Type: MsgPrepare, Height: 6, Round: 1}); err != nil {
+    if err := st.Process(Message{ID: "blk1", From: "P2", Type: MsgPrepare, Height: 6}); err != nil {
         t.Fatalf("prepare2: %v", err)
     }
     if st.Phase != "prepared" {
@@ -36,7 +36,7 @@ func TestState_Commit_AfterPrepared(t *testing.T) {
     }
 
     // Commit message should advance to commit and record metrics.
-    if err := st.Process(Message{ID: "blk1", From: "C1", Type: MsgCommit, Height: 6, Round: 1}); err != nil {
+    if err := st.Process(Message{ID: "blk1", From: "C1", Type: MsgCommit, Height: 6}); err != nil {
         t.Fatalf("commit: %v", err)
     }
     if st.Phase != "commit" {
@@ -15,23 +15,23 @@ func TestState_Prepare_MultiSource_Dedup_Once(t *testing.T) {
     if st.Phase != "preprepared" { t.Fatalf("want preprepared, got %q", st.Phase) }
 
     // Different From: P1 then P2 -> reach prepared.
-    if err := st.Process(Message{ID: "blk1", From: "P1", Type: MsgPrepare, Height: 11, Round: 1}); err != nil {
+    if err := st.Process(Message{ID: "blk1", From: "P1", Type: MsgPrepare, Height: 11}); err != nil {
         t.Fatalf("prepare P1: %v", err)
     }
     if st.Phase != "preprepared" { t.Fatalf("still preprepared after first prepare, got %q", st.Phase) }
 
-    if err := st.Process(Message{ID: "blk1", From: "P2", Type: MsgPrepare, Height: 11, Round: 1}); err != nil {
+    if err := st.Process(Message{ID: "blk1", From: "P2", Type: MsgPrepare, Height: 11}); err != nil {
         t.Fatalf("prepare P2: %v", err)
     }
     if st.Phase != "prepared" { t.Fatalf("want prepared after second distinct prepare, got %q", st.Phase) }
 
     // Duplicate or extra prepares must not change phase further.
-    if err := st.Process(Message{ID: "blk1", From: "P2", Type: MsgPrepare, Height: 11, Round: 1}); err != nil {
+    if err := st.Process(Message{ID: "blk1", From: "P2", Type: MsgPrepare, Height: 11}); err != nil {
         t.Fatalf("duplicate prepare must not error: %v", err)
     }
     if st.Phase != "prepared" { t.Fatalf("phase changed unexpectedly after duplicate, got %q", st.Phase) }
 
-    if err := st.Process(Message{ID: "blk1", From: "P3", Type: MsgPrepare, Height: 11, Round: 1}); err != nil {
+    if err := st.Process(Message{ID: "blk1", From: "P3", Type: MsgPrepare, Height: 11}); err != nil {
         t.Fatalf("extra prepare must not error: %v", err)
     }
     if st.Phase != "prepared" { t.Fatalf("phase changed unexpectedly after extra prepare, got %q", st.Phase) }
@@ -48,18 +48,18 @@ func TestState_Prepare_MultiSource_OutOfOrder(t *testing.T) {
     if st.Phase != "preprepared" { t.Fatalf("want preprepared, got %q", st.Phase) }
 
     // P2 arrives before P1.
-    if err := st.Process(Message{ID: "blk1", From: "P2", Type: MsgPrepare, Height: 12, Round: 1}); err != nil {
+    if err := st.Process(Message{ID: "blk1", From: "P2", Type: MsgPrepare, Height: 12}); err != nil {
         t.Fatalf("prepare P2: %v", err)
     }
     if st.Phase != "preprepared" { t.Fatalf("still preprepared after first prepare, got %q", st.Phase) }
 
-    if err := st.Process(Message{ID: "blk1", From: "P1", Type: MsgPrepare, Height: 12, Round: 1}); err != nil {
+    if err := st.Process(Message{ID: "blk1", From: "P1", Type: MsgPrepare, Height: 12}); err != nil {
         t.Fatalf("prepare P1: %v", err)
     }
     if st.Phase != "prepared" { t.Fatalf("want prepared after second distinct prepare, got %q", st.Phase) }
 
     // Duplicate should not change phase further.
-    if err := st.Process(Message{ID: "blk1", From: "P1", Type: MsgPrepare, Height: 12, Round: 1}); err != nil {
+    if err := st.Process(Message{ID: "blk1", From: "P1", Type: MsgPrepare, Height: 12}); err != nil {
         t.Fatalf("duplicate prepare must not error: %v", err)
     }
     if st.Phase != "prepared" { t.Fatalf("phase changed unexpectedly after duplicate, got %q", st.Phase) }
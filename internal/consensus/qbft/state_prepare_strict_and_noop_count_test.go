@@ -13,7 +13,7 @@ func TestState_Prepare_WithoutPreprepare_ErrorNoCount(t *testing.T) {
     metrics.Reset()
     st := &State{Leader: "L"}
 
-    if err := st.Process(Message{ID: "blk1", From: "P1", Type: MsgPrepare, Height: 1, Round: 1}); err == nil {
+    if err := st.Process(Message{ID: "blk1", From: "P1", Type: MsgPrepare, Height: 1}); err == nil {
         t.Fatalf("expected error for prepare before preprepare")
     }
     if st.Phase != "" { // phase should remain default (no transition)
@@ -42,7 +42,7 @@ func TestState_Prepare_Duplicate_NoOpCounts(t *testing.T) {
     }
 
     // First prepare from P1 increments prepare counter but does not reach threshold.
-    if err := st.Process(Message{ID: "blk1", From: "P1", Type: MsgPrepare, Height: 2, Round: 1}); err != nil {
+    if err := st.Process(Message{ID: "blk1", From: "P1", Type: MsgPrepare, Height: 2}); err != nil {
         t.Fatalf("prepare1: %v", err)
     }
     if st.Phase != "preprepared" {
@@ -50,7 +50,7 @@ func TestState_Prepare_Duplicate_NoOpCounts(t *testing.T) {
     }
 
     // Duplicate from P1 is a no-op; should not advance phase, but still count.
-    if err := st.Process(Message{ID: "blk1", From: "P1", Type: MsgPrepare, Height: 2, Round: 1}); err != nil {
+    if err := st.Process(Message{ID: "blk1", From: "P1", Type: MsgPrepare, Height: 2}); err != nil {
         t.Fatalf("duplicate prepare must not error: %v", err)
     }
     if st.Phase != "preprepared" {
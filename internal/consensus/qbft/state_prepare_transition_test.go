@@ -12,13 +12,13 @@ func TestState_Prepared_AfterThreshold(t *testing.T) {
     if st.Phase != "preprepared" { t.Fatalf("want preprepared, got %q", st.Phase) }
 
     // First prepare from P1 (same proposal id)
-    if err := st.Process(Message{ID: "blk1", From: "P1", Type: MsgPrepare, Height: 5, Round: 1}); err != nil {
+    if err := st.Process(Message{ID: "blk1", From: "P1", Type: MsgPrepare, Height: 5}); err != nil {
         t.Fatalf("prepare1: %v", err)
     }
     if st.Phase != "preprepared" { t.Fatalf("should still be preprepared after first prepare, got %q", st.Phase) }
 
     // Second prepare from P2 reaches threshold -> prepared
-    if err := st.Process(Message{ID: "blk1", From: "P2", Type: MsgPrepare, Height: 5, Round: 1}); err != nil {
+    if err := st.Process(Message{ID: "blk1", From: "P2", Type: MsgPrepare, Height: 5}); err != nil {
         t.Fatalf("prepare2: %v", err)
     }
     if st.Phase != "prepared" { t.Fatalf("want prepared, got %q", st.Phase) }
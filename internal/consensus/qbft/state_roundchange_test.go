@@ -0,0 +1,220 @@
+package qbft
+
+import (
+    "testing"
+    "time"
+)
+
+func TestRoundTimer_Backoff(t *testing.T) {
+    rt := RoundTimer{Base: time.Second, Backoff: 2, Max: 5 * time.Second}
+    want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+    for r, w := range want {
+        if got := rt.Duration(uint64(r)); got != w { t.Fatalf("round %d: got %v want %v", r, got, w) }
+    }
+    if (RoundTimer{}).Enabled() { t.Fatalf("zero timer must be disabled") }
+}
+
+// A timeout moves to the next round, rotates the leader and broadcasts a
+// roundchange carrying the prepared proposal.
+func TestState_Timeout_BroadcastsRoundChange(t *testing.T) {
+    now := time.Unix(0, 0)
+    var out []Message
+    st := &State{
+        Self:      "B",
+        Broadcast: func(m Message) { out = append(out, m) },
+        LeaderFn:  RoundRobin("A", "B", "C", "D"),
+        Timer:     RoundTimer{Base: time.Second},
+        Now:       func() time.Time { return now },
+    }
+    if err := st.Start(4); err != nil { t.Fatalf("start: %v", err) }
+    if st.Leader != "A" { t.Fatalf("leader round 0: %q", st.Leader) }
+    now = now.Add(500 * time.Millisecond)
+    _ = st.Tick(now)
+    if st.Round != 0 { t.Fatalf("timer fired early") }
+    now = now.Add(time.Second)
+    _ = st.Tick(now)
+    if st.Round != 1 || st.Phase != "roundchange" || st.Leader != "B" {
+        t.Fatalf("after timeout: round=%d phase=%q leader=%q", st.Round, st.Phase, st.Leader)
+    }
+    if len(out) != 1 || out[0].Type != MsgRoundChange || out[0].Round != 1 || out[0].From != "B" {
+        t.Fatalf("want one roundchange, got %+v", out)
+    }
+}
+
+// preparesFor builds the prepared certificate for id at (height, round).
+func preparesFor(id string, height, round uint64, from ...string) []Message {
+    var out []Message
+    for _, f := range from { out = append(out, Message{From: f, Type: MsgPrepare, Height: height, Round: round, ProposalID: id}) }
+    return out
+}
+
+// A roundchange quorum makes the new leader propose with a justification that
+// re-proposes the highest prepared value.
+func TestState_RoundChangeQuorum_LeaderProposesPrepared(t *testing.T) {
+    var out []Message
+    st := &State{Self: "B", Broadcast: func(m Message) { out = append(out, m) }, LeaderFn: RoundRobin("A", "B", "C", "D")}
+    st.SetInput("mine", []byte("x"))
+    _ = st.Start(4)
+    rcs := []Message{
+        {From: "C", Type: MsgRoundChange, Height: 4, Round: 1},
+        {From: "D", Type: MsgRoundChange, Height: 4, Round: 1, PreparedRound: 0, PreparedID: "old", Payload: []byte("v"),
+            Justification: preparesFor("old", 4, 0, "C", "D")},
+    }
+    for _, rc := range rcs {
+        if err := st.Process(rc); err != nil { t.Fatalf("roundchange: %v", err) }
+    }
+    if st.Round != 1 { t.Fatalf("want round 1, got %d", st.Round) }
+    if len(out) != 1 || out[0].Type != MsgPreprepare || out[0].ProposalID != "old" || len(out[0].Justification) != 4 {
+        t.Fatalf("want justified preprepare of prepared value, got %+v", out)
+    }
+    for _, j := range out[0].Justification {
+        if j.Type == MsgRoundChange && len(j.Justification) != 0 { t.Fatalf("embedded roundchange keeps its certificate: %+v", j) }
+    }
+    // The proposal is accepted by the state itself and voted for.
+    if err := st.Process(out[0]); err != nil { t.Fatalf("own preprepare: %v", err) }
+    if st.Phase != "preprepared" || out[len(out)-1].Type != MsgPrepare { t.Fatalf("want prepare vote, phase=%q", st.Phase) }
+}
+
+func TestState_Preprepare_HigherRound_Unjustified(t *testing.T) {
+    st := &State{LeaderFn: RoundRobin("A", "B")}
+    _ = st.Start(2)
+    just := []Message{{From: "A", Type: MsgRoundChange, Height: 2, Round: 1}}
+    if err := st.Process(Message{ID: "p", From: "B", Type: MsgPreprepare, Height: 2, Round: 1, Justification: just}); err == nil {
+        t.Fatalf("want unjustified error below roundchange quorum")
+    }
+    just = append(just, Message{From: "B", Type: MsgRoundChange, Height: 2, Round: 1, PreparedRound: 0, PreparedID: "locked"})
+    if err := st.Process(Message{ID: "p", From: "B", Type: MsgPreprepare, Height: 2, Round: 1, Justification: just}); err == nil {
+        t.Fatalf("want unjustified_value error when ignoring prepared value")
+    }
+    if err := st.Process(Message{ID: "locked", From: "B", Type: MsgPreprepare, Height: 2, Round: 1, Justification: just}); err == nil {
+        t.Fatalf("want unjustified_prepared error without the prepares for the locked value")
+    }
+    just = append(just, preparesFor("locked", 2, 0, "A", "B")...)
+    if err := st.Process(Message{ID: "locked", From: "B", Type: MsgPreprepare, Height: 2, Round: 1, Justification: just}); err != nil {
+        t.Fatalf("justified preprepare: %v", err)
+    }
+}
+
+// Votes carry their real round: preparing A in round 0 and B in round 1 is
+// neither an own conflict nor equivocation.
+func TestState_VotesInDistinctRounds_NoConflict(t *testing.T) {
+    var out []Message
+    ids := []string{"a", "b", "c", "d"}
+    st := &State{Self: "d", Broadcast: func(m Message) { out = append(out, m) }, LeaderFn: RoundRobin(ids...), Validators: NewValidators(ids, 0)}
+    _ = st.Start(1)
+    if err := st.Process(Message{ProposalID: "A", From: "b", Type: MsgPreprepare, Height: 1}); err != nil { t.Fatalf("round-0 proposal: %v", err) }
+    var just []Message
+    for _, from := range []string{"a", "b", "c"} {
+        rc := Message{From: from, Type: MsgRoundChange, Height: 1, Round: 1}
+        just = append(just, rc)
+        if err := st.Process(rc); err != nil { t.Fatalf("roundchange: %v", err) }
+    }
+    if err := st.Process(Message{ProposalID: "B", From: "c", Type: MsgPreprepare, Height: 1, Round: 1, Justification: just}); err != nil { t.Fatalf("round-1 proposal: %v", err) }
+    var prepares []Message
+    for _, m := range out { if m.Type == MsgPrepare { prepares = append(prepares, m) } }
+    if len(prepares) != 2 || prepares[0].Round != 0 || prepares[0].ProposalID != "A" || prepares[1].Round != 1 || prepares[1].ProposalID != "B" {
+        t.Fatalf("want prepares A@0 and B@1, got %+v", prepares)
+    }
+    peer := &State{Validators: NewValidators(ids, 0), OnEvidence: func(ev Evidence) { t.Fatalf("false evidence: %+v", ev) }}
+    _ = peer.Start(1)
+    _ = peer.Process(prepares[0])
+    for _, rc := range just { _ = peer.Process(rc) }
+    if peer.Round != 1 { t.Fatalf("peer should follow to round 1, at %d", peer.Round) }
+    _ = peer.Process(prepares[1])
+}
+
+// A roundchange claiming a prepared value needs a quorum of prepares for it
+// from a round below its own.
+func TestState_RoundChange_PreparedRequiresCertificate(t *testing.T) {
+    ids := []string{"a", "b", "c", "d"}
+    st := &State{LeaderFn: RoundRobin(ids...), Validators: NewValidators(ids, 0)}
+    _ = st.Start(3)
+    forged := Message{From: "a", Type: MsgRoundChange, Height: 3, Round: 2, PreparedRound: 1, PreparedID: "x", Justification: preparesFor("x", 3, 1, "a", "b")}
+    if err := st.Process(forged); err == nil { t.Fatalf("want unjustified_prepared below prepare quorum") }
+    forged.Justification, forged.PreparedRound = preparesFor("x", 3, 2, "a", "b", "c"), 2
+    if err := st.Process(forged); err == nil { t.Fatalf("want unjustified_prepared for prepared_round == round") }
+    ok := Message{From: "a", Type: MsgRoundChange, Height: 3, Round: 2, PreparedRound: 1, PreparedID: "x", Justification: preparesFor("x", 3, 1, "a", "b", "c")}
+    if err := st.Process(ok); err != nil { t.Fatalf("certified roundchange: %v", err) }
+}
+
+// A node that prepared attaches the prepares it saw to its roundchange.
+func TestState_RoundChange_CarriesPreparedCertificate(t *testing.T) {
+    now := time.Unix(0, 0)
+    var out []Message
+    st := &State{Self: "A", Broadcast: func(m Message) { out = append(out, m) }, LeaderFn: RoundRobin("A", "B"),
+        Timer: RoundTimer{Base: time.Second}, Now: func() time.Time { return now }}
+    _ = st.Start(5)
+    _ = st.Process(Message{ProposalID: "v", From: "B", Type: MsgPreprepare, Height: 5})
+    for _, p := range preparesFor("v", 5, 0, "B", "C") { _ = st.Process(p) }
+    if st.Phase != "prepared" { t.Fatalf("phase %q", st.Phase) }
+    now = now.Add(2 * time.Second)
+    _ = st.Tick(now)
+    rc := out[len(out)-1]
+    if rc.Type != MsgRoundChange || rc.PreparedID != "v" || rc.PreparedRound != 0 || preparedVotes(rc.Justification, "", 5, 0, "v", nil) != 2 {
+        t.Fatalf("want certified roundchange, got %+v", rc)
+    }
+}
+
+// f roundchanges for a higher round never move the node, whatever the lock
+// threshold; the f+1-th (at least one honest sender) makes it skip ahead.
+func TestState_RoundChange_SkipNeedsFPlusOne(t *testing.T) {
+    cases := []struct{ n, threshold, f int }{{4, 0, 1}, {4, 4, 1}, {6, 0, 1}, {7, 7, 2}}
+    for _, c := range cases {
+        ids := make([]string, c.n)
+        for i := range ids { ids[i] = string(rune('A' + i)) }
+        st := &State{Self: ids[0], Validators: NewValidators(ids, c.threshold), LeaderFn: RoundRobin(ids...)}
+        _ = st.Start(1)
+        f := c.f
+        for _, from := range ids[1 : f+1] {
+            if err := st.Process(Message{From: from, Type: MsgRoundChange, Height: 1, Round: 3}); err != nil { t.Fatalf("roundchange: %v", err) }
+        }
+        if st.Round != 0 { t.Fatalf("n=%d t=%d: %d roundchanges moved the node to round %d", c.n, c.threshold, f, st.Round) }
+        if err := st.Process(Message{From: ids[f+1], Type: MsgRoundChange, Height: 1, Round: 3}); err != nil { t.Fatalf("roundchange: %v", err) }
+        if st.Round != 3 { t.Fatalf("n=%d t=%d: want round 3 after f+1 roundchanges, got %d", c.n, c.threshold, st.Round) }
+    }
+}
+
+// A decided instance stays decided: a justified higher-round proposal, f+1
+// roundchanges and expired round timers neither move it nor make it decide
+// a second time.
+func TestState_Decided_IsTerminal(t *testing.T) {
+    ids := []string{"a", "b", "c", "d"}
+    now := time.Unix(1_700_000_000, 0)
+    var decisions []Decided
+    st := &State{
+        Validators: NewValidators(ids, 0),
+        LeaderFn:   RoundRobin(ids...),
+        Timer:      RoundTimer{Base: time.Second, Backoff: 2, Max: 8 * time.Second},
+        Now:        func() time.Time { return now },
+        OnDecided:  func(d Decided) { decisions = append(decisions, d) },
+    }
+    _ = st.Start(1)
+    steps := []Message{{ID: "v", From: st.leaderFor(0), Type: MsgPreprepare, Height: 1}}
+    for _, typ := range []Type{MsgPrepare, MsgCommit} {
+        for _, from := range ids[:3] { steps = append(steps, Message{ID: "v", From: from, Type: typ, Height: 1}) }
+    }
+    for _, m := range steps {
+        if err := st.Process(m); err != nil { t.Fatalf("%s from %s: %v", m.Type, m.From, err) }
+    }
+    if st.Phase != "commit" || len(decisions) != 1 { t.Fatalf("want one decision, got phase %q and %d", st.Phase, len(decisions)) }
+
+    // f+1 roundchanges for round 2.
+    for _, from := range []string{"c", "d"} {
+        if err := st.Process(Message{From: from, Type: MsgRoundChange, Height: 1, Round: 2}); err != nil { t.Fatalf("roundchange: %v", err) }
+    }
+    // A round-1 proposal for another value, justified by a roundchange quorum,
+    // followed by quorums of prepares and commits for it.
+    var just []Message
+    for _, from := range ids[:3] { just = append(just, Message{From: from, Type: MsgRoundChange, Height: 1, Round: 1}) }
+    later := []Message{{ID: "w", From: st.leaderFor(1), Type: MsgPreprepare, Height: 1, Round: 1, Justification: just}}
+    for _, typ := range []Type{MsgPrepare, MsgCommit} {
+        for _, from := range ids[1:] { later = append(later, Message{ID: "w", From: from, Type: typ, Height: 1, Round: 1}) }
+    }
+    for _, m := range later { _ = st.Process(m) }
+    for i := 0; i < 5; i++ {
+        now = now.Add(10 * time.Second)
+        _ = st.Tick(now)
+    }
+    if st.Phase != "commit" || st.Round != 0 || ProposalRef(st.Certificate().Commits[0]) != "v" { t.Fatalf("decided instance moved: phase %q round %d", st.Phase, st.Round) }
+    if len(decisions) != 1 { t.Fatalf("decided %d times", len(decisions)) }
+}
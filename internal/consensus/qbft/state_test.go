@@ -14,11 +14,15 @@ func TestState_Process_IncrementsCounterAndUpdatesCoords(t *testing.T) {
     if err := st.Process(Message{ID: "1", From: "L", Type: MsgPreprepare, Height: 7, Round: 0}); err != nil {
         t.Fatalf("preprepare: %v", err)
     }
-    msg := Message{ID: "1", From: "p", Type: MsgPrepare, Height: 7, Round: 2}
+    msg := Message{ID: "1", From: "p", Type: MsgPrepare, Height: 7}
     if err := st.Process(msg); err != nil {
         t.Fatalf("prepare: %v", err)
     }
-    if st.Height != 7 || st.Round != 2 {
+    // A vote for a later round is buffered, not applied.
+    if err := st.Process(Message{ID: "1", From: "q", Type: MsgPrepare, Height: 7, Round: 1}); err != nil {
+        t.Fatalf("future prepare: %v", err)
+    }
+    if st.Height != 7 || st.Round != 0 {
         t.Fatalf("coords mismatch: %+v", *st)
     }
     dump := metrics.DumpProm()
@@ -35,15 +39,15 @@ func TestState_Process_PhaseMapping(t *testing.T) {
     }
     if st.Phase != "preprepared" { t.Fatalf("phase: %s", st.Phase) }
     // two prepares reach prepared
-    if err := st.Process(Message{ID:"blkM", From:"P1", Type: MsgPrepare, Round:1}); err != nil {
+    if err := st.Process(Message{ID:"blkM", From:"P1", Type: MsgPrepare}); err != nil {
         t.Fatalf("prepare1: %v", err)
     }
-    if err := st.Process(Message{ID:"blkM", From:"P2", Type: MsgPrepare, Round:1}); err != nil {
+    if err := st.Process(Message{ID:"blkM", From:"P2", Type: MsgPrepare}); err != nil {
         t.Fatalf("prepare2: %v", err)
     }
     if st.Phase != "prepared" { t.Fatalf("phase: %s", st.Phase) }
     // commit advances to commit
-    if err := st.Process(Message{ID:"blkM", From:"C1", Type: MsgCommit, Round:1}); err != nil {
+    if err := st.Process(Message{ID:"blkM", From:"C1", Type: MsgCommit}); err != nil {
         t.Fatalf("commit: %v", err)
     }
     if st.Phase != "commit" { t.Fatalf("phase: %s", st.Phase) }
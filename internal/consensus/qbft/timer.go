@@ -0,0 +1,39 @@
+package qbft
+
+import "time"
+
+// RoundTimer computes per-round timeouts with exponential backoff:
+// Base * Backoff^round, capped at Max. A zero Base disables timeouts.
+type RoundTimer struct {
+    Base    time.Duration
+    Backoff float64
+    Max     time.Duration
+}
+
+// DefaultRoundTimer returns the timer used by nodes unless configured otherwise.
+func DefaultRoundTimer() RoundTimer {
+    return RoundTimer{Base: 2 * time.Second, Backoff: 2, Max: 30 * time.Second}
+}
+
+// Enabled reports whether the timer produces timeouts.
+func (t RoundTimer) Enabled() bool { return t.Base > 0 }
+
+// Duration returns the timeout for the given round.
+func (t RoundTimer) Duration(round uint64) time.Duration {
+    if t.Base <= 0 { return 0 }
+    d := float64(t.Base)
+    if t.Backoff > 1 {
+        for i := uint64(0); i < round; i++ {
+            d *= t.Backoff
+            if t.Max > 0 && d >= float64(t.Max) { return t.Max }
+        }
+    }
+    if t.Max > 0 && time.Duration(d) > t.Max { return t.Max }
+    return time.Duration(d)
+}
+
+// Ticker is implemented by processors that run round timers. Drivers call
+// Tick periodically (wall clock in the service, virtual clock in tests).
+type Ticker interface {
+    Tick(now time.Time) error
+}
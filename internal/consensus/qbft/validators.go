@@ -0,0 +1,77 @@
+package qbft
+
+import (
+    "crypto/ed25519"
+    "sort"
+
+    "github.com/zmlAEQ/Aequa-network/pkg/config"
+)
+
+// Validators is the operator set of a cluster. It sizes the quorums used by
+// State; the zero value means "unconfigured" and keeps minimal thresholds.
+type Validators struct {
+    ids       []string
+    index     map[string]int
+    threshold int
+    keys      map[string]ed25519.PublicKey
+}
+
+// NewValidators builds an operator set from ids (in order) and an optional
+// threshold (0 derives the quorum from the set size).
+func NewValidators(ids []string, threshold int) Validators {
+    v := Validators{ids: append([]string(nil), ids...), index: make(map[string]int, len(ids)), threshold: threshold}
+    for i, id := range v.ids { v.index[id] = i }
+    return v
+}
+
+// ValidatorsFromLock builds the operator set from a cluster lock, ordered by
+// operator index and identified by peer id. A malformed operator key is an
+// error rather than a set without signature checks.
+func ValidatorsFromLock(l config.ClusterLock) (Validators, error) {
+    ops := append([]config.Operator(nil), l.Operators...)
+    sort.SliceStable(ops, func(i, j int) bool { return ops[i].Index < ops[j].Index })
+    ids := make([]string, 0, len(ops))
+    for _, op := range ops { ids = append(ids, op.PeerID) }
+    v := NewValidators(ids, l.Threshold)
+    keys, err := KeysFromLock(l)
+    if err != nil { return Validators{}, err }
+    if len(keys) > 0 { v.keys = keys }
+    return v, nil
+}
+
+// WithKeys returns a copy of v that verifies signatures with keys.
+func (v Validators) WithKeys(keys map[string]ed25519.PublicKey) Validators { v.keys = keys; return v }
+
+// Key returns the public key of operator id, if known.
+func (v Validators) Key(id string) (ed25519.PublicKey, bool) { k, ok := v.keys[id]; return k, ok }
+
+// Keys returns the operator keys signatures are checked against.
+func (v Validators) Keys() map[string]ed25519.PublicKey { return v.keys }
+
+// HasKeys reports whether signatures can be checked against this set.
+func (v Validators) HasKeys() bool { return len(v.keys) > 0 }
+
+// Size returns the number of operators.
+func (v Validators) Size() int { return len(v.ids) }
+
+// IDs returns the operator ids in index order.
+func (v Validators) IDs() []string { return append([]string(nil), v.ids...) }
+
+// Contains reports whether id is an operator.
+func (v Validators) Contains(id string) bool { _, ok := v.index[id]; return ok }
+
+// Quorum returns ceil(2n/3), or the lock threshold when it is at least that
+// large. A threshold below the BFT bound is ignored as unsafe.
+func (v Validators) Quorum() int {
+    n := len(v.ids)
+    q := (2*n + 2) / 3
+    if v.threshold > q && v.threshold <= n { q = v.threshold }
+    return q
+}
+
+// Faulty returns the BFT fault bound f = (n-1)/3. It does not depend on the
+// lock threshold: a higher quorum costs liveness, not Byzantine tolerance.
+func (v Validators) Faulty() int {
+    if len(v.ids) == 0 { return 0 }
+    return (len(v.ids) - 1) / 3
+}
@@ -0,0 +1,70 @@
+package qbft
+
+import (
+    "testing"
+
+    "github.com/zmlAEQ/Aequa-network/pkg/config"
+)
+
+func TestValidators_Quorum(t *testing.T) {
+    cases := []struct{ n, threshold, quorum, faulty int }{
+        {1, 0, 1, 0},
+        {3, 0, 2, 0},
+        {4, 0, 3, 1},
+        {4, 4, 4, 1}, // a higher threshold does not change f
+        {4, 2, 3, 1}, // unsafe threshold ignored
+        {7, 0, 5, 2},
+        {10, 0, 7, 3},
+    }
+    for _, c := range cases {
+        ids := make([]string, c.n)
+        for i := range ids { ids[i] = string(rune('a' + i)) }
+        v := NewValidators(ids, c.threshold)
+        if v.Quorum() != c.quorum || v.Faulty() != c.faulty {
+            t.Fatalf("n=%d t=%d: quorum=%d faulty=%d", c.n, c.threshold, v.Quorum(), v.Faulty())
+        }
+    }
+}
+
+func TestValidators_Faulty(t *testing.T) {
+    want := []int{0, 0, 0, 1, 1, 1, 2, 2, 2, 3} // n = 1..10
+    for i, f := range want {
+        n := i + 1
+        ids := make([]string, n)
+        for j := range ids { ids[j] = string(rune('a' + j)) }
+        for _, threshold := range []int{0, n} {
+            if got := NewValidators(ids, threshold).Faulty(); got != f {
+                t.Fatalf("n=%d t=%d: faulty=%d want %d", n, threshold, got, f)
+            }
+        }
+    }
+}
+
+func TestValidatorsFromLock_OrderedByIndex(t *testing.T) {
+    l := config.ClusterLock{Threshold: 3, Operators: []config.Operator{{Index: 2, PeerID: "c"}, {Index: 0, PeerID: "a"}, {Index: 1, PeerID: "b"}, {Index: 3, PeerID: "d"}}}
+    v, err := ValidatorsFromLock(l)
+    if err != nil { t.Fatalf("lock: %v", err) }
+    if got := v.IDs(); got[0] != "a" || got[3] != "d" { t.Fatalf("order: %v", got) }
+    if !v.Contains("c") || v.Contains("x") { t.Fatalf("membership mismatch") }
+    l.Operators[1].PubKey = "zz"
+    if _, err := ValidatorsFromLock(l); err == nil { t.Fatalf("want error for malformed operator key") }
+}
+
+// With a 4-operator set, prepared needs 3 prepares and commit needs 3 commits.
+func TestState_ClusterQuorum_PrepareAndCommit(t *testing.T) {
+    st := &State{Leader: "a", Validators: NewValidators([]string{"a", "b", "c", "d"}, 0)}
+    if err := st.Process(Message{ID: "blk", From: "a", Type: MsgPreprepare, Height: 1}); err != nil { t.Fatalf("preprepare: %v", err) }
+    for i, from := range []string{"a", "b", "c"} {
+        if err := st.Process(Message{ID: "blk", From: from, Type: MsgPrepare, Height: 1}); err != nil { t.Fatalf("prepare: %v", err) }
+        if i < 2 && st.Phase != "preprepared" { t.Fatalf("prepared below quorum after %d votes", i+1) }
+    }
+    if st.Phase != "prepared" { t.Fatalf("want prepared at quorum, got %q", st.Phase) }
+    for i, from := range []string{"b", "c", "d"} {
+        if err := st.Process(Message{ID: "blk", From: from, Type: MsgCommit, Height: 1}); err != nil { t.Fatalf("commit: %v", err) }
+        if i < 2 && st.Phase == "commit" { t.Fatalf("decided below quorum after %d commits", i+1) }
+    }
+    if st.Phase != "commit" { t.Fatalf("want commit at quorum, got %q", st.Phase) }
+    if err := st.Process(Message{ID: "blk", From: "x", Type: MsgCommit, Height: 1}); err == nil {
+        t.Fatalf("want unknown_sender error for non-operator")
+    }
+}
@@ -0,0 +1,13 @@
+package qbft
+
+// ValueValidator decides whether a proposed value is acceptable to vote for,
+// e.g. whether attestation data matches what the local beacon node reports.
+// It sees the canonical value when a payload manager is configured.
+type ValueValidator interface {
+    ValidateValue(duty string, height uint64, value []byte) error
+}
+
+// ValueValidatorFunc adapts a function into a ValueValidator.
+type ValueValidatorFunc func(duty string, height uint64, value []byte) error
+
+func (f ValueValidatorFunc) ValidateValue(duty string, height uint64, value []byte) error { return f(duty, height, value) }
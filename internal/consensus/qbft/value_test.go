@@ -0,0 +1,40 @@
+package qbft
+
+import (
+    "errors"
+    "strings"
+    "testing"
+
+    "github.com/zmlAEQ/Aequa-network/pkg/metrics"
+)
+
+func TestState_ValueValidator_RefusesToVoteAndChangesRound(t *testing.T) {
+    metrics.Reset()
+    var out []Message
+    var seen []string
+    st := &State{
+        Self:           "b",
+        Broadcast:      func(m Message) { out = append(out, m) },
+        LeaderFn:       RoundRobin("a", "b", "c", "d"),
+        Validators:     NewValidators([]string{"a", "b", "c", "d"}, 0),
+        Duty:           "attester",
+        ValueValidator: ValueValidatorFunc(func(duty string, h uint64, v []byte) error {
+            seen = append(seen, duty)
+            if string(v) != "good" { return errors.New("attestation data mismatch") }
+            return nil
+        }),
+    }
+    if err := st.Start(8); err != nil { t.Fatalf("start: %v", err) }
+    if err := st.Process(Message{ID: "x", ProposalID: "bad", From: "a", Type: MsgPreprepare, Height: 8, Payload: []byte("bad")}); err == nil {
+        t.Fatalf("want invalid_value error")
+    }
+    if st.Phase != "roundchange" || st.Round != 1 { t.Fatalf("want round change, got phase=%q round=%d", st.Phase, st.Round) }
+    if len(out) != 1 || out[0].Type != MsgRoundChange || out[0].Round != 1 { t.Fatalf("want only a roundchange, got %+v", out) }
+    if len(seen) != 1 || seen[0] != "attester" { t.Fatalf("validator not consulted with duty: %v", seen) }
+    if !strings.Contains(metrics.DumpProm(), `qbft_round_changes_total{reason="invalid_value"} 1`) { t.Fatalf("missing round change metric") }
+
+    st2 := &State{Self: "b", Broadcast: func(m Message) { out = append(out, m) }, Leader: "a", ValueValidator: st.ValueValidator}
+    out = nil
+    if err := st2.Process(Message{ID: "y", ProposalID: "good", From: "a", Type: MsgPreprepare, Height: 8, Payload: []byte("good")}); err != nil { t.Fatalf("valid value: %v", err) }
+    if len(out) != 1 || out[0].Type != MsgPrepare { t.Fatalf("want prepare for an accepted value, got %+v", out) }
+}
@@ -1,9 +1,12 @@
 package qbft
 
 import (
+    "crypto/ed25519"
+    "errors"
     "fmt"
-    "sync"
+    "time"
 
+    "github.com/zmlAEQ/Aequa-network/internal/consensus/payload"
     "github.com/zmlAEQ/Aequa-network/pkg/logger"
     "github.com/zmlAEQ/Aequa-network/pkg/metrics"
 )
@@ -17,44 +20,40 @@ type Policy struct {
     MinHeight     uint64
     RoundWindow   uint64
     ReplayWindow  uint64
+    // ReplayMaxEntries and ReplayRetain bound the anti-replay cache (0 = defaults).
+    ReplayMaxEntries int
+    ReplayRetain     uint64
     TypeMinHeight map[Type]uint64
     TypeRoundMax  map[Type]uint64
     Allowed       []string
+    // Leader, if set, rejects preprepares whose sender does not lead the round.
+    Leader        LeaderFunc
+    // Keys, if set, requires valid ed25519 signatures from these operators.
+    Keys          map[string]ed25519.PublicKey
+    // Validators, if set, sizes the prepare quorum of prepared certificates
+    // and limits who may appear in them (default: Keys, then 2 prepares).
+    Validators    Validators
+    // ContentIDs rejects messages whose ID (and, for preprepares, ProposalID)
+    // does not match their content. Payloads validates proposed values and
+    // hashes them for ids (nil skips validation and hashes the raw bytes).
+    ContentIDs    bool
+    Payloads      payload.Manager
+    // RateLimits, if set, applies token buckets per From and per (From, Type)
+    // (see DefaultRateLimits). Now is their clock (nil = time.Now), e.g. the
+    // virtual clock of a replay.
+    RateLimits    RateLimits
+    Now           func() time.Time
+    // Rules are custom rules added to the pipeline. Without Order they run
+    // after the built-in rules.
+    Rules         []Rule
+    // Order, if set, lists the rules (built-in and custom, by name) to run
+    // and their order; rules not listed are skipped.
+    Order         []string
 }
 
 // DefaultPolicy returns a zero-valued policy that keeps current behavior.
 func DefaultPolicy() Policy { return Policy{} }
 
-type AntiReplay struct {
-    mu     sync.Mutex
-    seen   map[string]struct{}
-    hSeen  map[string]uint64
-}
-
-func NewAntiReplay() *AntiReplay { return &AntiReplay{seen: make(map[string]struct{}), hSeen: make(map[string]uint64)} }
-
-// Seen returns true if id already seen; otherwise records and returns false.
-func (r *AntiReplay) Seen(id string) bool {
-    if id == "" { return false }
-    r.mu.Lock()
-    defer r.mu.Unlock()
-    if _, ok := r.seen[id]; ok { return true }
-    r.seen[id] = struct{}{}
-    return false
-}
-
-// SeenWithin returns true if id was seen within the given height window.
-func (r *AntiReplay) SeenWithin(id string, h, window uint64) bool {
-    if id == "" || window == 0 { return false }
-    r.mu.Lock()
-    defer r.mu.Unlock()
-    if last, ok := r.hSeen[id]; ok {
-        if h >= last && h-last <= window { return true }
-    }
-    r.hSeen[id] = h
-    return false
-}
-
 type BasicVerifier struct {
     replay       *AntiReplay
     minHeight    uint64
@@ -64,18 +63,59 @@ type BasicVerifier struct {
     // type-scoped windows (placeholders; 0 disables)
     typeMinHeight map[Type]uint64
     typeRoundMax  map[Type]uint64
+    leader        LeaderFunc
+    keys          map[string]ed25519.PublicKey
+    validators    Validators
+    contentIDs    bool
+    payloads      payload.Manager
+    pipeline      *Pipeline
+    limiter       *rateLimiter
 }
 
-func NewBasicVerifier() *BasicVerifier { return &BasicVerifier{replay: NewAntiReplay()} }
+// NewBasicVerifier returns a verifier running the built-in rules in DefaultRuleOrder.
+func NewBasicVerifier() *BasicVerifier {
+    v := &BasicVerifier{replay: NewAntiReplay()}
+    v.pipeline = v.preset(nil, nil)
+    return v
+}
+
+// preset builds the pipeline for order (nil = DefaultRuleOrder) from the
+// built-in and custom rules. Unknown names are logged and skipped.
+func (v *BasicVerifier) preset(order []string, custom []Rule) *Pipeline {
+    byName := v.builtinRules()
+    for _, r := range custom { byName[r.Name()] = r }
+    if order == nil {
+        order = append([]string(nil), DefaultRuleOrder...)
+        for _, r := range custom { order = append(order, r.Name()) }
+    }
+    p := NewPipeline()
+    for _, name := range order {
+        r, ok := byName[name]
+        if !ok {
+            logger.ErrorJ("qbft_verify", map[string]any{"op":"pipeline", "result":"error", "reason":"unknown_rule", "rule": name, "trace_id": ""})
+            continue
+        }
+        p.Use(r)
+    }
+    return p
+}
 // NewBasicVerifierWithPolicy constructs a BasicVerifier configured from policy.
 func NewBasicVerifierWithPolicy(p Policy) *BasicVerifier {
     v := NewBasicVerifier()
+    if p.ReplayMaxEntries > 0 || p.ReplayRetain > 0 { v.replay = NewAntiReplayWithLimits(p.ReplayMaxEntries, p.ReplayRetain) }
     if p.MinHeight > 0 { v.minHeight = p.MinHeight }
     if p.RoundWindow > 0 { v.roundWindow = p.RoundWindow }
     if p.ReplayWindow > 0 { v.replayWindow = p.ReplayWindow }
     if len(p.TypeMinHeight) > 0 { v.typeMinHeight = p.TypeMinHeight }
     if len(p.TypeRoundMax) > 0 { v.typeRoundMax = p.TypeRoundMax }
     if len(p.Allowed) > 0 { v.SetAllowed(p.Allowed...) }
+    if p.Leader != nil { v.leader = p.Leader }
+    if len(p.Keys) > 0 { v.keys = p.Keys }
+    if p.Validators.Size() > 0 { v.validators = p.Validators }
+    if p.Payloads != nil { v.SetPayloadManager(p.Payloads) }
+    if p.ContentIDs { v.SetContentIDs(p.Payloads) }
+    if p.RateLimits.enabled() { v.SetRateLimits(p.RateLimits, p.Now) }
+    if len(p.Rules) > 0 || len(p.Order) > 0 { v.pipeline = v.preset(p.Order, p.Rules) }
     return v
 }
 
@@ -87,6 +127,27 @@ func (v *BasicVerifier) SetAllowed(ids ...string) {
 }
 func (v *BasicVerifier) SetReplayWindow(w uint64) { v.replayWindow = w }
 
+// Replay exposes the anti-replay cache (e.g. to advance its watermark).
+func (v *BasicVerifier) Replay() *AntiReplay { return v.replay }
+
+// SetKeys enables signature verification against operator keys (nil disables).
+func (v *BasicVerifier) SetKeys(keys map[string]ed25519.PublicKey) { v.keys = keys }
+
+// SetValidators sizes prepared-certificate quorums from the operator set.
+func (v *BasicVerifier) SetValidators(vs Validators) { v.validators = vs }
+
+// SetContentIDs enables content-addressed id checks, hashing proposals with mgr.
+func (v *BasicVerifier) SetContentIDs(mgr payload.Manager) { v.contentIDs, v.payloads = true, mgr }
+
+// SetRateLimits enables per-sender token buckets; now overrides the clock (nil = time.Now).
+func (v *BasicVerifier) SetRateLimits(l RateLimits, now func() time.Time) { v.limiter = newRateLimiter(l, now) }
+
+// SetPayloadManager validates proposed payloads with mgr (nil disables).
+func (v *BasicVerifier) SetPayloadManager(mgr payload.Manager) { v.payloads = mgr }
+
+// SetLeader enables leader checks for preprepares (nil disables).
+func (v *BasicVerifier) SetLeader(f LeaderFunc) { v.leader = f }
+
 // SetTypeMinHeight sets a per-type minimum acceptable height (0 disables for that type).
 func (v *BasicVerifier) SetTypeMinHeight(t Type, h uint64) {
     if v.typeMinHeight == nil { v.typeMinHeight = map[Type]uint64{} }
@@ -100,106 +161,285 @@ func (v *BasicVerifier) SetTypeRoundMax(t Type, max uint64) {
 }
 
 func validType(t Type) bool {
-    switch t { case MsgPreprepare, MsgPrepare, MsgCommit: return true }
+    switch t { case MsgPreprepare, MsgPrepare, MsgCommit, MsgRoundChange: return true }
     return false
 }
 
-func (v *BasicVerifier) Verify(msg Message) error {
-    labels := map[string]string{"type": string(msg.Type)}
-    // structural checks
+// sigValid checks the signature of msg and of any justification messages;
+// it returns the sender of the first invalid one.
+func (v *BasicVerifier) sigValid(msg Message) (string, bool) {
+    if k, ok := v.keys[msg.From]; !ok || !VerifySig(k, msg) { return msg.From, false }
+    for _, j := range msg.Justification {
+        if k, ok := v.keys[j.From]; !ok || !VerifySig(k, j) { return j.From, false }
+    }
+    return "", true
+}
+
+// idMismatch returns a reason when msg's ids do not match its content.
+func (v *BasicVerifier) idMismatch(msg Message) string {
+    if msg.Type == MsgPreprepare {
+        pid, err := ProposalIDOf(v.payloads, msg.Payload)
+        if err != nil || msg.ProposalID != pid { return "proposal_id_mismatch" }
+    } else if msg.Type == MsgRoundChange && msg.PreparedID != "" {
+        pid, err := ProposalIDOf(v.payloads, msg.Payload)
+        if err != nil || msg.PreparedID != pid { return "prepared_id_mismatch" }
+    } else if msg.Type != MsgRoundChange && msg.ProposalID == "" {
+        return "proposal_id_missing"
+    }
+    if msg.ID != ContentID(msg) { return "id_mismatch" }
+    return ""
+}
+
+// Built-in rule names, in DefaultRuleOrder. Each is also the stable
+// rule label of qbft_rule_rejected_total.
+const (
+    RuleStructure       = "structure"
+    RuleAllowlist       = "allowlist"
+    RulePayload         = "payload"
+    RuleContentID       = "content_id"
+    RuleLeader          = "leader"
+    RuleSignature       = "signature"
+    RulePreprepareRound = "preprepare_round"
+    RulePreparedCert    = "prepared_cert"
+    RuleMinHeight       = "min_height"
+    RuleRoundWindow     = "round_window"
+    RuleReplay          = "replay"
+    RuleVoteRound       = "vote_round"
+    RuleTypeMinHeight   = "type_min_height"
+    RuleTypeRoundMax    = "type_round_max"
+    RuleRateLimit       = "rate_limit"
+)
+
+// DefaultRuleOrder is the order the built-in rules run in unless
+// Policy.Order overrides it.
+var DefaultRuleOrder = []string{
+    RuleStructure, RuleAllowlist, RulePayload, RuleContentID, RuleLeader, RuleSignature, RulePreprepareRound, RulePreparedCert,
+    RuleMinHeight, RuleRoundWindow, RuleReplay, RuleVoteRound, RuleTypeMinHeight, RuleTypeRoundMax, RuleRateLimit,
+}
+
+// builtinRules returns the built-in rules by name. They read the verifier's
+// current settings, so setters keep working after construction.
+func (v *BasicVerifier) builtinRules() map[string]Rule {
+    return map[string]Rule{
+        RuleStructure:       RuleFunc(RuleStructure, v.checkStructure),
+        RuleAllowlist:       RuleFunc(RuleAllowlist, v.checkAllowlist),
+        RulePayload:         RuleFunc(RulePayload, v.checkPayload),
+        RuleContentID:       RuleFunc(RuleContentID, v.checkContentID),
+        RuleLeader:          RuleFunc(RuleLeader, v.checkLeader),
+        RuleSignature:       RuleFunc(RuleSignature, v.checkSignature),
+        RuleRateLimit:       RuleFunc(RuleRateLimit, v.checkRateLimit),
+        RulePreprepareRound: RuleFunc(RulePreprepareRound, checkPreprepareRound),
+        RulePreparedCert:    RuleFunc(RulePreparedCert, v.checkPreparedCert),
+        RuleMinHeight:       RuleFunc(RuleMinHeight, v.checkMinHeight),
+        RuleRoundWindow:     RuleFunc(RuleRoundWindow, v.checkRoundWindow),
+        RuleReplay:          RuleFunc(RuleReplay, v.checkReplay),
+        RuleVoteRound:       RuleFunc(RuleVoteRound, checkVoteRound),
+        RuleTypeMinHeight:   RuleFunc(RuleTypeMinHeight, v.checkTypeMinHeight),
+        RuleTypeRoundMax:    RuleFunc(RuleTypeRoundMax, v.checkTypeRoundMax),
+    }
+}
+
+// Verify runs the verifier's rule pipeline on msg.
+func (v *BasicVerifier) Verify(msg Message) error { return v.accept(msg, v.pipeline.Verify(msg)) }
+
+// VerifyRecovered verifies a message read back from this node's own
+// write-ahead log. Rate limits are skipped: the message was already charged
+// when it first arrived, and a recovery burst must not be throttled.
+func (v *BasicVerifier) VerifyRecovered(msg Message) error {
+    return v.accept(msg, v.pipeline.Without(RuleRateLimit).Verify(msg))
+}
+
+// accept records msg in the anti-replay cache once the whole pipeline passed
+// it. The replay rule only checks: recording there would mark a message that a
+// later rule (rate_limit or a custom rule) then rejects, and its honest resend
+// would be refused as a replay forever.
+func (v *BasicVerifier) accept(msg Message, err error) error {
+    if err != nil || v.replay == nil { return err }
+    if v.replayWindow > 0 { v.replay.SeenWithin(msg.ID, msg.Height, v.replayWindow) } else { v.replay.SeenAt(msg.ID, msg.Height) }
+    return nil
+}
+
+// Pipeline exposes the rule pipeline, e.g. to register custom rules.
+func (v *BasicVerifier) Pipeline() *Pipeline { return v.pipeline }
+
+// Use appends a custom rule to the pipeline.
+func (v *BasicVerifier) Use(r Rule) { v.pipeline.Use(r) }
+
+func (v *BasicVerifier) checkStructure(msg Message) error {
     if msg.ID == "" || msg.From == "" || !validType(msg.Type) {
-        metrics.Inc("qbft_msg_verified_total", map[string]string{"result":"error"})
-        logger.ErrorJ("qbft_verify", map[string]any{"result":"error", "reason":"invalid", "type": string(msg.Type), "trace_id": msg.TraceID})
-        return fmt.Errorf("invalid message")
+        return reject("error", "invalid", "invalid message", nil)
     }
-    // optional sender whitelist
+    return nil
+}
+
+// checkAllowlist applies the optional sender whitelist.
+func (v *BasicVerifier) checkAllowlist(msg Message) error {
     if len(v.allowed) > 0 {
-        if _, ok := v.allowed[msg.From]; !ok {
-            metrics.Inc("qbft_msg_verified_total", map[string]string{"result":"unauthorized"})
-            logger.ErrorJ("qbft_verify", map[string]any{"result":"unauthorized", "from": msg.From, "type": string(msg.Type), "trace_id": msg.TraceID})
-            return fmt.Errorf("unauthorized")
-        }
+        if _, ok := v.allowed[msg.From]; !ok { return reject("unauthorized", "not_allowed", "unauthorized", nil) }
+    }
+    return nil
+}
+
+// checkPayload validates proposed values (preprepares and prepared
+// roundchanges) with the payload manager, if one is configured.
+func (v *BasicVerifier) checkPayload(msg Message) error {
+    if v.payloads == nil { return nil }
+    if msg.Type != MsgPreprepare && !(msg.Type == MsgRoundChange && msg.PreparedID != "") { return nil }
+    if reason := payloadReason(v.payloads, msg.Payload); reason != "" {
+        metrics.Inc("qbft_payload_rejected_total", map[string]string{"reason": reason})
+        return reject("error", reason, reason, map[string]any{"size": len(msg.Payload), "max": v.payloads.MaxSize()})
     }
-    // signature shape placeholder (no crypto)
-    if l := len(msg.Sig); l > 0 && l < 32 {
-        metrics.Inc("qbft_msg_verified_total", map[string]string{"result":"sig_invalid"})
-        logger.ErrorJ("qbft_verify", map[string]any{"result":"sig_invalid", "type": string(msg.Type), "trace_id": msg.TraceID})
-        return fmt.Errorf("sig invalid")
+    return nil
+}
+
+// payloadReason maps a payload validation failure to its rejection reason.
+func payloadReason(mgr payload.Manager, b []byte) string {
+    err := mgr.Validate(b)
+    switch {
+    case err == nil:
+        return ""
+    case errors.Is(err, payload.ErrTooLarge):
+        return "payload_oversize"
+    default:
+        return "payload_invalid"
     }
-    // context semantic: preprepare must have round == 0 (placeholder constraint)
-    if msg.Type == MsgPreprepare {
-        if msg.Round != 0 {
-            metrics.Inc("qbft_msg_verified_total", map[string]string{"result":"error"})
-            logger.ErrorJ("qbft_verify", map[string]any{"result":"error", "reason":"round_semantic", "type": string(msg.Type), "round": msg.Round, "trace_id": msg.TraceID})
-            return fmt.Errorf("invalid round for preprepare")
-        }
+}
+
+// checkContentID requires declared ids to match the message content.
+func (v *BasicVerifier) checkContentID(msg Message) error {
+    if !v.contentIDs { return nil }
+    if reason := v.idMismatch(msg); reason != "" {
+        return reject("error", reason, reason, map[string]any{"proposal_id": msg.ProposalID})
     }
-    // height window (old height)
-    if v.minHeight > 0 && msg.Height < v.minHeight {
-        metrics.Inc("qbft_msg_verified_total", map[string]string{"result":"old"})
-        logger.ErrorJ("qbft_verify", map[string]any{"result":"old", "height": msg.Height, "min": v.minHeight, "type": string(msg.Type), "trace_id": msg.TraceID})
-        return fmt.Errorf("old height")
+    return nil
+}
+
+// checkLeader lets only the round leader propose.
+func (v *BasicVerifier) checkLeader(msg Message) error {
+    if msg.Type != MsgPreprepare || v.leader == nil { return nil }
+    if expect := v.leader(msg.Height, msg.Round); msg.From != expect {
+        return reject("unauthorized", "not_leader", "not leader", map[string]any{"expect": expect})
     }
-    // round window (upper bound)
-    if v.roundWindow > 0 && msg.Round > v.roundWindow {
-        metrics.Inc("qbft_msg_verified_total", map[string]string{"result":"round_oob"})
-        logger.ErrorJ("qbft_verify", map[string]any{"result":"round_oob", "round": msg.Round, "max": v.roundWindow, "type": string(msg.Type), "trace_id": msg.TraceID})
-        return fmt.Errorf("round out of bound")
-    }
-    // anti-replay: prefer height-windowed replay if configured; otherwise id-level replay
-    if v.replay != nil {
-        if v.replayWindow > 0 {
-            if v.replay.SeenWithin(msg.ID, msg.Height, v.replayWindow) {
-                metrics.Inc("qbft_msg_verified_total", map[string]string{"result":"replay"})
-                logger.ErrorJ("qbft_verify", map[string]any{"result":"replay", "id": msg.ID, "type": string(msg.Type), "window": v.replayWindow, "trace_id": msg.TraceID})
-                return fmt.Errorf("replay")
-            }
-        } else {
-            if v.replay.Seen(msg.ID) {
-                metrics.Inc("qbft_msg_verified_total", map[string]string{"result":"replay"})
-                logger.ErrorJ("qbft_verify", map[string]any{"result":"replay", "id": msg.ID, "type": string(msg.Type), "trace_id": msg.TraceID})
-                return fmt.Errorf("replay")
-            }
+    return nil
+}
+
+// checkSignature verifies ed25519 signatures against operator keys when
+// configured, otherwise only the signature shape.
+func (v *BasicVerifier) checkSignature(msg Message) error {
+    if len(v.keys) > 0 {
+        if from, ok := v.sigValid(msg); !ok {
+            return reject("sig_invalid", "bad_signature", "sig invalid", map[string]any{"from": from})
         }
+    } else if l := len(msg.Sig); l > 0 && l < 32 {
+        return reject("sig_invalid", "bad_shape", "sig invalid", nil)
     }
+    return nil
+}
 
-    // context semantics (placeholder, non-breaking):
-    // - preprepare must have round == 0 (added earlier)
-    if msg.Type == MsgPreprepare {
-        if msg.Round != 0 {
-            metrics.Inc("qbft_msg_verified_total", map[string]string{"result":"error"})
-            logger.ErrorJ("qbft_verify", map[string]any{"result":"error", "reason":"round_semantic", "type": string(msg.Type), "round": msg.Round, "trace_id": msg.TraceID})
-            return fmt.Errorf("invalid round for preprepare")
-        }
+// checkRateLimit runs last: after signature checks so a spoofed From cannot
+// drain an honest operator's budget (or claim an exempt one), and after replay
+// and the cheap checks so duplicates and malformed messages do not consume tokens.
+func (v *BasicVerifier) checkRateLimit(msg Message) error {
+    if v.limiter == nil { return nil }
+    if reason, ok := v.limiter.allow(msg); !ok {
+        return reject("rate_limited", reason, "rate limited", nil)
     }
-    // - prepare/commit must have round >= 1
-    if msg.Type == MsgPrepare || msg.Type == MsgCommit {
-        if msg.Round < 1 {
-            metrics.Inc("qbft_msg_verified_total", map[string]string{"result":"error"})
-            logger.ErrorJ("qbft_verify", map[string]any{"result":"error", "reason":"round_semantic", "type": string(msg.Type), "round": msg.Round, "trace_id": msg.TraceID})
-            return fmt.Errorf("invalid round for %s", msg.Type)
-        }
+    return nil
+}
+
+// checkPreprepareRound: a preprepare must have round == 0 unless justified
+// by a roundchange quorum.
+func checkPreprepareRound(msg Message) error {
+    if msg.Type == MsgPreprepare && msg.Round != 0 && len(msg.Justification) == 0 {
+        return reject("error", "round_semantic", "invalid round for preprepare", nil)
     }
+    return nil
+}
 
-    // type-scoped windows (preserve metric label space; use reason in logs)
-    if v.typeMinHeight != nil {
-        if min, ok := v.typeMinHeight[msg.Type]; ok && min > 0 && msg.Height < min {
-            metrics.Inc("qbft_msg_verified_total", map[string]string{"result":"old"})
-            logger.ErrorJ("qbft_verify", map[string]any{"result":"old", "reason":"type_height_old", "type": string(msg.Type), "height": msg.Height, "min": min, "trace_id": msg.TraceID})
-            return fmt.Errorf("type-scoped old height")
+// checkPreparedCert requires a prepared claim to carry its certificate: a
+// roundchange naming a prepared value from an earlier round must include a
+// quorum of prepares for it, and a preprepare above round 0 must re-propose
+// the highest prepared value among its roundchanges with those prepares.
+// Every justification message must belong to the same instance (duty and
+// height) as msg.
+func (v *BasicVerifier) checkPreparedCert(msg Message) error {
+    for _, j := range msg.Justification {
+        if j.Duty != msg.Duty || j.Height != msg.Height {
+            return reject("error", "foreign_justification", "justification from another instance", map[string]any{"duty": j.Duty, "height": j.Height})
         }
     }
-    if v.typeRoundMax != nil {
-        if max, ok := v.typeRoundMax[msg.Type]; ok && max > 0 && msg.Round > max {
-            metrics.Inc("qbft_msg_verified_total", map[string]string{"result":"round_oob"})
-            logger.ErrorJ("qbft_verify", map[string]any{"result":"round_oob", "reason":"type_round_oob", "type": string(msg.Type), "round": msg.Round, "max": max, "trace_id": msg.TraceID})
-            return fmt.Errorf("type-scoped round out of bound")
+    switch {
+    case msg.Type == MsgRoundChange && msg.PreparedID != "":
+        if msg.PreparedRound >= msg.Round || !v.certified(msg.Justification, msg.Duty, msg.Height, msg.PreparedRound, msg.PreparedID) {
+            return reject("error", "unjustified_prepared", "unjustified prepared value", map[string]any{"prepared_round": msg.PreparedRound})
+        }
+    case msg.Type == MsgPreprepare && msg.Round > 0:
+        rcs, prepares := splitJustification(msg.Justification)
+        best := highestPrepared(rcs, msg.Duty, msg.Height, msg.Round)
+        if best == nil { return nil }
+        if ProposalRef(msg) != best.PreparedID || !v.certified(prepares, msg.Duty, msg.Height, best.PreparedRound, best.PreparedID) {
+            return reject("error", "unjustified_prepared", "unjustified prepared value", map[string]any{"prepared_round": best.PreparedRound})
         }
     }
-    // id-level anti-replay handled above when window is disabled
-    // ok
-    metrics.Inc("qbft_msg_verified_total", labels)
-    logger.InfoJ("qbft_verify", map[string]any{"result":"ok", "id": msg.ID, "type": string(msg.Type), "trace_id": msg.TraceID})
     return nil
 }
 
+// certified reports whether prepares hold a quorum of operator prepares for
+// id at (duty, height, round). Signatures are checked by checkSignature.
+func (v *BasicVerifier) certified(prepares []Message, duty string, height, round uint64, id string) bool {
+    quorum, member := minPrepareVotes, func(string) bool { return true }
+    switch {
+    case v.validators.Size() > 0:
+        quorum, member = v.validators.Quorum(), v.validators.Contains
+    case len(v.keys) > 0:
+        quorum = (2*len(v.keys) + 2) / 3
+        member = func(id string) bool { _, ok := v.keys[id]; return ok }
+    }
+    return preparedVotes(prepares, duty, height, round, id, member) >= quorum
+}
+
+func (v *BasicVerifier) checkMinHeight(msg Message) error {
+    if v.minHeight > 0 && msg.Height < v.minHeight {
+        return reject("old", "height_old", "old height", map[string]any{"min": v.minHeight})
+    }
+    return nil
+}
+
+func (v *BasicVerifier) checkRoundWindow(msg Message) error {
+    if v.roundWindow > 0 && msg.Round > v.roundWindow {
+        return reject("round_oob", "round_oob", "round out of bound", map[string]any{"max": v.roundWindow})
+    }
+    return nil
+}
+
+// checkReplay prefers height-windowed replay if configured; otherwise id-level
+// replay. It only looks the id up; accept records it after the pipeline passes.
+func (v *BasicVerifier) checkReplay(msg Message) error {
+    if v.replay == nil || !v.replay.Known(msg.ID, msg.Height, v.replayWindow) { return nil }
+    if v.replayWindow > 0 { return reject("replay", "replay", "replay", map[string]any{"window": v.replayWindow}) }
+    return reject("replay", "replay", "replay", nil)
+}
+
+// checkVoteRound: votes carry the round of the proposal they vote for (0 is
+// the initial round), but a roundchange always targets round >= 1.
+func checkVoteRound(msg Message) error {
+    if msg.Type == MsgRoundChange && msg.Round < 1 {
+        return reject("error", "round_semantic", fmt.Sprintf("invalid round for %s", msg.Type), nil)
+    }
+    return nil
+}
+
+// checkTypeMinHeight applies type-scoped height windows (same result label, own reason).
+func (v *BasicVerifier) checkTypeMinHeight(msg Message) error {
+    if min, ok := v.typeMinHeight[msg.Type]; ok && min > 0 && msg.Height < min {
+        return reject("old", "type_height_old", "type-scoped old height", map[string]any{"min": min})
+    }
+    return nil
+}
+
+func (v *BasicVerifier) checkTypeRoundMax(msg Message) error {
+    if max, ok := v.typeRoundMax[msg.Type]; ok && max > 0 && msg.Round > max {
+        return reject("round_oob", "type_round_oob", "type-scoped round out of bound", map[string]any{"max": max})
+    }
+    return nil
+}
@@ -187,27 +187,21 @@ func TestBasicVerifier_ReplayOutsideWindowAccept(t *testing.T) {
     if err := v.Verify(Message{ID:"rid2", From:"p", Type:MsgPrepare, Height:103, Round:1}); err != nil { t.Fatalf("outside window should pass: %v", err) }
 }
 
-func TestBasicVerifier_Prepare_RoundZero_Error(t *testing.T) {
+// Votes for the initial proposal carry its round, 0.
+func TestBasicVerifier_Prepare_RoundZero_OK(t *testing.T) {
     metrics.Reset()
     v := NewBasicVerifier()
-    if err := v.Verify(Message{ID:"pr0", From:"p", Type:MsgPrepare, Round:0}); err == nil {
-        t.Fatalf("want prepare round semantic error")
-    }
-    dump := metrics.DumpProm()
-    if !strings.Contains(dump, `qbft_msg_verified_total{result="error"} 1`) {
-        t.Fatalf("want error=1, got %q", dump)
+    if err := v.Verify(Message{ID:"pr0", From:"p", Type:MsgPrepare, Round:0}); err != nil {
+        t.Fatalf("round-0 prepare: %v", err)
     }
 }
 
-func TestBasicVerifier_Commit_RoundZero_Error(t *testing.T) {
+// Votes for the initial proposal carry its round, 0.
+func TestBasicVerifier_Commit_RoundZero_OK(t *testing.T) {
     metrics.Reset()
     v := NewBasicVerifier()
-    if err := v.Verify(Message{ID:"cm0", From:"p", Type:MsgCommit, Round:0}); err == nil {
-        t.Fatalf("want commit round semantic error")
-    }
-    dump := metrics.DumpProm()
-    if !strings.Contains(dump, `qbft_msg_verified_total{result="error"} 1`) {
-        t.Fatalf("want error=1, got %q", dump)
+    if err := v.Verify(Message{ID:"cm0", From:"p", Type:MsgCommit, Round:0}); err != nil {
+        t.Fatalf("round-0 commit: %v", err)
     }
 }
 
@@ -349,3 +343,40 @@ func TestBasicVerifier_Commit_SenderUnauthorized(t *testing.T) {
         t.Fatalf("want unauthorized=1, got %q", dump)
     }
 }
+
+func TestBasicVerifier_Preprepare_JustifiedHigherRound_OK(t *testing.T) {
+    metrics.Reset()
+    v := NewBasicVerifier()
+    just := []Message{{ID: "rc", From: "q", Type: MsgRoundChange, Round: 2}}
+    if err := v.Verify(Message{ID: "pp2", From: "p", Type: MsgPreprepare, Round: 2, Justification: just}); err != nil {
+        t.Fatalf("justified preprepare should pass: %v", err)
+    }
+    if err := v.Verify(Message{ID: "rc0", From: "p", Type: MsgRoundChange, Round: 0}); err == nil {
+        t.Fatalf("want roundchange round semantic error")
+    }
+}
+
+// A prepared claim must carry a quorum of prepares: in a roundchange for an
+// earlier round, and in a preprepare for the value it re-proposes.
+func TestBasicVerifier_PreparedCertificate(t *testing.T) {
+    ids := []string{"a", "b", "c", "d"}
+    v := NewBasicVerifierWithPolicy(Policy{Validators: NewValidators(ids, 0)})
+    rc := Message{ID: "rc", From: "a", Type: MsgRoundChange, Height: 1, Round: 2, PreparedRound: 1, PreparedID: "x", Justification: preparesFor("x", 1, 1, "a", "b")}
+    if err := v.Verify(rc); err == nil || !strings.Contains(err.Error(), "unjustified") { t.Fatalf("want unjustified_prepared below quorum, got %v", err) }
+    rc.ID, rc.Justification = "rc2", append(preparesFor("x", 1, 1, "a", "b"), preparesFor("x", 1, 1, "e")...)
+    if err := v.Verify(rc); err == nil { t.Fatalf("non-member prepares must not count") }
+    rc.ID, rc.Justification = "rc3", preparesFor("x", 1, 1, "a", "b", "c")
+    if err := v.Verify(rc); err != nil { t.Fatalf("certified roundchange: %v", err) }
+    rc.ID, rc.PreparedRound = "rc4", 2
+    if err := v.Verify(rc); err == nil { t.Fatalf("want unjustified_prepared for prepared_round == round") }
+
+    stripped := rc
+    stripped.PreparedRound, stripped.Justification = 1, nil
+    just := []Message{stripped, {From: "b", Type: MsgRoundChange, Height: 1, Round: 2}, {From: "c", Type: MsgRoundChange, Height: 1, Round: 2}}
+    pp := Message{ID: "pp", From: "c", Type: MsgPreprepare, Height: 1, Round: 2, ProposalID: "x", Justification: just}
+    if err := v.Verify(pp); err == nil { t.Fatalf("want unjustified_prepared without prepares") }
+    pp.ID, pp.ProposalID, pp.Justification = "pp2", "y", append(just, preparesFor("x", 1, 1, "a", "b", "c")...)
+    if err := v.Verify(pp); err == nil { t.Fatalf("want unjustified_prepared for another value") }
+    pp.ID, pp.ProposalID = "pp3", "x"
+    if err := v.Verify(pp); err != nil { t.Fatalf("certified preprepare: %v", err) }
+}
@@ -0,0 +1,221 @@
+package consensus
+
+import (
+    "bufio"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "hash/crc32"
+    "io"
+    "os"
+    "sync"
+    "time"
+
+    qbft "github.com/zmlAEQ/Aequa-network/internal/consensus/qbft"
+)
+
+// Recording file format: the magic "QREC" and a version byte, then records
+//
+//   [len u32][crc32c u32][kind u8][at i64 unix ns][flags u8][result str16][body]
+//
+// where str16 is [len u16][data] and body is the qbft.Marshal encoding of a
+// message, [duty str16][height u64][value] for a duty, and empty for a tick.
+const (
+    recordingMagic   = "QREC"
+    recordingVersion = 1
+    maxRecordSize    = 4 << 20
+)
+
+// RecordKind distinguishes what a Record captured.
+type RecordKind uint8
+
+const (
+    // RecordMessage is a consensus message handed to the verifier.
+    RecordMessage RecordKind = 1
+    // RecordDuty is a duty starting an instance with this node's input.
+    RecordDuty RecordKind = 2
+    // RecordTick is a tick of the round timers (recorded only while
+    // instances are live).
+    RecordTick RecordKind = 3
+)
+
+const flagRecovered = 1
+
+// ErrRecordingTruncated is returned with the records read before a torn or
+// corrupt tail, e.g. after a crash.
+var ErrRecordingTruncated = errors.New("recording truncated")
+
+// Record is one entry of a recording.
+type Record struct {
+    Kind RecordKind
+    At   time.Time
+    // Msg and Result (the verifier's verdict, see VerifyResult) are set for
+    // messages; Recovered marks messages read back from the WAL on startup.
+    Msg       qbft.Message
+    Result    string
+    Recovered bool
+    // Key and Value are set for duties.
+    Key   qbft.InstanceKey
+    Value []byte
+}
+
+// VerifyResult is the recorded form of a verifier verdict: "ok", or the
+// reason of the rule that rejected the message.
+func VerifyResult(err error) string {
+    if err == nil { return "ok" }
+    var re *qbft.RuleError
+    if errors.As(err, &re) { return re.Reason }
+    return err.Error()
+}
+
+// Recorder appends records to a recording file. It is safe for concurrent use.
+type Recorder struct {
+    mu sync.Mutex
+    f  *os.File
+    w  *bufio.Writer
+}
+
+// CreateRecording creates (or truncates) the recording file at path.
+func CreateRecording(path string) (*Recorder, error) {
+    f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+    if err != nil { return nil, err }
+    r := &Recorder{f: f, w: bufio.NewWriter(f)}
+    if _, err := r.w.Write(append([]byte(recordingMagic), recordingVersion)); err != nil {
+        _ = f.Close()
+        return nil, err
+    }
+    return r, nil
+}
+
+// Message records msg as handed to the verifier at at, with its verdict.
+func (r *Recorder) Message(at time.Time, msg qbft.Message, verr error, recovered bool) error {
+    b, err := qbft.Marshal(msg)
+    if err != nil { return err }
+    var flags byte
+    if recovered { flags |= flagRecovered }
+    return r.write(RecordMessage, at, flags, VerifyResult(verr), b)
+}
+
+// Duty records the start of the instance for key with value as input.
+func (r *Recorder) Duty(at time.Time, key qbft.InstanceKey, value []byte) error {
+    body := appendStr16(nil, key.Duty)
+    body = binary.BigEndian.AppendUint64(body, key.Height)
+    return r.write(RecordDuty, at, 0, "", append(body, value...))
+}
+
+// Tick records a tick of the round timers at at.
+func (r *Recorder) Tick(at time.Time) error { return r.write(RecordTick, at, 0, "", nil) }
+
+// Flush writes buffered records to the file.
+func (r *Recorder) Flush() error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    if r.f == nil { return nil }
+    return r.w.Flush()
+}
+
+// Close flushes and closes the file.
+func (r *Recorder) Close() error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    if r.f == nil { return nil }
+    err := r.w.Flush()
+    if cerr := r.f.Close(); err == nil { err = cerr }
+    r.f = nil
+    return err
+}
+
+func (r *Recorder) write(kind RecordKind, at time.Time, flags byte, result string, body []byte) error {
+    if len(result) > 0xffff { result = result[:0xffff] }
+    rec := []byte{byte(kind)}
+    rec = binary.BigEndian.AppendUint64(rec, uint64(at.UnixNano()))
+    rec = append(rec, flags)
+    rec = appendStr16(rec, result)
+    rec = append(rec, body...)
+    if len(rec) > maxRecordSize { return fmt.Errorf("recording: record of %d bytes exceeds limit", len(rec)) }
+    var hdr [8]byte
+    binary.BigEndian.PutUint32(hdr[0:4], uint32(len(rec)))
+    binary.BigEndian.PutUint32(hdr[4:8], crc32.Checksum(rec, crcTable))
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    if r.f == nil { return errors.New("recording: closed") }
+    // bufio errors are sticky: after a failed header nothing more is
+    // written, so the file ends in a torn record rather than a corrupt one.
+    if _, err := r.w.Write(hdr[:]); err != nil { return err }
+    _, err := r.w.Write(rec)
+    return err
+}
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+func appendStr16(b []byte, s string) []byte {
+    b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+    return append(b, s...)
+}
+
+// ReadRecording decodes a recording. A torn or corrupt tail ends the read:
+// the records before it are returned with ErrRecordingTruncated.
+func ReadRecording(r io.Reader) ([]Record, error) {
+    br := bufio.NewReader(r)
+    head := make([]byte, len(recordingMagic)+1)
+    if _, err := io.ReadFull(br, head); err != nil || string(head[:4]) != recordingMagic {
+        return nil, errors.New("recording: bad header")
+    }
+    if head[4] != recordingVersion { return nil, fmt.Errorf("recording: unsupported version %d", head[4]) }
+    var out []Record
+    var hdr [8]byte
+    for {
+        if _, err := io.ReadFull(br, hdr[:]); err != nil {
+            if err == io.EOF { return out, nil }
+            return out, ErrRecordingTruncated
+        }
+        n := binary.BigEndian.Uint32(hdr[0:4])
+        if n > maxRecordSize { return out, ErrRecordingTruncated }
+        b := make([]byte, n)
+        if _, err := io.ReadFull(br, b); err != nil || crc32.Checksum(b, crcTable) != binary.BigEndian.Uint32(hdr[4:8]) {
+            return out, ErrRecordingTruncated
+        }
+        rec, err := decodeRecord(b)
+        if err != nil { return out, ErrRecordingTruncated }
+        out = append(out, rec)
+    }
+}
+
+// ReadRecordingFile reads the recording at path.
+func ReadRecordingFile(path string) ([]Record, error) {
+    f, err := os.Open(path)
+    if err != nil { return nil, err }
+    defer f.Close()
+    return ReadRecording(f)
+}
+
+func decodeRecord(b []byte) (Record, error) {
+    short := errors.New("recording: short record")
+    if len(b) < 12 { return Record{}, short }
+    rec := Record{Kind: RecordKind(b[0]), At: time.Unix(0, int64(binary.BigEndian.Uint64(b[1:9]))), Recovered: b[9]&flagRecovered != 0}
+    result, rest, ok := readStr16(b[10:])
+    if !ok { return Record{}, short }
+    rec.Result = result
+    switch rec.Kind {
+    case RecordMessage:
+        msg, err := qbft.Unmarshal(rest)
+        if err != nil { return Record{}, err }
+        rec.Msg = msg
+    case RecordDuty:
+        duty, rest, ok := readStr16(rest)
+        if !ok || len(rest) < 8 { return Record{}, short }
+        rec.Key = qbft.InstanceKey{Duty: duty, Height: binary.BigEndian.Uint64(rest[:8])}
+        rec.Value = append([]byte(nil), rest[8:]...)
+    case RecordTick:
+    default:
+        return Record{}, fmt.Errorf("recording: unknown record kind %d", rec.Kind)
+    }
+    return rec, nil
+}
+
+func readStr16(b []byte) (string, []byte, bool) {
+    if len(b) < 2 { return "", nil, false }
+    n := int(binary.BigEndian.Uint16(b))
+    if len(b) < 2+n { return "", nil, false }
+    return string(b[2 : 2+n]), b[2+n:], true
+}
@@ -0,0 +1,175 @@
+package consensus
+
+import (
+    "bufio"
+    "encoding/json"
+    "fmt"
+    "io"
+    "time"
+
+    "github.com/zmlAEQ/Aequa-network/internal/consensus/payload"
+    qbft "github.com/zmlAEQ/Aequa-network/internal/consensus/qbft"
+    "github.com/zmlAEQ/Aequa-network/internal/state"
+    "github.com/zmlAEQ/Aequa-network/pkg/config"
+)
+
+// ReplayOptions configures a replay like the node that made the recording.
+type ReplayOptions struct {
+    Lock *config.ClusterLock
+    // Self is the recording node's operator id. It attributes log lines and
+    // is exempt from rate limits, as on the recording node.
+    Self         string
+    Payloads     payload.Manager
+    ReplayRetain uint64
+    // RateLimits are the limits the node ran with (nil = qbft.DefaultRateLimits).
+    RateLimits   *qbft.RateLimits
+}
+
+// ReplayMismatch is a recorded message whose verdict differs on replay.
+type ReplayMismatch struct {
+    Index  int
+    Record Record
+    Got    string
+}
+
+func (m ReplayMismatch) String() string {
+    msg := m.Record.Msg
+    return fmt.Sprintf("#%d %s from %s %s/%d/%d id=%s: recorded %s, replayed %s", m.Index, msg.Type, msg.From, msg.Duty, msg.Height, msg.Round, msg.ID, m.Record.Result, m.Got)
+}
+
+// Replay feeds recs through the verifier and instance manager Service.Start
+// would build from opts, on a virtual clock set to each record's time; round
+// timers run at the recorded ticks and rate limits refill on that clock. The replaying node is passive: it sends
+// nothing, and its own messages come from the recording like everyone else's.
+// State restored from a snapshot before the recording started is not
+// reproduced. It returns the messages whose verdict differs from the recorded
+// one, or an error if the lock in opts is invalid.
+func Replay(recs []Record, opts ReplayOptions) ([]ReplayMismatch, error) {
+    s := &Service{lock: opts.Lock, self: opts.Self, payloads: opts.Payloads, replayRetain: opts.ReplayRetain, rateLimits: opts.RateLimits, evidence: state.NewMemoryEvidenceStore()}
+    if err := s.loadValidators(); err != nil { return nil, err }
+    var now time.Time
+    clock := func() time.Time { return now }
+    // Rate limits refill on the recorded clock, not at replay speed.
+    s.now = clock
+    v := s.defaultVerifier()
+    s.v = v
+    mgr := qbft.NewInstanceManager(0, 0, func(k qbft.InstanceKey) *qbft.State {
+        st := s.newState(k)
+        st.Self, st.Broadcast, st.Now = opts.Self, nil, clock
+        return st
+    })
+    mgr.SetClock(clock)
+    s.st = mgr
+
+    var out []ReplayMismatch
+    for i, rec := range recs {
+        now = rec.At
+        switch rec.Kind {
+        case RecordTick:
+            _ = mgr.Tick(now)
+        case RecordDuty:
+            _ = mgr.Propose(rec.Key, rec.Value)
+        case RecordMessage:
+            verify := v.Verify
+            if rec.Recovered { verify = v.VerifyRecovered }
+            err := verify(rec.Msg)
+            if got := VerifyResult(err); got != rec.Result { out = append(out, ReplayMismatch{Index: i, Record: rec, Got: got}) }
+            if err == nil { _ = mgr.Process(rec.Msg) }
+        }
+    }
+    return out, nil
+}
+
+// Transition is a qbft_state log line recording a state change, a rejected
+// message, a round change or a decision.
+type Transition struct {
+    Node      string `json:"node"`
+    Op        string `json:"op"`
+    EventType string `json:"event_type"`
+    Duty      string `json:"duty"`
+    Height    uint64 `json:"height"`
+    Round     uint64 `json:"round"`
+    Phase     string `json:"phase"`
+    Proposal  string `json:"proposal"`
+    Reason    string `json:"reason"`
+    Note      string `json:"note"`
+    TraceID   string `json:"trace_id"`
+}
+
+func (t Transition) String() string {
+    s := fmt.Sprintf("%s %s %s/%d/%d", t.Op, t.EventType, t.Duty, t.Height, t.Round)
+    for _, kv := range [][2]string{{"phase", t.Phase}, {"proposal", t.Proposal}, {"reason", t.Reason}, {"note", t.Note}, {"trace_id", t.TraceID}} {
+        if kv[1] != "" { s += " " + kv[0] + "=" + kv[1] }
+    }
+    return s
+}
+
+// ParseTransitions returns the transitions of a JSON log, in order. Other
+// lines are skipped.
+func ParseTransitions(r io.Reader) ([]Transition, error) {
+    sc := bufio.NewScanner(r)
+    sc.Buffer(nil, 1<<20)
+    var out []Transition
+    for sc.Scan() {
+        b := sc.Bytes()
+        if len(b) == 0 || b[0] != '{' { continue }
+        var line struct {
+            Msg string `json:"msg"`
+            Transition
+        }
+        if err := json.Unmarshal(b, &line); err != nil || line.Msg != "qbft_state" { continue }
+        switch line.Op {
+        case "transition", "round_change", "decided":
+            out = append(out, line.Transition)
+        }
+    }
+    return out, sc.Err()
+}
+
+// maxDiffCells bounds the quadratic diff; larger inputs are reported as one
+// replaced block after their common prefix and suffix.
+const maxDiffCells = 4 << 20
+
+// DiffTransitions returns an edit script turning want into got: "- " lines
+// only in want, "+ " lines only in got. It is empty when both are equal.
+func DiffTransitions(want, got []Transition) []string {
+    pre := 0
+    for pre < len(want) && pre < len(got) && want[pre] == got[pre] { pre++ }
+    suf := 0
+    for suf < len(want)-pre && suf < len(got)-pre && want[len(want)-1-suf] == got[len(got)-1-suf] { suf++ }
+    a, b := want[pre:len(want)-suf], got[pre:len(got)-suf]
+    var out []string
+    del := func(t Transition) { out = append(out, "- "+t.String()) }
+    add := func(t Transition) { out = append(out, "+ "+t.String()) }
+    if len(a)*len(b) > maxDiffCells {
+        for _, t := range a { del(t) }
+        for _, t := range b { add(t) }
+        return out
+    }
+    // lcs[i][j] is the longest common subsequence of a[i:] and b[j:].
+    lcs := make([][]int, len(a)+1)
+    for i := range lcs { lcs[i] = make([]int, len(b)+1) }
+    for i := len(a) - 1; i >= 0; i-- {
+        for j := len(b) - 1; j >= 0; j-- {
+            if a[i] == b[j] {
+                lcs[i][j] = lcs[i+1][j+1] + 1
+            } else if lcs[i+1][j] >= lcs[i][j+1] {
+                lcs[i][j] = lcs[i+1][j]
+            } else {
+                lcs[i][j] = lcs[i][j+1]
+            }
+        }
+    }
+    i, j := 0, 0
+    for i < len(a) || j < len(b) {
+        switch {
+        case i < len(a) && j < len(b) && a[i] == b[j]:
+            i++; j++
+        case j < len(b) && (i == len(a) || lcs[i][j+1] >= lcs[i+1][j]):
+            add(b[j]); j++
+        default:
+            del(a[i]); i++
+        }
+    }
+    return out
+}
@@ -0,0 +1,159 @@
+package consensus
+
+import (
+    "bytes"
+    "context"
+    "crypto/ed25519"
+    "crypto/rand"
+    "errors"
+    "os"
+    "path/filepath"
+    "reflect"
+    "sync"
+    "testing"
+    "time"
+
+    qbft "github.com/zmlAEQ/Aequa-network/internal/consensus/qbft"
+    "github.com/zmlAEQ/Aequa-network/pkg/bus"
+    "github.com/zmlAEQ/Aequa-network/pkg/logger"
+)
+
+func TestRecording_RoundTripAndTornTail(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "node.qrec")
+    r, err := CreateRecording(path)
+    if err != nil { t.Fatal(err) }
+    at := time.Unix(1_700_000_000, 42)
+    msg := qbft.Message{From: "a", Type: qbft.MsgPrepare, Duty: "attester", Height: 3, Round: 1, ID: "x", ProposalID: "p", TraceID: "t"}
+    key := qbft.InstanceKey{Duty: "attester", Height: 3}
+    if err := r.Duty(at, key, []byte(`{"slot":3}`)); err != nil { t.Fatal(err) }
+    if err := r.Message(at.Add(time.Millisecond), msg, nil, false); err != nil { t.Fatal(err) }
+    if err := r.Message(at.Add(2*time.Millisecond), msg, errors.New("boom"), true); err != nil { t.Fatal(err) }
+    if err := r.Close(); err != nil { t.Fatal(err) }
+    if err := r.Close(); err != nil { t.Fatalf("second close: %v", err) }
+
+    recs, err := ReadRecordingFile(path)
+    if err != nil { t.Fatal(err) }
+    if err := r.Tick(at.Add(3*time.Millisecond)); err == nil { t.Fatal("tick after close accepted") }
+    if len(recs) != 3 || recs[0].Kind != RecordDuty { t.Fatalf("records %+v", recs) }
+    if recs[0].Key != key || string(recs[0].Value) != `{"slot":3}` || !recs[0].At.Equal(at) { t.Fatalf("duty %+v", recs[0]) }
+    if !reflect.DeepEqual(recs[1].Msg, msg) || recs[1].Result != "ok" || recs[1].Recovered { t.Fatalf("message %+v", recs[1]) }
+    if recs[2].Result != "boom" || !recs[2].Recovered { t.Fatalf("rejected %+v", recs[2]) }
+
+    b, _ := os.ReadFile(path)
+    if err := os.WriteFile(path, b[:len(b)-3], 0o600); err != nil { t.Fatal(err) }
+    recs, err = ReadRecordingFile(path)
+    if !errors.Is(err, ErrRecordingTruncated) || len(recs) != 2 { t.Fatalf("torn tail: %d records, err %v", len(recs), err) }
+}
+
+// syncBuffer is a bytes.Buffer safe for the service goroutine's log writes.
+type syncBuffer struct {
+    mu sync.Mutex
+    b  bytes.Buffer
+}
+
+func (s *syncBuffer) Write(p []byte) (int, error) { s.mu.Lock(); defer s.mu.Unlock(); return s.b.Write(p) }
+
+func (s *syncBuffer) Bytes() []byte { s.mu.Lock(); defer s.mu.Unlock(); return append([]byte(nil), s.b.Bytes()...) }
+
+// A recorded run replays to the same verdicts and the same transitions.
+func TestReplay_ReproducesRecordedRun(t *testing.T) {
+    var orig syncBuffer
+    logger.SetOutput(&orig)
+    defer logger.SetOutput(os.Stdout)
+
+    path := filepath.Join(t.TempDir(), "node.qrec")
+    rec, err := CreateRecording(path)
+    if err != nil { t.Fatal(err) }
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    leader := qbft.LeaderFromLock(dutyLock)(7, 0)
+    _, key, _ := ed25519.GenerateKey(rand.Reader)
+    b := bus.New(16)
+    s := NewWithSub(b.Subscribe())
+    s.SetClusterLock(dutyLock)
+    s.SetSigner(qbft.NewKeySigner(leader, key))
+    s.SetRecorder(rec)
+    out := &capture{}
+    s.SetTransport(out.send)
+    decided := s.SubscribeDecided(1)
+    if err := s.Start(ctx); err != nil { t.Fatal(err) }
+
+    b.Publish(ctx, bus.Event{Kind: bus.KindDuty, Height: 7, Body: bus.Duty{Type: "attester", Height: 7, Payload: []byte(`{"slot":7}`)}, TraceID: "d7"})
+    waitFor(t, "preprepare", func() bool { return len(out.ofType(qbft.MsgPreprepare)) == 1 })
+    pp := out.ofType(qbft.MsgPreprepare)[0]
+    for _, typ := range []qbft.Type{qbft.MsgPrepare, qbft.MsgCommit} {
+        for _, from := range []string{"a", "b", "c", "d"} {
+            if from == leader { continue }
+            m := qbft.Message{ProposalID: pp.ProposalID, From: from, Type: typ, Duty: "attester", Height: 7, TraceID: "t-" + from}
+            m.ID = qbft.ContentID(m)
+            b.Publish(ctx, bus.Event{Kind: bus.KindConsensus, Body: m})
+            if typ == qbft.MsgPrepare && from != leader {
+                b.Publish(ctx, bus.Event{Kind: bus.KindConsensus, Body: m}) // replayed copy
+                m.ID = "forged"
+                b.Publish(ctx, bus.Event{Kind: bus.KindConsensus, Body: m}) // id not matching content
+            }
+        }
+    }
+    select {
+    case <-decided:
+    case <-time.After(time.Second):
+        t.Fatal("no decision")
+    }
+    time.Sleep(50 * time.Millisecond) // let own commits drain
+    cancel()
+    time.Sleep(20 * time.Millisecond)
+    if err := rec.Close(); err != nil { t.Fatal(err) }
+
+    recs, err := ReadRecordingFile(path)
+    if err != nil { t.Fatal(err) }
+    results := map[string]int{}
+    for _, r := range recs { if r.Kind == RecordMessage { results[r.Result]++ } }
+    if results["ok"] == 0 || len(results) < 3 { t.Fatalf("expected accepted and two kinds of rejected messages, got %v", results) }
+
+    var replayed bytes.Buffer
+    logger.SetOutput(&replayed)
+    mismatches, err := Replay(recs, ReplayOptions{Lock: &dutyLock, Self: leader})
+    logger.SetOutput(os.Stdout)
+    if err != nil { t.Fatalf("replay: %v", err) }
+    if len(mismatches) != 0 { t.Fatalf("verdicts differ: %v", mismatches) }
+    want, _ := ParseTransitions(bytes.NewReader(orig.Bytes()))
+    got, _ := ParseTransitions(&replayed)
+    if len(want) == 0 { t.Fatal("no transitions logged") }
+    if d := DiffTransitions(want, got); len(d) != 0 { t.Fatalf("transitions differ:\n%v", d) }
+
+    // A diverging replay is reported.
+    got = append(got[:1], got[2:]...)
+    if d := DiffTransitions(want, got); len(d) != 1 || d[0][0] != '-' { t.Fatalf("diff %v", d) }
+}
+
+// Rate limits refill on the recorded clock: a peer within its limit at the
+// recorded pace is not rate_limited when replayed at full speed.
+func TestReplay_RateLimitsUseRecordedClock(t *testing.T) {
+    limits := qbft.RateLimits{PerSender: qbft.Rate{PerSecond: 1, Burst: 1}}
+    at := time.Unix(1_700_000_000, 0)
+    var recs []Record
+    for i := 0; i < 5; i++ {
+        msg := qbft.Message{ID: "m" + string(rune('0'+i)), From: "b", Type: qbft.MsgPrepare, Duty: "attester", Height: 1}
+        recs = append(recs, Record{Kind: RecordMessage, At: at.Add(time.Duration(i) * time.Second), Msg: msg, Result: "ok"})
+    }
+    // A sixth message in the same second as the fifth was limited.
+    recs = append(recs, Record{Kind: RecordMessage, At: at.Add(4*time.Second + time.Millisecond), Msg: qbft.Message{ID: "m5", From: "b", Type: qbft.MsgPrepare, Duty: "attester", Height: 1}, Result: "sender_rate"})
+    mismatches, err := Replay(recs, ReplayOptions{RateLimits: &limits})
+    if err != nil { t.Fatal(err) }
+    if len(mismatches) != 0 { t.Fatalf("verdicts differ: %v", mismatches) }
+}
+
+type failWriter struct{}
+
+func (failWriter) Write([]byte) (int, error) { return 0, errors.New("disk full") }
+
+// A failed header write is reported, not followed by a body without its header.
+func TestRecorder_WriteReportsHeaderError(t *testing.T) {
+    r, err := CreateRecording(filepath.Join(t.TempDir(), "node.qrec"))
+    if err != nil { t.Fatal(err) }
+    defer r.Close()
+    r.w.Reset(failWriter{})
+    big := make([]byte, 8192)
+    if err := r.Duty(time.Now(), qbft.InstanceKey{Duty: "attester", Height: 1}, big); err == nil { t.Fatal("want write error") }
+    if err := r.Tick(time.Now()); err == nil { t.Fatal("want sticky write error") }
+}
@@ -2,17 +2,35 @@ package consensus
 
 import (
     "context"
+    "encoding/json"
+    "fmt"
+    "sync"
     "time"
 
     "github.com/zmlAEQ/Aequa-network/pkg/bus"
+    "github.com/zmlAEQ/Aequa-network/pkg/config"
     "github.com/zmlAEQ/Aequa-network/pkg/lifecycle"
     "github.com/zmlAEQ/Aequa-network/pkg/logger"
     "github.com/zmlAEQ/Aequa-network/pkg/metrics"
+    "github.com/zmlAEQ/Aequa-network/internal/consensus/payload"
     qbft "github.com/zmlAEQ/Aequa-network/internal/consensus/qbft"
     "github.com/zmlAEQ/Aequa-network/internal/state"
 )
 
-type Service struct{ sub bus.Subscriber; v qbft.Verifier; store state.Store; st qbft.Processor }
+// tickInterval bounds the resolution of QBFT round timeouts.
+const tickInterval = 100 * time.Millisecond
+
+// replayFlushInterval bounds how often the anti-replay window is persisted
+// with a WAL, whose replay restores the ids seen since. Without a WAL each
+// verified id is appended to the store's replay log before it is acted on,
+// and the window is saved (compacting the log) once a decision moves its
+// watermark or replayCompactAt ids were appended.
+const replayFlushInterval = time.Second
+
+// replayCompactAt bounds the replay log between watermark moves.
+const replayCompactAt = qbft.DefaultReplayMaxEntries
+
+type Service struct{ sub bus.Subscriber; v qbft.Verifier; store state.Store; saved *state.LastState; st qbft.Processor; lock *config.ClusterLock; validators qbft.Validators; self string; rateLimits *qbft.RateLimits; now func() time.Time; decided []chan qbft.Decided; signer qbft.Signer; evidence state.EvidenceStore; replayRetain uint64; replayDirty bool; replayAppends int; payloads payload.Manager; values qbft.ValueValidator; wal *state.WAL; transport func(qbft.Message); ownMu sync.Mutex; own []qbft.Message; ownReady chan struct{}; hold bool; outbox []qbft.Message; inbox chan qbft.Message; recorder *Recorder; timer *qbft.RoundTimer }
 
 func New() *Service { return &Service{} }
 func NewWithSub(sub bus.Subscriber) *Service { return &Service{sub: sub} }
@@ -24,50 +42,191 @@ func (s *Service) SetVerifier(v qbft.Verifier) { s.v = v }
 // SetStore allows tests/wiring to inject a StateDB store. If nil, a MemoryStore is instantiated on start.
 func (s *Service) SetStore(st state.Store) { s.store = st }
 
-// SetProcessor allows tests/wiring to inject a qbft state processor. If nil, a default state is created on start.
+// SetProcessor allows tests/wiring to inject a qbft state processor. If nil, an
+// instance manager running one qbft.State per (duty, height) is created on start.
 func (s *Service) SetProcessor(p qbft.Processor) { s.st = p }
 
+// SetClusterLock injects the cluster operator set used to size QBFT quorums and elect leaders.
+func (s *Service) SetClusterLock(l config.ClusterLock) { s.lock = &l }
+
+// SetSigner injects the signer for this node's own consensus messages.
+func (s *Service) SetSigner(sg qbft.Signer) { s.signer, s.self = sg, sg.ID() }
+
+// SetRateLimits sets the default verifier's per-sender message limits. If
+// unset, qbft.DefaultRateLimits is used; zero limits disable rate limiting.
+// This node's own id is always exempt.
+func (s *Service) SetRateLimits(l qbft.RateLimits) { s.rateLimits = &l }
+
+// SetPayloadManager injects the payload.Manager validating and canonicalising
+// proposed values, both in the default verifier and in every QBFT instance.
+func (s *Service) SetPayloadManager(m payload.Manager) { s.payloads = m }
+
+// ValueValidator checks proposed values before this node votes for them.
+type ValueValidator = qbft.ValueValidator
+
+// SetValueValidator injects the check every QBFT instance applies to a
+// proposal before voting; rejected values lead to a round change.
+func (s *Service) SetValueValidator(v ValueValidator) { s.values = v }
+
+// SetReplayRetention sets how many heights below the last decided height the
+// default verifier's anti-replay window keeps (and persists). 0 keeps the default.
+func (s *Service) SetReplayRetention(heights uint64) { s.replayRetain = heights }
+
+// SetTransport injects how this node's own consensus messages reach its
+// peers. Own messages are always processed locally as well.
+func (s *Service) SetTransport(send func(qbft.Message)) { s.transport = send }
+
+// inboxSize bounds peer messages waiting for the service loop.
+const inboxSize = 1024
+
+// peerInbox returns the queue peer messages are delivered on. It is separate
+// from the bus, so a peer flood fills (and drops from) this queue rather
+// than crowding duties off the bus.
+func (s *Service) peerInbox() chan qbft.Message {
+    if s.inbox == nil { s.inbox = make(chan qbft.Message, inboxSize) }
+    return s.inbox
+}
+
+// SetWAL injects the write-ahead log of verified messages. Every message that
+// passes verification is appended before it reaches the state machine, and on
+// Start the log is replayed to rebuild in-flight instances and the ids of the
+// anti-replay window seen since its last save (durable per message with
+// state.WALSyncAlways). Segments more than the replay retention below a
+// decided height are pruned.
+func (s *Service) SetWAL(w *state.WAL) { s.wal = w }
+
+// SetRecorder records every message handed to the verifier (with its arrival
+// time and verdict) and every duty, for offline replay with Replay.
+func (s *Service) SetRecorder(r *Recorder) { s.recorder = r }
+
+// SetRoundTimer sets the round timeouts of new QBFT instances. If unset,
+// qbft.DefaultRoundTimer is used; a zero RoundTimer disables timeouts.
+func (s *Service) SetRoundTimer(t qbft.RoundTimer) { s.timer = &t }
+
+// SetEvidenceStore injects where equivocation evidence is persisted. If nil, a
+// MemoryEvidenceStore is instantiated on start.
+func (s *Service) SetEvidenceStore(es state.EvidenceStore) { s.evidence = es }
+
+// Evidence lists the equivocation evidence recorded so far.
+func (s *Service) Evidence(ctx context.Context) ([]state.Evidence, error) {
+    if s.evidence == nil { return nil, nil }
+    return s.evidence.ListEvidence(ctx)
+}
+
+// recordEvidence persists equivocation evidence raised by an instance.
+func (s *Service) recordEvidence(ev qbft.Evidence) {
+    body, err := json.Marshal(ev)
+    if err == nil {
+        err = s.evidence.SaveEvidence(context.Background(), state.Evidence{ID: ev.ID(), Kind: "equivocation", Offender: ev.Offender, Duty: ev.Duty, Height: ev.Height, Round: ev.Round, Body: body})
+    }
+    if err != nil {
+        logger.ErrorJ("consensus_state", map[string]any{"op":"evidence", "result":"error", "err": err.Error(), "offender": ev.Offender, "trace_id": ev.Second.TraceID})
+    }
+}
+
+// SubscribeDecided returns a channel receiving every decision of the default
+// instance manager. Must be called before Start. Decisions are dropped (and
+// counted) when the subscriber falls behind, mirroring bus backpressure.
+func (s *Service) SubscribeDecided(size int) <-chan qbft.Decided {
+    if size <= 0 { size = 16 }
+    ch := make(chan qbft.Decided, size)
+    s.decided = append(s.decided, ch)
+    return ch
+}
+
+func (s *Service) publishDecided(d qbft.Decided) {
+    // A decision moves the anti-replay watermark even without further traffic.
+    if bv, ok := s.v.(*qbft.BasicVerifier); ok {
+        bv.Replay().Advance(d.Height)
+        s.replayDirty = true
+    }
+    if s.wal != nil {
+        retain := s.replayRetain
+        if retain == 0 { retain = qbft.DefaultReplayRetain }
+        if d.Height > retain { _ = s.wal.PruneBelow(d.Height - retain) }
+    }
+    for _, ch := range s.decided {
+        select {
+        case ch <- d:
+        default:
+            metrics.Inc("consensus_decided_dropped_total", nil)
+        }
+    }
+}
+
 func (s *Service) Start(ctx context.Context) error {
     if s.sub == nil {
         logger.Info("consensus start (stub)")
         return nil
     }
-    if s.v == nil { s.v = qbft.NewBasicVerifierWithPolicy(qbft.DefaultPolicy()) }
+    if err := s.loadValidators(); err != nil { return err }
+    if s.lock != nil && s.signer == nil {
+        logger.ErrorJ("consensus_state", map[string]any{"op":"start", "result":"no_signer", "err": "no signer: this node never proposes or votes", "trace_id": ""})
+    }
+    if s.v == nil { s.v = s.defaultVerifier() }
     if s.store == nil { s.store = state.NewMemoryStore() }
-    if s.st == nil { s.st = &qbft.State{} }
+    if s.evidence == nil { s.evidence = state.NewMemoryEvidenceStore() }
+    if s.st == nil { s.st = qbft.NewInstanceManager(0, 0, s.newState) }
+    if m, ok := s.st.(*qbft.InstanceManager); ok { m.SetOnRemove(s.forgetInstance) }
+    s.ownReady = make(chan struct{}, 1)
     // Start E2E attack/testing endpoint when built with tag "e2e" (no-op otherwise).
     startE2E(s)
+    restored := s.restoreInstances(ctx)
     if ls, err := s.store.LoadLastState(ctx); err != nil {
         logger.InfoJ("consensus_state", map[string]any{"op":"load", "result":"miss", "err": err.Error(), "trace_id": ""})
     } else {
         logger.InfoJ("consensus_state", map[string]any{"op":"load", "result":"ok", "height": ls.Height, "round": ls.Round, "trace_id": ""})
+        // A single-instance record from before per-instance snapshots.
+        if !restored { s.restoreInstance(ls) }
     }
+    // Replay the WAL before loading the persisted window: the replayed
+    // messages would otherwise be rejected as replays of themselves.
+    s.replayWAL()
+    s.loadReplay(ctx)
     go func() {
-        ticker := time.NewTicker(5 * time.Second)
+        ticker := time.NewTicker(tickInterval)
         defer ticker.Stop()
+        lastFlush := time.Now()
         for {
             select {
+            case now := <-ticker.C:
+                s.recordTick(now)
+                // Drive round timers of processors that support them.
+                if t, ok := s.st.(qbft.Ticker); ok { _ = t.Tick(now) }
+                if s.wal != nil { _ = s.wal.Flush() }
+                if s.recorder != nil { _ = s.recorder.Flush() }
+                if now.Sub(lastFlush) >= replayFlushInterval {
+                    s.saveReplay(ctx)
+                    lastFlush = now
+                }
             case ev := <-s.sub:
                 // Count the event as received
                 metrics.Inc("consensus_events_total", map[string]string{"kind": string(ev.Kind)})
 
                 // Measure full processing time: verify -> state -> persist
                 begin := time.Now()
-                // Map event to qbft message via adapter
-                msg := MapEventToQBFT(ev)
-                if err := s.v.Verify(msg); err == nil {
-                    _ = s.st.Process(msg)
-                    if err2 := s.store.SaveLastState(ctx, state.LastState{Height: msg.Height, Round: msg.Round}); err2 != nil {
-                        logger.ErrorJ("consensus_state", map[string]any{"op":"save", "result":"error", "err": err2.Error(), "trace_id": ev.TraceID})
-                    } else {
-                        logger.InfoJ("consensus_state", map[string]any{"op":"save", "result":"ok", "height": msg.Height, "round": msg.Round, "trace_id": ev.TraceID})
-                    }
+                if d, ok := DutyOf(ev); ok {
+                    s.handleDuty(ctx, d, ev.TraceID)
+                } else if msg, ok := MessageOf(ev); ok {
+                    s.handleMessage(ctx, msg)
+                } else {
+                    metrics.Inc("consensus_events_dropped_total", map[string]string{"reason": "unknown_body"})
                 }
                 durMs := time.Since(begin).Milliseconds()
                 // Audit log and summary with the full processing latency; labels unchanged
                 logger.InfoJ("consensus_recv", map[string]any{"kind": string(ev.Kind), "trace_id": ev.TraceID, "result": "recv", "latency_ms": durMs})
                 metrics.ObserveSummary("consensus_proc_ms", map[string]string{"kind": string(ev.Kind)}, float64(durMs))
+            case msg := <-s.inbox:
+                metrics.Inc("consensus_events_total", map[string]string{"kind": string(bus.KindConsensus)})
+                begin := time.Now()
+                s.handleMessage(ctx, msg)
+                metrics.ObserveSummary("consensus_proc_ms", map[string]string{"kind": string(bus.KindConsensus)}, float64(time.Since(begin).Milliseconds()))
+            case <-s.ownReady:
+                s.drainOwn(ctx)
             case <-ctx.Done():
+                s.saveReplay(context.Background())
+                if s.wal != nil { _ = s.wal.Sync() }
+                if s.recorder != nil { _ = s.recorder.Flush() }
                 return
             }
         }
@@ -75,6 +234,334 @@ func (s *Service) Start(ctx context.Context) error {
     return nil
 }
 
+// handleDuty starts the QBFT instance for a duty with its payload as this
+// node's input; the round leader proposes it, the others wait for the leader.
+func (s *Service) handleDuty(ctx context.Context, d bus.Duty, traceID string) {
+    p, ok := s.st.(qbft.Proposer)
+    if !ok {
+        metrics.Inc("consensus_duties_total", map[string]string{"type": d.Type, "result": "unsupported"})
+        logger.ErrorJ("consensus_duty", map[string]any{"type": d.Type, "height": d.Height, "result": "unsupported", "trace_id": traceID})
+        return
+    }
+    key := qbft.InstanceKey{Duty: d.Type, Height: d.Height}
+    if s.recorder != nil { _ = s.recorder.Duty(time.Now(), key, d.Payload) }
+    s.holdSends()
+    if err := p.Propose(key, d.Payload); err != nil {
+        metrics.Inc("consensus_duties_total", map[string]string{"type": d.Type, "result": "error"})
+        logger.ErrorJ("consensus_duty", map[string]any{"type": d.Type, "height": d.Height, "result": "error", "err": err.Error(), "trace_id": traceID})
+        s.releaseSends(err, traceID)
+        return
+    }
+    metrics.Inc("consensus_duties_total", map[string]string{"type": d.Type, "result": "started"})
+    logger.InfoJ("consensus_duty", map[string]any{"type": d.Type, "height": d.Height, "result": "started", "trace_id": traceID})
+    s.releaseSends(s.saveState(ctx, qbft.Message{Duty: d.Type, Height: d.Height}, traceID), traceID)
+}
+
+// handleMessage verifies an inbound (or own) consensus message, logs it to
+// the WAL and applies it to its instance. Votes the instance casts in reply
+// reach peers only once the message and the new state are persisted.
+func (s *Service) handleMessage(ctx context.Context, msg qbft.Message) {
+    err := s.v.Verify(msg)
+    s.record(msg, err, false)
+    if err != nil { return }
+    s.holdSends()
+    if s.wal != nil {
+        s.replayDirty = true
+        err = s.appendWAL(msg)
+    } else {
+        // Without a WAL a crash would forget the id: persist it first.
+        s.appendReplay(ctx, msg)
+    }
+    _ = s.st.Process(msg)
+    if serr := s.saveState(ctx, msg, msg.TraceID); err == nil { err = serr }
+    s.releaseSends(err, msg.TraceID)
+}
+
+// saveState persists the coordinates of msg and, with an InstanceStore, the
+// snapshot of the instance msg belongs to (keyed by duty and height, so other
+// in-flight instances keep theirs). The snapshot then carries everything a
+// restore needs, so the coordinates are only rewritten when they change.
+func (s *Service) saveState(ctx context.Context, msg qbft.Message, traceID string) error {
+    ls := lastStateOf(s.st, msg)
+    var ierr error
+    if is, ok := s.store.(state.InstanceStore); ok {
+        ierr = s.saveInstance(ctx, is, ls, traceID)
+        ls.Instance = nil
+        if s.saved != nil && *s.saved == ls { return ierr }
+    }
+    if err := s.store.SaveLastState(ctx, ls); err != nil {
+        logger.ErrorJ("consensus_state", map[string]any{"op":"save", "result":"error", "err": err.Error(), "trace_id": traceID})
+        return err
+    }
+    if ls.Instance == nil { s.saved = &ls }
+    logger.InfoJ("consensus_state", map[string]any{"op":"save", "result":"ok", "height": ls.Height, "round": ls.Round, "trace_id": traceID})
+    return ierr
+}
+
+// saveInstance stores the snapshot in ls, or drops it once the instance has
+// decided. States without a live instance leave the stored snapshots alone.
+func (s *Service) saveInstance(ctx context.Context, is state.InstanceStore, ls state.LastState, traceID string) error {
+    in := ls.Instance
+    if in == nil { return nil }
+    var err error
+    if in.Phase == "commit" {
+        err = is.DeleteInstance(ctx, in.Duty, ls.Height)
+    } else {
+        err = is.SaveInstance(ctx, ls)
+    }
+    if err != nil {
+        logger.ErrorJ("consensus_state", map[string]any{"op":"instance_save", "result":"error", "err": err.Error(), "duty": in.Duty, "height": ls.Height, "trace_id": traceID})
+    }
+    return err
+}
+
+// forgetInstance drops the stored snapshot of an instance the manager removed
+// (decided or evicted).
+func (s *Service) forgetInstance(k qbft.InstanceKey, reason string) {
+    is, ok := s.store.(state.InstanceStore)
+    if !ok { return }
+    if err := is.DeleteInstance(context.Background(), k.Duty, k.Height); err != nil {
+        logger.ErrorJ("consensus_state", map[string]any{"op":"instance_delete", "result":"error", "err": err.Error(), "reason": reason, "duty": k.Duty, "height": k.Height, "trace_id": ""})
+    }
+}
+
+// broadcast delivers an own message to this node and to the transport. The
+// local copy is queued, as instances must not be re-entered synchronously,
+// but never dropped: a node that misses its own vote can miss quorum. While
+// sends are held the transport copy waits in the outbox instead.
+func (s *Service) broadcast(msg qbft.Message) {
+    s.ownMu.Lock()
+    s.own = append(s.own, msg)
+    held := s.hold
+    if held { s.outbox = append(s.outbox, msg) }
+    s.ownMu.Unlock()
+    select {
+    case s.ownReady <- struct{}{}:
+    default:
+    }
+    if !held && s.transport != nil { s.transport(msg) }
+}
+
+// holdSends starts collecting transport sends in the outbox, so a vote never
+// leaves the node before the state that cast it is persisted: after a crash
+// the node could otherwise cast a conflicting one.
+func (s *Service) holdSends() {
+    s.ownMu.Lock()
+    s.hold = true
+    s.ownMu.Unlock()
+}
+
+// releaseSends ends holding and sends the outbox, or withholds it when
+// persisting failed (err != nil). Withheld votes stay local; the round timer
+// moves the instance on.
+func (s *Service) releaseSends(err error, traceID string) {
+    s.ownMu.Lock()
+    out := s.outbox
+    s.hold, s.outbox = false, nil
+    s.ownMu.Unlock()
+    if len(out) == 0 { return }
+    if err != nil {
+        for range out { metrics.Inc("consensus_sends_withheld_total", nil) }
+        logger.ErrorJ("consensus_state", map[string]any{"op":"send", "result":"withheld", "err": err.Error(), "count": len(out), "trace_id": traceID})
+        return
+    }
+    if s.transport == nil { return }
+    for _, msg := range out { s.transport(msg) }
+}
+
+// drainOwn applies queued own messages, including those they give rise to.
+func (s *Service) drainOwn(ctx context.Context) {
+    for {
+        s.ownMu.Lock()
+        msgs := s.own
+        s.own = nil
+        s.ownMu.Unlock()
+        if len(msgs) == 0 { return }
+        for _, msg := range msgs { s.handleMessage(ctx, msg) }
+    }
+}
+
+// restoreInstances resumes every instance snapshot of an InstanceStore and
+// reports whether there were any.
+func (s *Service) restoreInstances(ctx context.Context) bool {
+    is, ok := s.store.(state.InstanceStore)
+    if !ok { return false }
+    lss, err := is.LoadInstances(ctx)
+    if err != nil || len(lss) == 0 { return false }
+    for _, ls := range lss { s.restoreInstance(ls) }
+    return true
+}
+
+// restoreInstance resumes the in-flight instance of a state so the node
+// never contradicts votes it sent before the restart.
+func (s *Service) restoreInstance(ls state.LastState) {
+    sp, ok := s.st.(qbft.Snapshotter)
+    if !ok || ls.Instance == nil { return }
+    sn, err := snapshotOf(ls)
+    if err == nil { err = sp.Restore(sn) }
+    if err != nil {
+        logger.ErrorJ("consensus_state", map[string]any{"op":"restore", "result":"error", "err": err.Error(), "height": ls.Height, "trace_id": ""})
+        return
+    }
+    logger.InfoJ("consensus_state", map[string]any{"op":"restore", "result":"ok", "duty": sn.Duty, "height": sn.Height, "round": sn.Round, "phase": sn.Phase, "proposal": sn.ProposalID, "own": len(sn.Own), "votes": len(sn.Votes), "trace_id": ""})
+}
+
+// record appends a message and its verdict to the recording, if enabled.
+func (s *Service) record(msg qbft.Message, verr error, recovered bool) {
+    if s.recorder == nil { return }
+    if err := s.recorder.Message(time.Now(), msg, verr, recovered); err != nil {
+        logger.ErrorJ("consensus_state", map[string]any{"op":"record", "result":"error", "err": err.Error(), "trace_id": msg.TraceID})
+    }
+}
+
+// recordTick records a timer tick while the processor has live instances.
+func (s *Service) recordTick(now time.Time) {
+    if s.recorder == nil { return }
+    if l, ok := s.st.(interface{ Len() int }); ok && l.Len() == 0 { return }
+    _ = s.recorder.Tick(now)
+}
+
+// appendWAL logs a verified message ahead of applying it.
+func (s *Service) appendWAL(msg qbft.Message) error {
+    if s.wal == nil { return nil }
+    b, err := qbft.Marshal(msg)
+    if err == nil { err = s.wal.Append(msg.Height, b) }
+    if err != nil {
+        logger.ErrorJ("consensus_state", map[string]any{"op":"wal_append", "result":"error", "err": err.Error(), "height": msg.Height, "trace_id": msg.TraceID})
+    }
+    return err
+}
+
+// recoveryVerifier is implemented by verifiers with a dedicated path for
+// messages read back from the local WAL (e.g. skipping rate limits).
+type recoveryVerifier interface{ VerifyRecovered(msg qbft.Message) error }
+
+// replayWAL feeds every logged message through the verifier and the state
+// machine again, rebuilding the instances that were in flight at the crash.
+// Own messages are re-derived, and the instance's own-vote guard keeps them
+// consistent with anything restored from the last-state snapshot.
+func (s *Service) replayWAL() {
+    if s.wal == nil { return }
+    verify := s.v.Verify
+    if rv, ok := s.v.(recoveryVerifier); ok { verify = rv.VerifyRecovered }
+    counts := map[string]int{}
+    err := s.wal.Replay(func(_ uint64, b []byte) error {
+        result := "applied"
+        if msg, err := qbft.Unmarshal(b); err != nil {
+            result = "undecodable"
+        } else if err := verify(msg); err != nil {
+            s.record(msg, err, true)
+            result = "rejected"
+        } else {
+            s.record(msg, nil, true)
+            _ = s.st.Process(msg)
+        }
+        counts[result]++
+        metrics.Inc("consensus_wal_replayed_total", map[string]string{"result": result})
+        return nil
+    })
+    result := "ok"
+    if err != nil { result = "error" }
+    logger.InfoJ("consensus_state", map[string]any{"op":"wal_recover", "result": result, "applied": counts["applied"], "rejected": counts["rejected"], "undecodable": counts["undecodable"], "trace_id": ""})
+}
+
+// replayCache returns the default verifier's anti-replay cache and the store
+// persisting it, if both are available.
+func (s *Service) replayCache() (*qbft.AntiReplay, state.ReplayStore, bool) {
+    bv, ok := s.v.(*qbft.BasicVerifier)
+    if !ok || bv.Replay() == nil { return nil, nil, false }
+    rs, ok := s.store.(state.ReplayStore)
+    if !ok { return nil, nil, false }
+    return bv.Replay(), rs, true
+}
+
+// loadReplay restores the persisted anti-replay window so messages accepted
+// before a restart are still rejected as replays.
+func (s *Service) loadReplay(ctx context.Context) {
+    cache, rs, ok := s.replayCache()
+    if !ok { return }
+    r, err := rs.LoadReplay(ctx)
+    if err != nil {
+        logger.InfoJ("consensus_state", map[string]any{"op":"replay_load", "result":"miss", "err": err.Error(), "trace_id": ""})
+        return
+    }
+    cache.Restore(r.IDs, r.High)
+    logger.InfoJ("consensus_state", map[string]any{"op":"replay_load", "result":"ok", "entries": cache.Len(), "high": cache.Watermark(), "trace_id": ""})
+}
+
+// appendReplay logs the id of a verified message. Stores without a replay log,
+// or a failed append, fall back to saving the whole window.
+func (s *Service) appendReplay(ctx context.Context, msg qbft.Message) {
+    _, rs, ok := s.replayCache()
+    if !ok { return }
+    if ra, ok := rs.(state.ReplayAppender); ok && ra.AppendReplay(ctx, msg.ID, msg.Height) == nil {
+        s.replayAppends++
+        if s.replayAppends >= replayCompactAt { s.replayDirty = true }
+        return
+    }
+    s.replayDirty = true
+    s.saveReplay(ctx)
+}
+
+// saveReplay persists the anti-replay window if it changed since the last save.
+func (s *Service) saveReplay(ctx context.Context) {
+    if !s.replayDirty { return }
+    cache, rs, ok := s.replayCache()
+    if !ok { return }
+    if err := rs.SaveReplay(ctx, state.ReplayState{High: cache.Watermark(), IDs: cache.Snapshot()}); err != nil {
+        logger.ErrorJ("consensus_state", map[string]any{"op":"replay_save", "result":"error", "err": err.Error(), "trace_id": ""})
+        return
+    }
+    s.replayDirty, s.replayAppends = false, 0
+}
+
+// loadValidators builds the operator set of the cluster lock. A lock with a
+// malformed operator key is refused: running without signature checks for
+// the whole cluster is not a fallback.
+func (s *Service) loadValidators() error {
+    if s.lock == nil { return nil }
+    vs, err := qbft.ValidatorsFromLock(*s.lock)
+    if err != nil { return fmt.Errorf("cluster lock: %w", err) }
+    s.validators = vs
+    return nil
+}
+
+// defaultVerifier builds the BasicVerifier configured from the cluster lock
+// (loaded by loadValidators).
+func (s *Service) defaultVerifier() *qbft.BasicVerifier {
+    p := qbft.DefaultPolicy()
+    p.ReplayRetain = s.replayRetain
+    p.Payloads = s.payloads
+    p.RateLimits, p.Now = s.limits(), s.now
+    if s.lock != nil {
+        p.Leader = qbft.LeaderFromLock(*s.lock)
+        p.ContentIDs = true
+        p.Validators = s.validators
+        p.Keys = s.validators.Keys()
+    }
+    return qbft.NewBasicVerifierWithPolicy(p)
+}
+
+// limits returns the configured rate limits with this node exempt.
+func (s *Service) limits() qbft.RateLimits {
+    l := qbft.DefaultRateLimits()
+    if s.rateLimits != nil { l = *s.rateLimits }
+    if s.self != "" { l.Exempt = append(append([]string(nil), l.Exempt...), s.self) }
+    return l
+}
+
+// newState builds the qbft.State for a new (duty, height) instance.
+func (s *Service) newState(k qbft.InstanceKey) *qbft.State {
+    st := &qbft.State{Duty: k.Duty, OnDecided: s.publishDecided, OnEvidence: s.recordEvidence, Payloads: s.payloads, ValueValidator: s.values, Broadcast: s.broadcast, Timer: qbft.DefaultRoundTimer()}
+    if s.timer != nil { st.Timer = *s.timer }
+    if s.lock != nil {
+        st.Validators = s.validators
+        st.LeaderFn = qbft.LeaderFromLock(*s.lock)
+    }
+    if s.signer != nil { st.Self, st.Signer = s.signer.ID(), s.signer }
+    return st
+}
+
 func (s *Service) Stop(ctx context.Context) error  { logger.Info("consensus stop (stub)"); return nil }
 
 var _ lifecycle.Service = (*Service)(nil)
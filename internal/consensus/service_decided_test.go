@@ -0,0 +1,37 @@
+package consensus
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    qbft "github.com/zmlAEQ/Aequa-network/internal/consensus/qbft"
+    "github.com/zmlAEQ/Aequa-network/pkg/bus"
+    "github.com/zmlAEQ/Aequa-network/pkg/config"
+)
+
+// Decisions reached by the default instance manager reach SubscribeDecided.
+func TestService_SubscribeDecided_ReceivesDecision(t *testing.T) {
+    b := bus.New(4)
+    s := NewWithSub(b.Subscribe())
+    s.SetClusterLock(config.ClusterLock{Operators: []config.Operator{{Index: 0, PeerID: "a"}, {Index: 1, PeerID: "b"}, {Index: 2, PeerID: "c"}, {Index: 3, PeerID: "d"}}})
+    s.SetVerifier(okVerifier{})
+    ch := s.SubscribeDecided(1)
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    if err := s.Start(ctx); err != nil { t.Fatalf("start: %v", err) }
+
+    // Drive the manager directly, as the p2p layer would.
+    msgs := []qbft.Message{{ID: "blk", From: "b", Type: qbft.MsgPreprepare, Height: 1}}
+    for _, f := range []string{"a", "b", "c"} { msgs = append(msgs, qbft.Message{ID: "blk", From: f, Type: qbft.MsgPrepare, Height: 1}) }
+    for _, f := range []string{"a", "b", "c"} { msgs = append(msgs, qbft.Message{ID: "blk", From: f, Type: qbft.MsgCommit, Height: 1}) }
+    for _, m := range msgs {
+        if err := s.st.Process(m); err != nil { t.Fatalf("%s from %s: %v", m.Type, m.From, err) }
+    }
+    select {
+    case d := <-ch:
+        if d.Height != 1 || d.ProposalID != "blk" { t.Fatalf("unexpected decision: %+v", d) }
+    case <-time.After(time.Second):
+        t.Fatalf("no decision delivered")
+    }
+}
@@ -0,0 +1,177 @@
+package consensus
+
+import (
+    "context"
+    "crypto/ed25519"
+    "crypto/rand"
+    "errors"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    qbft "github.com/zmlAEQ/Aequa-network/internal/consensus/qbft"
+    "github.com/zmlAEQ/Aequa-network/internal/state"
+    "github.com/zmlAEQ/Aequa-network/pkg/bus"
+    "github.com/zmlAEQ/Aequa-network/pkg/config"
+    "github.com/zmlAEQ/Aequa-network/pkg/metrics"
+)
+
+type capture struct {
+    mu   sync.Mutex
+    msgs []qbft.Message
+}
+
+func (c *capture) send(m qbft.Message) { c.mu.Lock(); c.msgs = append(c.msgs, m); c.mu.Unlock() }
+
+func (c *capture) ofType(t qbft.Type) []qbft.Message {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    var out []qbft.Message
+    for _, m := range c.msgs { if m.Type == t { out = append(out, m) } }
+    return out
+}
+
+var dutyLock = config.ClusterLock{Operators: []config.Operator{{Index: 0, PeerID: "a"}, {Index: 1, PeerID: "b"}, {Index: 2, PeerID: "c"}, {Index: 3, PeerID: "d"}}}
+
+// startDutyNode runs a Service as operator self and returns its bus and own outbound messages.
+func startDutyNode(t *testing.T, ctx context.Context, self string, opts ...func(*Service)) (*bus.Bus, *capture, <-chan qbft.Decided) {
+    t.Helper()
+    _, key, _ := ed25519.GenerateKey(rand.Reader)
+    b := bus.New(16)
+    s := NewWithSub(b.Subscribe())
+    s.SetClusterLock(dutyLock)
+    s.SetVerifier(okVerifier{})
+    s.SetSigner(qbft.NewKeySigner(self, key))
+    out := &capture{}
+    s.SetTransport(out.send)
+    ch := s.SubscribeDecided(1)
+    for _, o := range opts { o(s) }
+    if err := s.Start(ctx); err != nil { t.Fatalf("start: %v", err) }
+    return b, out, ch
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+    t.Helper()
+    for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+        if cond() { return }
+    }
+    t.Fatalf("timed out waiting for %s", what)
+}
+
+// The leader of a duty's first round proposes the duty payload and, with
+// votes from its peers, decides it.
+func TestService_Duty_LeaderProposesAndDecides(t *testing.T) {
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    leader := qbft.LeaderFromLock(dutyLock)(7, 0)
+    b, out, decided := startDutyNode(t, ctx, leader)
+    payload := []byte(`{"slot":7}`)
+    b.Publish(ctx, bus.Event{Kind: bus.KindDuty, Height: 7, Body: bus.Duty{Type: "attester", Height: 7, Payload: payload}, TraceID: "d7"})
+
+    waitFor(t, "preprepare", func() bool { return len(out.ofType(qbft.MsgPreprepare)) == 1 })
+    pp := out.ofType(qbft.MsgPreprepare)[0]
+    if pp.From != leader || pp.Duty != "attester" || pp.Height != 7 || string(pp.Payload) != string(payload) { t.Fatalf("unexpected proposal: %+v", pp) }
+    // The leader processes its own proposal and prepares it.
+    waitFor(t, "own prepare", func() bool { return len(out.ofType(qbft.MsgPrepare)) == 1 })
+
+    for _, typ := range []qbft.Type{qbft.MsgPrepare, qbft.MsgCommit} {
+        for _, from := range []string{"a", "b", "c", "d"} {
+            if from == leader { continue }
+            m := qbft.Message{ID: from + string(typ), ProposalID: pp.ProposalID, From: from, Type: typ, Duty: "attester", Height: 7}
+            b.Publish(ctx, bus.Event{Kind: bus.KindConsensus, Body: m})
+        }
+    }
+    select {
+    case d := <-decided:
+        if d.Height != 7 || d.ProposalID != pp.ProposalID || string(d.Value) != string(payload) { t.Fatalf("unexpected decision: %+v", d) }
+    case <-time.After(time.Second):
+        t.Fatalf("no decision")
+    }
+}
+
+// A follower starts the instance but waits for the leader's proposal.
+func TestService_Duty_FollowerWaitsForLeader(t *testing.T) {
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    leader := qbft.LeaderFromLock(dutyLock)(7, 0)
+    follower := "a"
+    if leader == "a" { follower = "b" }
+    b, out, _ := startDutyNode(t, ctx, follower)
+    b.Publish(ctx, bus.Event{Kind: bus.KindDuty, Height: 7, Body: bus.Duty{Type: "attester", Height: 7, Payload: []byte(`{"slot":7}`)}})
+    time.Sleep(30 * time.Millisecond)
+    if got := out.ofType(qbft.MsgPreprepare); len(got) != 0 { t.Fatalf("follower proposed: %+v", got) }
+
+    pp := qbft.Message{ID: "pp", ProposalID: "v", From: leader, Type: qbft.MsgPreprepare, Duty: "attester", Height: 7, Payload: []byte(`{"slot":7}`)}
+    b.Publish(ctx, bus.Event{Kind: bus.KindConsensus, Body: pp})
+    waitFor(t, "prepare", func() bool { return len(out.ofType(qbft.MsgPrepare)) == 1 })
+    if p := out.ofType(qbft.MsgPrepare)[0]; p.ProposalID != "v" || p.From != follower { t.Fatalf("unexpected prepare: %+v", p) }
+}
+
+// Without a proposal from the round-0 leader, the round timer moves the node
+// to round 1 and it broadcasts a roundchange.
+func TestService_Duty_LeaderTimeoutMovesToRoundOne(t *testing.T) {
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    leader := qbft.LeaderFromLock(dutyLock)(7, 0)
+    follower := "a"
+    if leader == "a" { follower = "b" }
+    b, out, _ := startDutyNode(t, ctx, follower, func(s *Service) { s.SetRoundTimer(qbft.RoundTimer{Base: 50 * time.Millisecond}) })
+    b.Publish(ctx, bus.Event{Kind: bus.KindDuty, Height: 7, Body: bus.Duty{Type: "attester", Height: 7, Payload: []byte(`{"slot":7}`)}})
+    waitFor(t, "roundchange", func() bool { return len(out.ofType(qbft.MsgRoundChange)) > 0 })
+    if rc := out.ofType(qbft.MsgRoundChange)[0]; rc.Round != 1 || rc.Height != 7 || rc.Duty != "attester" || rc.From != follower {
+        t.Fatalf("unexpected roundchange: %+v", rc)
+    }
+}
+
+// hookStore runs onSave before each state save and fails it with err.
+type hookStore struct {
+    state.Store
+    onSave func(state.LastState)
+    err    error
+}
+
+func (h *hookStore) SaveLastState(ctx context.Context, ls state.LastState) error {
+    h.onSave(ls)
+    if h.err != nil { return h.err }
+    return h.Store.SaveLastState(ctx, ls)
+}
+
+// The prepare a proposal gives rise to reaches the transport only after the
+// state recording it is saved, and not at all when the save fails.
+func TestService_Duty_VotesSentAfterStateSaved(t *testing.T) {
+    leader := qbft.LeaderFromLock(dutyLock)(7, 0)
+    follower := "a"
+    if leader == "a" { follower = "b" }
+    pp := qbft.Message{ID: "pp", ProposalID: "v", From: leader, Type: qbft.MsgPreprepare, Duty: "attester", Height: 7, Payload: []byte(`{"slot":7}`)}
+    for _, fail := range []bool{false, true} {
+        metrics.Reset()
+        ctx, cancel := context.WithCancel(context.Background())
+        var out *capture
+        var mu sync.Mutex
+        early, checked := false, false
+        hs := &hookStore{Store: state.NewMemoryStore()}
+        hs.onSave = func(ls state.LastState) {
+            if ls.Instance == nil || len(ls.Instance.OwnVotes) == 0 { return }
+            mu.Lock()
+            // The first save holding the own prepare precedes its send.
+            if !checked { early, checked = len(out.ofType(qbft.MsgPrepare)) > 0, true }
+            mu.Unlock()
+        }
+        if fail { hs.err = errors.New("disk full") }
+        b, o, _ := startDutyNode(t, ctx, follower, func(s *Service) { s.SetStore(hs) })
+        out = o
+        b.Publish(ctx, bus.Event{Kind: bus.KindDuty, Height: 7, Body: bus.Duty{Type: "attester", Height: 7, Payload: []byte(`{"slot":7}`)}})
+        b.Publish(ctx, bus.Event{Kind: bus.KindConsensus, Body: pp})
+        if fail {
+            waitFor(t, "withheld prepare", func() bool { return strings.Contains(metrics.DumpProm(), "consensus_sends_withheld_total 1") })
+            if got := out.ofType(qbft.MsgPrepare); len(got) != 0 { t.Fatalf("prepare sent without a saved state: %+v", got) }
+        } else {
+            waitFor(t, "prepare", func() bool { return len(out.ofType(qbft.MsgPrepare)) == 1 })
+            mu.Lock()
+            if early { t.Fatalf("prepare sent before the state was saved") }
+            mu.Unlock()
+        }
+        cancel()
+    }
+}
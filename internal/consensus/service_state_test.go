@@ -43,8 +43,8 @@ func TestService_StateStore_LoadAndSaveCalled(t *testing.T) {
     defer cancel()
     if err := s.Start(ctx); err != nil { t.Fatalf("start: %v", err) }
 
-    // One event triggers a save after verify
-    b.Publish(ctx, bus.Event{Kind: bus.KindDuty})
+    // One message triggers a save after verify
+    b.Publish(ctx, bus.Event{Kind: bus.KindConsensus, Body: qbft.Message{ID: "m1", From: "a", Type: qbft.MsgPrepare, Height: 1, Round: 1}})
     time.Sleep(30 * time.Millisecond)
 
     if atomic.LoadInt32(&st.loads) == 0 {
@@ -2,12 +2,15 @@ package consensus
 
 import (
     "context"
+    "crypto/ed25519"
+    "strconv"
     "sync/atomic"
     "testing"
     "time"
 
     qbft "github.com/zmlAEQ/Aequa-network/internal/consensus/qbft"
     "github.com/zmlAEQ/Aequa-network/pkg/bus"
+    "github.com/zmlAEQ/Aequa-network/pkg/config"
 )
 
 type stubVerifier struct{ calls int32 }
@@ -28,7 +31,7 @@ func TestService_InvokesVerifierOnEvent(t *testing.T) {
     defer cancel()
     if err := s.Start(ctx); err != nil { t.Fatalf("start: %v", err) }
 
-    b.Publish(ctx, bus.Event{Kind: bus.KindDuty})
+    b.Publish(ctx, bus.Event{Kind: bus.KindConsensus, Body: qbft.Message{ID: "m1", From: "a", Type: qbft.MsgPrepare, Height: 1, Round: 1}})
 
     // Wait briefly to allow processing
     time.Sleep(30 * time.Millisecond)
@@ -38,3 +41,42 @@ func TestService_InvokesVerifierOnEvent(t *testing.T) {
     }
 }
 
+
+// A malformed operator key in the lock fails Start instead of silently
+// disabling signature checks for the whole cluster.
+func TestService_Start_RejectsMalformedLockKey(t *testing.T) {
+    lock := config.ClusterLock{Operators: append([]config.Operator(nil), dutyLock.Operators...)}
+    lock.Operators[2].PubKey = "not-hex"
+    b := bus.New(4)
+    s := NewWithSub(b.Subscribe())
+    s.SetClusterLock(lock)
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    if err := s.Start(ctx); err == nil { t.Fatalf("want start error for malformed operator key") }
+    if _, err := Replay(nil, ReplayOptions{Lock: &lock}); err == nil { t.Fatalf("want replay error for malformed operator key") }
+}
+
+// The default verifier rate-limits peers unless told otherwise, and never
+// limits this node's own messages looping back through it.
+func TestService_DefaultVerifier_RateLimitsPeersNotSelf(t *testing.T) {
+    _, key, _ := ed25519.GenerateKey(nil)
+    s := New()
+    s.SetSigner(qbft.NewKeySigner("a", key))
+    s.SetRateLimits(qbft.RateLimits{PerSender: qbft.Rate{PerSecond: 0.001, Burst: 2}})
+    v := s.defaultVerifier()
+    for i := 0; i < 10; i++ {
+        if err := v.Verify(qbft.Message{ID: "own" + strconv.Itoa(i), From: "a", Type: qbft.MsgPrepare, Height: 1}); err != nil { t.Fatalf("own message %d: %v", i, err) }
+    }
+    var limited int
+    for i := 0; i < 10; i++ {
+        if v.Verify(qbft.Message{ID: "peer" + strconv.Itoa(i), From: "b", Type: qbft.MsgPrepare, Height: 1}) != nil { limited++ }
+    }
+    if limited != 8 { t.Fatalf("want 8 peer messages limited, got %d", limited) }
+
+    limited = 0
+    v = New().defaultVerifier()
+    for i := 0; i < 1000; i++ {
+        if v.Verify(qbft.Message{ID: "flood" + strconv.Itoa(i), From: "b", Type: qbft.MsgPrepare, Height: 1}) != nil { limited++ }
+    }
+    if limited == 0 { t.Fatalf("default limits not applied") }
+}
@@ -0,0 +1,41 @@
+package consensus
+
+import (
+    qbft "github.com/zmlAEQ/Aequa-network/internal/consensus/qbft"
+    "github.com/zmlAEQ/Aequa-network/internal/p2p"
+    "github.com/zmlAEQ/Aequa-network/pkg/logger"
+    "github.com/zmlAEQ/Aequa-network/pkg/metrics"
+)
+
+// Transport carries encoded consensus messages between nodes.
+type Transport interface {
+    Broadcast(msg p2p.Message)
+    SetHandler(f func(p2p.Message))
+}
+
+// Connect routes s's own messages to its peers through tr and queues the
+// messages tr receives for verification by s. Peer messages bypass the bus,
+// so a flood drops peer messages (counted with reason "backpressure") and
+// never duties. Must be called before Start.
+func Connect(s *Service, tr Transport) {
+    inbox := s.peerInbox()
+    s.SetTransport(func(msg qbft.Message) {
+        payload, err := qbft.Marshal(msg)
+        if err != nil { logger.ErrorJ("consensus_transport", map[string]any{"op":"encode", "result":"error", "err": err.Error(), "trace_id": msg.TraceID}); return }
+        tr.Broadcast(p2p.Message{From: p2p.PeerID(msg.From), Payload: payload, TraceID: msg.TraceID})
+    })
+    tr.SetHandler(func(pm p2p.Message) {
+        msg, err := qbft.Unmarshal(pm.Payload)
+        if err != nil {
+            metrics.Inc("consensus_transport_dropped_total", map[string]string{"reason":"decode"})
+            logger.ErrorJ("consensus_transport", map[string]any{"op":"decode", "result":"error", "peer_id": string(pm.From), "err": err.Error(), "trace_id": pm.TraceID})
+            return
+        }
+        if msg.TraceID == "" { msg.TraceID = pm.TraceID }
+        select {
+        case inbox <- msg:
+        default:
+            metrics.Inc("consensus_transport_dropped_total", map[string]string{"reason":"backpressure"})
+        }
+    })
+}
@@ -0,0 +1,91 @@
+package consensus
+
+import (
+    "context"
+    "crypto/ed25519"
+    "crypto/rand"
+    "strconv"
+    "strings"
+    "testing"
+
+    qbft "github.com/zmlAEQ/Aequa-network/internal/consensus/qbft"
+    "github.com/zmlAEQ/Aequa-network/internal/p2p"
+    "github.com/zmlAEQ/Aequa-network/pkg/bus"
+    "github.com/zmlAEQ/Aequa-network/pkg/metrics"
+)
+
+// tap records the consensus messages a transport delivers.
+type tap struct {
+    *p2p.HTTPTransport
+    capture
+}
+
+func (t *tap) SetHandler(f func(p2p.Message)) {
+    t.HTTPTransport.SetHandler(func(pm p2p.Message) {
+        if m, err := qbft.Unmarshal(pm.Payload); err == nil { t.send(m) }
+        f(pm)
+    })
+}
+
+// Two nodes connected over HTTP transports exchange a proposal and a prepare.
+func TestConnect_TwoNodesExchangeMessages(t *testing.T) {
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    leader := qbft.LeaderFromLock(dutyLock)(3, 0)
+    follower := "a"
+    if leader == follower { follower = "b" }
+
+    node := func(self string, peers map[p2p.PeerID]string) (*bus.Bus, *tap) {
+        _, key, _ := ed25519.GenerateKey(rand.Reader)
+        tr := &tap{HTTPTransport: p2p.NewHTTPTransport(p2p.PeerID(self), "127.0.0.1:0", peers)}
+        if err := tr.Start(ctx); err != nil { t.Fatalf("transport: %v", err) }
+        t.Cleanup(func() { _ = tr.Stop(context.Background()) })
+        b := bus.New(16)
+        s := NewWithSub(b.Subscribe())
+        s.SetClusterLock(dutyLock)
+        s.SetVerifier(okVerifier{})
+        s.SetSigner(qbft.NewKeySigner(self, key))
+        Connect(s, tr)
+        if err := s.Start(ctx); err != nil { t.Fatalf("start: %v", err) }
+        return b, tr
+    }
+    // The follower starts first so the leader knows its address.
+    _, ft := node(follower, map[p2p.PeerID]string{p2p.PeerID(leader): "http://127.0.0.1:1"})
+    lb, lt := node(leader, map[p2p.PeerID]string{p2p.PeerID(follower): "http://" + ft.Addr()})
+    ft.SetPeer(p2p.PeerID(leader), "http://"+lt.Addr())
+
+    lb.Publish(ctx, bus.Event{Kind: bus.KindDuty, Height: 3, Body: bus.Duty{Type: "attester", Height: 3, Payload: []byte(`{"slot":3}`)}})
+    waitFor(t, "follower receives the proposal", func() bool { return len(ft.ofType(qbft.MsgPreprepare)) == 1 })
+    waitFor(t, "leader receives the follower's prepare", func() bool {
+        for _, m := range lt.ofType(qbft.MsgPrepare) { if m.From == follower { return true } }
+        return false
+    })
+    if pp := ft.ofType(qbft.MsgPreprepare)[0]; pp.From != leader || string(pp.Payload) != `{"slot":3}` { t.Fatalf("unexpected proposal: %+v", pp) }
+}
+
+// fakeTransport hands the test the handler Connect installs.
+type fakeTransport struct{ h func(p2p.Message) }
+
+func (f *fakeTransport) Broadcast(p2p.Message)            {}
+func (f *fakeTransport) SetHandler(h func(p2p.Message)) { f.h = h }
+
+// A peer flood overflows the peer inbox, not the bus: duties still start.
+func TestConnect_PeerFloodDoesNotDropDuties(t *testing.T) {
+    metrics.Reset()
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    b := bus.New(4)
+    s := NewWithSub(b.Subscribe())
+    s.SetClusterLock(dutyLock)
+    s.SetVerifier(okVerifier{})
+    tr := &fakeTransport{}
+    Connect(s, tr)
+    for i := 0; i < inboxSize+500; i++ {
+        payload, _ := qbft.Marshal(qbft.Message{ID: strconv.Itoa(i), From: "b", Type: qbft.MsgPrepare, Duty: "attester", Height: 9})
+        tr.h(p2p.Message{From: "b", Payload: payload})
+    }
+    b.Publish(ctx, bus.Event{Kind: bus.KindDuty, Height: 3, Body: bus.Duty{Type: "attester", Height: 3, Payload: []byte(`{"slot":3}`)}})
+    if err := s.Start(ctx); err != nil { t.Fatalf("start: %v", err) }
+    waitFor(t, "duty started", func() bool { return strings.Contains(metrics.DumpProm(), `consensus_duties_total{result="started",type="attester"} 1`) })
+    if !strings.Contains(metrics.DumpProm(), `consensus_transport_dropped_total{reason="backpressure"} 500`) { t.Fatalf("overflow not counted: %q", metrics.DumpProm()) }
+}
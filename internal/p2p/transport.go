@@ -0,0 +1,245 @@
+package p2p
+
+import (
+    "bytes"
+    "context"
+    "crypto/ed25519"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "io"
+    "net"
+    "net/http"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/zmlAEQ/Aequa-network/pkg/lifecycle"
+    "github.com/zmlAEQ/Aequa-network/pkg/logger"
+    "github.com/zmlAEQ/Aequa-network/pkg/metrics"
+)
+
+// TransportPath is the HTTP path peers post messages to.
+const TransportPath = "/v1/p2p/message"
+
+// maxTransportPayload bounds an inbound message body.
+const maxTransportPayload = 1 << 20
+
+// sendQueueSize bounds the messages waiting to be sent to one peer; further
+// messages to a peer that is that far behind are dropped.
+const sendQueueSize = 256
+
+// transportSigDomain separates request signatures from other uses of the key.
+const transportSigDomain = "aequa-p2p-v1"
+
+// HTTPTransport exchanges messages with a static set of peers over HTTP
+// (placeholder for a libp2p pubsub topic). Broadcast queues a message for
+// every peer, each served by one sender goroutine; messages posted by known
+// peers are handed to the handler. With SetKeys, requests are signed and
+// only requests signed by the claimed peer's key are accepted; without keys
+// the X-Peer-ID header is trusted as is.
+type HTTPTransport struct {
+    self    PeerID
+    addr    string
+    client  *http.Client
+    done    chan struct{}
+    stop    sync.Once
+
+    mu       sync.RWMutex
+    peers    map[PeerID]string
+    queues   map[PeerID]chan Message
+    key      ed25519.PrivateKey
+    peerKeys map[PeerID]ed25519.PublicKey
+    handler  func(Message)
+    ln       net.Listener
+    srv      *http.Server
+}
+
+// NewHTTPTransport listens on addr as self and broadcasts to peers, keyed by
+// peer id with their base URL (e.g. "http://10.0.0.2:4610").
+func NewHTTPTransport(self PeerID, addr string, peers map[PeerID]string) *HTTPTransport {
+    ps := make(map[PeerID]string, len(peers))
+    for id, u := range peers { ps[id] = strings.TrimRight(u, "/") }
+    return &HTTPTransport{self: self, addr: addr, peers: ps, queues: make(map[PeerID]chan Message), done: make(chan struct{}), client: &http.Client{Timeout: 2 * time.Second}}
+}
+
+// SetKeys signs outbound requests with key and requires inbound requests to
+// be signed by the key of the peer they claim to come from. Peers without a
+// key in peerKeys are refused.
+func (t *HTTPTransport) SetKeys(key ed25519.PrivateKey, peerKeys map[PeerID]ed25519.PublicKey) {
+    t.mu.Lock(); t.key, t.peerKeys = key, peerKeys; t.mu.Unlock()
+}
+
+// ParsePeers parses a comma-separated list of id=url peers.
+func ParsePeers(s string) (map[PeerID]string, error) {
+    out := make(map[PeerID]string)
+    for _, part := range strings.Split(s, ",") {
+        part = strings.TrimSpace(part)
+        if part == "" { continue }
+        id, u, ok := strings.Cut(part, "=")
+        if !ok || id == "" || u == "" { return nil, fmt.Errorf("invalid peer %q, want id=url", part) }
+        out[PeerID(id)] = u
+    }
+    return out, nil
+}
+
+func (t *HTTPTransport) Name() string { return "p2p_transport" }
+
+// SetPeer adds or updates the base URL of peer id.
+func (t *HTTPTransport) SetPeer(id PeerID, url string) { t.mu.Lock(); t.peers[id] = strings.TrimRight(url, "/"); t.mu.Unlock() }
+
+// SetHandler sets the receiver of inbound messages.
+func (t *HTTPTransport) SetHandler(f func(Message)) { t.mu.Lock(); t.handler = f; t.mu.Unlock() }
+
+// Addr returns the listen address once started.
+func (t *HTTPTransport) Addr() string {
+    t.mu.RLock(); defer t.mu.RUnlock()
+    if t.ln == nil { return t.addr }
+    return t.ln.Addr().String()
+}
+
+func (t *HTTPTransport) Start(ctx context.Context) error {
+    begin := time.Now()
+    ln, err := net.Listen("tcp", t.addr)
+    if err != nil {
+        dur := time.Since(begin).Milliseconds()
+        logger.ErrorJ("service_op", map[string]any{"service":"p2p_transport", "op":"start", "result":"error", "err": err.Error(), "latency_ms": dur})
+        metrics.ObserveSummary("service_op_ms", map[string]string{"service":"p2p_transport", "op":"start"}, float64(dur))
+        return err
+    }
+    mux := http.NewServeMux()
+    mux.HandleFunc(TransportPath, t.handle)
+    srv := &http.Server{Handler: mux}
+    t.mu.Lock(); t.ln = ln; t.srv = srv; t.mu.Unlock()
+    go func() {
+        if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+            logger.Error("p2p transport error: " + err.Error())
+        }
+    }()
+    dur := time.Since(begin).Milliseconds()
+    logger.InfoJ("service_op", map[string]any{"service":"p2p_transport", "op":"start", "result":"ok", "addr": ln.Addr().String(), "latency_ms": dur})
+    metrics.ObserveSummary("service_op_ms", map[string]string{"service":"p2p_transport", "op":"start"}, float64(dur))
+    return nil
+}
+
+func (t *HTTPTransport) Stop(ctx context.Context) error {
+    begin := time.Now()
+    t.stop.Do(func() { close(t.done) })
+    t.mu.RLock(); srv := t.srv; t.mu.RUnlock()
+    var err error
+    if srv != nil {
+        ctx2, cancel := context.WithTimeout(ctx, 3*time.Second); defer cancel()
+        err = srv.Shutdown(ctx2)
+    }
+    result := "ok"
+    if err != nil { result = "error" }
+    dur := time.Since(begin).Milliseconds()
+    logger.InfoJ("service_op", map[string]any{"service":"p2p_transport", "op":"stop", "result": result, "latency_ms": dur})
+    metrics.ObserveSummary("service_op_ms", map[string]string{"service":"p2p_transport", "op":"stop"}, float64(dur))
+    return err
+}
+
+// Broadcast queues msg for every peer and returns without waiting. A peer
+// whose queue is full misses the message (counted as send_dropped).
+func (t *HTTPTransport) Broadcast(msg Message) {
+    t.mu.Lock(); defer t.mu.Unlock()
+    for id := range t.peers {
+        if id == t.self { continue }
+        select {
+        case t.queue(id) <- msg:
+        default:
+            metrics.Inc("p2p_messages_total", map[string]string{"kind":"send_dropped"})
+        }
+    }
+}
+
+// queue returns the send queue of peer id, starting its sender on first use.
+// Callers hold t.mu.
+func (t *HTTPTransport) queue(id PeerID) chan Message {
+    q, ok := t.queues[id]
+    if !ok {
+        q = make(chan Message, sendQueueSize)
+        t.queues[id] = q
+        go t.sender(id, q)
+    }
+    return q
+}
+
+// sender posts the queued messages of one peer in order until Stop.
+func (t *HTTPTransport) sender(id PeerID, q chan Message) {
+    for {
+        select {
+        case msg := <-q:
+            t.mu.RLock(); base, ok := t.peers[id]; t.mu.RUnlock()
+            if ok { t.send(id, base, msg) }
+        case <-t.done:
+            return
+        }
+    }
+}
+
+func (t *HTTPTransport) send(id PeerID, base string, msg Message) {
+    begin := time.Now()
+    req, err := http.NewRequest(http.MethodPost, base+TransportPath, bytes.NewReader(msg.Payload))
+    if err == nil {
+        req.Header.Set("X-Peer-ID", string(t.self))
+        t.mu.RLock(); key := t.key; t.mu.RUnlock()
+        if key != nil { req.Header.Set("X-Peer-Sig", hex.EncodeToString(ed25519.Sign(key, sigInput(t.self, msg.Payload)))) }
+        if msg.TraceID != "" { req.Header.Set("X-Trace-ID", msg.TraceID) }
+        var resp *http.Response
+        resp, err = t.client.Do(req)
+        if err == nil {
+            _ = resp.Body.Close()
+            if resp.StatusCode != http.StatusAccepted { err = fmt.Errorf("status %d", resp.StatusCode) }
+        }
+    }
+    metrics.ObserveSummary("p2p_broadcast_ms", map[string]string{"kind":"send"}, float64(time.Since(begin).Milliseconds()))
+    if err != nil {
+        metrics.Inc("p2p_messages_total", map[string]string{"kind":"send_error"})
+        logger.ErrorJ("p2p_send", map[string]any{"peer_id": string(id), "result":"error", "err": err.Error(), "trace_id": msg.TraceID})
+        return
+    }
+    metrics.Inc("p2p_messages_total", map[string]string{"kind":"send"})
+}
+
+// sigInput is what a request signature covers: the domain, the sender id and
+// the body, so a signature cannot be presented under another peer's id.
+func sigInput(from PeerID, body []byte) []byte {
+    b := make([]byte, 0, len(transportSigDomain)+1+len(from)+1+len(body))
+    b = append(b, transportSigDomain...)
+    b = append(b, 0)
+    b = append(b, from...)
+    b = append(b, 0)
+    return append(b, body...)
+}
+
+func (t *HTTPTransport) handle(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost { w.WriteHeader(http.StatusMethodNotAllowed); return }
+    from := PeerID(r.Header.Get("X-Peer-ID"))
+    t.mu.RLock(); _, known := t.peers[from]; h := t.handler; keys := t.peerKeys; t.mu.RUnlock()
+    if !known || from == t.self {
+        metrics.Inc("p2p_messages_total", map[string]string{"kind":"recv_denied"})
+        w.WriteHeader(http.StatusForbidden)
+        return
+    }
+    body, err := io.ReadAll(io.LimitReader(r.Body, maxTransportPayload+1))
+    if err != nil || len(body) > maxTransportPayload {
+        metrics.Inc("p2p_messages_total", map[string]string{"kind":"recv_error"})
+        w.WriteHeader(http.StatusBadRequest)
+        return
+    }
+    if keys != nil {
+        sig, err := hex.DecodeString(r.Header.Get("X-Peer-Sig"))
+        if pub, ok := keys[from]; !ok || err != nil || len(pub) != ed25519.PublicKeySize || !ed25519.Verify(pub, sigInput(from, body), sig) {
+            metrics.Inc("p2p_messages_total", map[string]string{"kind":"recv_denied"})
+            logger.ErrorJ("p2p_recv", map[string]any{"peer_id": string(from), "result":"denied", "reason":"bad_signature", "trace_id": r.Header.Get("X-Trace-ID")})
+            w.WriteHeader(http.StatusForbidden)
+            return
+        }
+    }
+    metrics.Inc("p2p_messages_total", map[string]string{"kind":"recv"})
+    if h != nil { h(Message{From: from, Payload: body, TraceID: r.Header.Get("X-Trace-ID")}) }
+    w.WriteHeader(http.StatusAccepted)
+}
+
+var _ lifecycle.Service = (*HTTPTransport)(nil)
@@ -0,0 +1,137 @@
+package p2p
+
+import (
+    "bytes"
+    "context"
+    "crypto/ed25519"
+    "encoding/hex"
+    "net/http"
+    "net/http/httptest"
+    "runtime"
+    "strconv"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/zmlAEQ/Aequa-network/pkg/metrics"
+)
+
+func newKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+    pub, key, err := ed25519.GenerateKey(nil)
+    if err != nil { t.Fatal(err) }
+    return pub, key
+}
+
+// post sends body to tr's handler as from, signed with key if set.
+func post(t *testing.T, tr *HTTPTransport, method string, from PeerID, key ed25519.PrivateKey, body []byte) int {
+    req := httptest.NewRequest(method, TransportPath, bytes.NewReader(body))
+    req.Header.Set("X-Peer-ID", string(from))
+    req.Header.Set("X-Trace-ID", "tid")
+    if key != nil { req.Header.Set("X-Peer-Sig", hex.EncodeToString(ed25519.Sign(key, sigInput(from, body)))) }
+    w := httptest.NewRecorder()
+    tr.handle(w, req)
+    return w.Code
+}
+
+func TestHTTPTransport_HandleAuthenticatesPeers(t *testing.T) {
+    metrics.Reset()
+    pubB, keyB := newKey(t)
+    _, keyC := newKey(t)
+    tr := NewHTTPTransport("A", "127.0.0.1:0", map[PeerID]string{"B": "http://b", "C": "http://c"})
+    tr.SetKeys(nil, map[PeerID]ed25519.PublicKey{"B": pubB})
+    var got []Message
+    tr.SetHandler(func(m Message) { got = append(got, m) })
+
+    if code := post(t, tr, http.MethodPost, "B", keyB, []byte("hello")); code != http.StatusAccepted { t.Fatalf("signed by B: %d", code) }
+    if len(got) != 1 || got[0].From != "B" || string(got[0].Payload) != "hello" || got[0].TraceID != "tid" { t.Fatalf("delivered %+v", got) }
+
+    cases := []struct {
+        name   string
+        method string
+        from   PeerID
+        key    ed25519.PrivateKey
+        body   []byte
+        code   int
+    }{
+        {"spoofed id", http.MethodPost, "B", keyC, []byte("x"), http.StatusForbidden},
+        {"unsigned", http.MethodPost, "B", nil, []byte("x"), http.StatusForbidden},
+        {"peer without key", http.MethodPost, "C", keyC, []byte("x"), http.StatusForbidden},
+        {"unknown peer", http.MethodPost, "Z", keyB, []byte("x"), http.StatusForbidden},
+        {"self", http.MethodPost, "A", keyB, []byte("x"), http.StatusForbidden},
+        {"oversize", http.MethodPost, "B", keyB, make([]byte, maxTransportPayload+1), http.StatusBadRequest},
+        {"method", http.MethodGet, "B", keyB, nil, http.StatusMethodNotAllowed},
+    }
+    for _, c := range cases {
+        if code := post(t, tr, c.method, c.from, c.key, c.body); code != c.code { t.Fatalf("%s: got %d want %d", c.name, code, c.code) }
+    }
+    if len(got) != 1 { t.Fatalf("rejected requests reached the handler: %d", len(got)) }
+    if !strings.Contains(metrics.DumpProm(), `p2p_messages_total{kind="recv_denied"} 5`) { t.Fatalf("denials not counted: %q", metrics.DumpProm()) }
+}
+
+// A signature is bound to the sender id: B's signature presented with C's id fails.
+func TestHTTPTransport_SignatureBoundToSender(t *testing.T) {
+    pubB, keyB := newKey(t)
+    pubC, _ := newKey(t)
+    tr := NewHTTPTransport("A", "127.0.0.1:0", map[PeerID]string{"B": "http://b", "C": "http://c"})
+    tr.SetKeys(nil, map[PeerID]ed25519.PublicKey{"B": pubB, "C": pubC})
+    req := httptest.NewRequest(http.MethodPost, TransportPath, strings.NewReader("x"))
+    req.Header.Set("X-Peer-ID", "C")
+    req.Header.Set("X-Peer-Sig", hex.EncodeToString(ed25519.Sign(keyB, sigInput("B", []byte("x")))))
+    w := httptest.NewRecorder()
+    tr.handle(w, req)
+    if w.Code != http.StatusForbidden { t.Fatalf("replayed signature accepted: %d", w.Code) }
+}
+
+// Two started transports exchange signed messages in order, and nothing is
+// sent to self.
+func TestHTTPTransport_BroadcastDeliversInOrder(t *testing.T) {
+    ctx := context.Background()
+    pubA, keyA := newKey(t)
+    pubB, keyB := newKey(t)
+    b := NewHTTPTransport("B", "127.0.0.1:0", nil)
+    var mu sync.Mutex
+    var got []string
+    b.SetHandler(func(m Message) { mu.Lock(); got = append(got, string(m.Payload)); mu.Unlock() })
+    if err := b.Start(ctx); err != nil { t.Fatal(err) }
+    defer b.Stop(ctx)
+    a := NewHTTPTransport("A", "127.0.0.1:0", map[PeerID]string{"A": "http://127.0.0.1:1", "B": "http://" + b.Addr()})
+    a.SetKeys(keyA, map[PeerID]ed25519.PublicKey{"B": pubB})
+    b.SetPeer("A", "http://unused")
+    b.SetKeys(keyB, map[PeerID]ed25519.PublicKey{"A": pubA})
+    defer a.Stop(ctx)
+
+    metrics.Reset()
+    for i := 0; i < 20; i++ { a.Broadcast(Message{Payload: []byte(strconv.Itoa(i))}) }
+    deadline := time.Now().Add(2 * time.Second)
+    for time.Now().Before(deadline) {
+        mu.Lock(); n := len(got); mu.Unlock()
+        if n == 20 { break }
+        time.Sleep(5 * time.Millisecond)
+    }
+    mu.Lock(); defer mu.Unlock()
+    if len(got) != 20 { t.Fatalf("delivered %d of 20", len(got)) }
+    for i, p := range got {
+        if p != strconv.Itoa(i) { t.Fatalf("out of order at %d: %v", i, got) }
+    }
+    if strings.Contains(metrics.DumpProm(), "send_error") { t.Fatalf("sent to self or failed: %q", metrics.DumpProm()) }
+}
+
+// A stalled peer costs one sender goroutine and a bounded queue; the excess
+// is dropped and counted instead of piling up goroutines.
+func TestHTTPTransport_BroadcastBoundedForStalledPeer(t *testing.T) {
+    metrics.Reset()
+    release := make(chan struct{})
+    stalled := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-release; w.WriteHeader(http.StatusAccepted) }))
+    defer stalled.Close()
+    defer close(release)
+    a := NewHTTPTransport("A", "127.0.0.1:0", map[PeerID]string{"B": stalled.URL})
+    defer a.Stop(context.Background())
+
+    before := runtime.NumGoroutine()
+    const n = 2000
+    for i := 0; i < n; i++ { a.Broadcast(Message{Payload: []byte("x")}) }
+    if g := runtime.NumGoroutine() - before; g > 20 { t.Fatalf("broadcast spawned %d goroutines", g) }
+    dump := metrics.DumpProm()
+    if !strings.Contains(dump, `p2p_messages_total{kind="send_dropped"}`) { t.Fatalf("overflow not counted: %q", dump) }
+}
@@ -0,0 +1,255 @@
+// Package slashing implements slashing protection for the validator keys a
+// distributed validator signs with: it records every block and attestation
+// signed per validator public key and refuses requests that would conflict
+// with them, following the conditions of EIP-3076.
+package slashing
+
+import (
+    "bufio"
+    "bytes"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "strings"
+    "sync"
+
+    "github.com/zmlAEQ/Aequa-network/pkg/logger"
+    "github.com/zmlAEQ/Aequa-network/pkg/metrics"
+)
+
+var (
+    // ErrDoubleProposal is returned for a second, different block at a slot.
+    ErrDoubleProposal = errors.New("double proposal")
+    // ErrBlockSlotTooLow is returned for a block at or below the lowest slot
+    // on record (the lower bound left by an import or pruning).
+    ErrBlockSlotTooLow = errors.New("block slot at or below lower bound")
+    // ErrDoubleVote is returned for a second, different attestation with the same target.
+    ErrDoubleVote = errors.New("double vote")
+    // ErrSurroundVote is returned for an attestation surrounding, or surrounded by, a signed one.
+    ErrSurroundVote = errors.New("surround vote")
+    // ErrAttestationTooLow is returned for a source or target below the lower bound.
+    ErrAttestationTooLow = errors.New("attestation epoch below lower bound")
+    // ErrInvalidRequest is returned for malformed requests (e.g. source > target).
+    ErrInvalidRequest = errors.New("invalid signing request")
+    // ErrGenesisMismatch is returned when interchange data belongs to another chain.
+    ErrGenesisMismatch = errors.New("genesis validators root mismatch")
+    // ErrGenesisUnknown is returned when exporting a DB not yet bound to a
+    // chain: EIP-3076 requires the genesis validators root.
+    ErrGenesisUnknown = errors.New("genesis validators root unknown")
+)
+
+// SignedBlock is a block proposal signed by a validator.
+type SignedBlock struct {
+    Slot        uint64
+    SigningRoot string // hex, empty if unknown
+}
+
+// SignedAttestation is an attestation signed by a validator.
+type SignedAttestation struct {
+    Source      uint64
+    Target      uint64
+    SigningRoot string // hex, empty if unknown
+}
+
+type history struct {
+    blocks       []SignedBlock
+    attestations []SignedAttestation
+}
+
+// DB is a slashing-protection database. With a path, every accepted signing
+// request and import is appended to a JSON-lines log and fsynced before the
+// call returns, so a restarted node remembers everything it signed.
+type DB struct {
+    mu      sync.Mutex
+    path    string
+    genesis string
+    vals    map[string]*history
+}
+
+// NewMemoryDB returns a non-durable DB (tests and dry runs). An empty
+// genesisRoot is taken from the first import.
+func NewMemoryDB(genesisRoot string) *DB {
+    return &DB{genesis: normHex(genesisRoot), vals: map[string]*history{}}
+}
+
+// Open opens (or creates) the DB logged at path. A torn trailing line from
+// a crash is truncated away. genesisRoot, if set, must match the stored one.
+func Open(path, genesisRoot string) (*DB, error) {
+    db := NewMemoryDB("")
+    db.path = path
+    b, err := os.ReadFile(path)
+    if err != nil && !os.IsNotExist(err) { return nil, err }
+    if i := bytes.LastIndexByte(b, '\n'); i+1 < len(b) {
+        if err := os.Truncate(path, int64(i+1)); err != nil { return nil, err }
+        b = b[:i+1]
+    }
+    sc := bufio.NewScanner(bytes.NewReader(b))
+    sc.Buffer(nil, 1<<20)
+    for sc.Scan() {
+        if len(sc.Bytes()) == 0 { continue }
+        var e entry
+        if err := json.Unmarshal(sc.Bytes(), &e); err != nil { return nil, fmt.Errorf("slashing db %s: %w", path, err) }
+        db.apply(e)
+    }
+    if err := sc.Err(); err != nil { return nil, err }
+    if g := normHex(genesisRoot); g != "" {
+        if db.genesis != "" && db.genesis != g { return nil, ErrGenesisMismatch }
+        if db.genesis == "" {
+            if err := db.append(entry{Kind: kindGenesis, Root: g}); err != nil { return nil, err }
+            db.genesis = g
+        }
+    }
+    return db, nil
+}
+
+// GenesisValidatorsRoot returns the chain the DB is bound to ("" if unset).
+func (db *DB) GenesisValidatorsRoot() string {
+    db.mu.Lock()
+    defer db.mu.Unlock()
+    return db.genesis
+}
+
+// CheckAndSignBlock records a block proposal for pubkey at slot, or returns
+// an error if signing it could be slashable. Re-signing the exact same block
+// (same slot and signing root) is allowed.
+func (db *DB) CheckAndSignBlock(pubkey string, slot uint64, signingRoot string) error {
+    db.mu.Lock()
+    defer db.mu.Unlock()
+    pk, root := normHex(pubkey), normHex(signingRoot)
+    h := db.vals[pk]
+    if h != nil {
+        for _, b := range h.blocks {
+            if b.Slot != slot { continue }
+            if root != "" && b.SigningRoot == root { return db.result("block", "repeat", pk, nil) }
+            return db.result("block", "double_proposal", pk, ErrDoubleProposal)
+        }
+        if len(h.blocks) > 0 && slot <= minSlot(h.blocks) { return db.result("block", "slot_too_low", pk, ErrBlockSlotTooLow) }
+    }
+    e := entry{Kind: kindBlock, Pubkey: pk, Slot: slot, Root: root}
+    if err := db.append(e); err != nil { return db.result("block", "error", pk, err) }
+    db.apply(e)
+    return db.result("block", "ok", pk, nil)
+}
+
+// CheckAndSignAttestation records an attestation for pubkey, or returns an
+// error if it would be a double or surround vote, or falls below the lower
+// bound. Re-signing the exact same attestation is allowed.
+func (db *DB) CheckAndSignAttestation(pubkey string, source, target uint64, signingRoot string) error {
+    db.mu.Lock()
+    defer db.mu.Unlock()
+    pk, root := normHex(pubkey), normHex(signingRoot)
+    if source > target { return db.result("attestation", "invalid", pk, ErrInvalidRequest) }
+    h := db.vals[pk]
+    if h != nil && len(h.attestations) > 0 {
+        for _, a := range h.attestations {
+            if a.Target == target {
+                if root != "" && a.SigningRoot == root && a.Source == source { return db.result("attestation", "repeat", pk, nil) }
+                return db.result("attestation", "double_vote", pk, ErrDoubleVote)
+            }
+            if (source < a.Source && a.Target < target) || (a.Source < source && target < a.Target) {
+                return db.result("attestation", "surround_vote", pk, ErrSurroundVote)
+            }
+        }
+        minSource, minTarget := minEpochs(h.attestations)
+        if source < minSource || target <= minTarget { return db.result("attestation", "epoch_too_low", pk, ErrAttestationTooLow) }
+    }
+    e := entry{Kind: kindAttestation, Pubkey: pk, Source: source, Target: target, Root: root}
+    if err := db.append(e); err != nil { return db.result("attestation", "error", pk, err) }
+    db.apply(e)
+    return db.result("attestation", "ok", pk, nil)
+}
+
+// result counts and logs a signing decision and returns err.
+func (db *DB) result(kind, result, pubkey string, err error) error {
+    metrics.Inc("slashing_checks_total", map[string]string{"kind": kind, "result": result})
+    if err != nil {
+        logger.ErrorJ("slashing_protection", map[string]any{"kind": kind, "result": result, "pubkey": pubkey, "err": err.Error(), "trace_id": ""})
+    }
+    return err
+}
+
+const (
+    kindGenesis     = "genesis"
+    kindBlock       = "block"
+    kindAttestation = "attestation"
+)
+
+// entry is one line of the DB log.
+type entry struct {
+    Kind   string `json:"kind"`
+    Pubkey string `json:"pubkey,omitempty"`
+    Slot   uint64 `json:"slot,omitempty"`
+    Source uint64 `json:"source,omitempty"`
+    Target uint64 `json:"target,omitempty"`
+    Root   string `json:"root,omitempty"`
+}
+
+func (db *DB) apply(e entry) {
+    switch e.Kind {
+    case kindGenesis:
+        db.genesis = e.Root
+    case kindBlock:
+        h := db.history(e.Pubkey)
+        h.blocks = append(h.blocks, SignedBlock{Slot: e.Slot, SigningRoot: e.Root})
+    case kindAttestation:
+        h := db.history(e.Pubkey)
+        h.attestations = append(h.attestations, SignedAttestation{Source: e.Source, Target: e.Target, SigningRoot: e.Root})
+    }
+}
+
+func (db *DB) history(pk string) *history {
+    h := db.vals[pk]
+    if h == nil {
+        h = &history{}
+        db.vals[pk] = h
+    }
+    return h
+}
+
+// append durably logs entries (no-op for in-memory DBs).
+func (db *DB) append(es ...entry) error {
+    if db.path == "" || len(es) == 0 { return nil }
+    var buf bytes.Buffer
+    for _, e := range es {
+        line, err := json.Marshal(e)
+        if err != nil { return err }
+        buf.Write(line)
+        buf.WriteByte('\n')
+    }
+    f, err := os.OpenFile(db.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+    if err != nil { return db.fail(err) }
+    if _, err = f.Write(buf.Bytes()); err != nil { _ = f.Close(); return db.fail(err) }
+    if err = f.Sync(); err != nil { _ = f.Close(); return db.fail(err) }
+    if err = f.Close(); err != nil { return db.fail(err) }
+    return nil
+}
+
+func (db *DB) fail(err error) error {
+    metrics.Inc("state_persist_errors_total", nil)
+    logger.ErrorJ("slashing_protection", map[string]any{"op":"persist", "result":"error", "err": err.Error(), "trace_id": ""})
+    return err
+}
+
+func minSlot(bs []SignedBlock) uint64 {
+    m := bs[0].Slot
+    for _, b := range bs[1:] { if b.Slot < m { m = b.Slot } }
+    return m
+}
+
+func minEpochs(as []SignedAttestation) (source, target uint64) {
+    source, target = as[0].Source, as[0].Target
+    for _, a := range as[1:] {
+        if a.Source < source { source = a.Source }
+        if a.Target < target { target = a.Target }
+    }
+    return source, target
+}
+
+// normHex lowercases hex strings and ensures a 0x prefix.
+func normHex(s string) string {
+    s = strings.ToLower(strings.TrimSpace(s))
+    if s == "" { return "" }
+    if !strings.HasPrefix(s, "0x") { s = "0x" + s }
+    return s
+}
@@ -0,0 +1,63 @@
+package slashing
+
+import (
+    "errors"
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+const pk = "0xB845089A1457F811BFC000588FBB4E713669BE8CE060EA6BE3C6ECE09AFC3794106C91CA73ACDA5E5457122D58723BED"
+
+func TestDB_Blocks(t *testing.T) {
+    db := NewMemoryDB("")
+    if err := db.CheckAndSignBlock(pk, 10, "0x01"); err != nil { t.Fatalf("first block: %v", err) }
+    if err := db.CheckAndSignBlock(pk, 10, "0x01"); err != nil { t.Fatalf("repeat must be allowed: %v", err) }
+    if err := db.CheckAndSignBlock(pk, 10, "0x02"); !errors.Is(err, ErrDoubleProposal) { t.Fatalf("want double proposal, got %v", err) }
+    if err := db.CheckAndSignBlock(pk, 10, ""); !errors.Is(err, ErrDoubleProposal) { t.Fatalf("unknown root at a signed slot: %v", err) }
+    if err := db.CheckAndSignBlock(pk, 9, "0x03"); !errors.Is(err, ErrBlockSlotTooLow) { t.Fatalf("want lower bound, got %v", err) }
+    if err := db.CheckAndSignBlock(pk, 11, "0x04"); err != nil { t.Fatalf("next slot: %v", err) }
+    if err := db.CheckAndSignBlock("0xother", 1, "0x05"); err != nil { t.Fatalf("validators are independent: %v", err) }
+}
+
+func TestDB_Attestations(t *testing.T) {
+    db := NewMemoryDB("")
+    if err := db.CheckAndSignAttestation(pk, 2, 4, "0xa"); err != nil { t.Fatalf("first: %v", err) }
+    if err := db.CheckAndSignAttestation(pk, 2, 4, "0xa"); err != nil { t.Fatalf("repeat: %v", err) }
+    cases := []struct {
+        source, target uint64
+        want           error
+    }{
+        {3, 4, ErrDoubleVote},
+        {1, 5, ErrSurroundVote},        // surrounds 2->4
+        {2, 3, ErrAttestationTooLow},   // target below lower bound
+        {1, 4, ErrDoubleVote},
+        {5, 4, ErrInvalidRequest},
+    }
+    for _, c := range cases {
+        if err := db.CheckAndSignAttestation(pk, c.source, c.target, "0xb"); !errors.Is(err, c.want) {
+            t.Fatalf("%d->%d: want %v, got %v", c.source, c.target, c.want, err)
+        }
+    }
+    if err := db.CheckAndSignAttestation(pk, 2, 10, "0xc"); err != nil { t.Fatalf("2->10: %v", err) }
+    if err := db.CheckAndSignAttestation(pk, 3, 9, "0xd"); !errors.Is(err, ErrSurroundVote) { t.Fatalf("surrounded by 2->10: %v", err) }
+    if err := db.CheckAndSignAttestation(pk, 1, 11, "0xd"); !errors.Is(err, ErrSurroundVote) { t.Fatalf("surrounds 2->10: %v", err) }
+}
+
+func TestDB_PersistsAcrossOpen(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "slashing.jsonl")
+    db, err := Open(path, "0xAA")
+    if err != nil { t.Fatalf("open: %v", err) }
+    if err := db.CheckAndSignBlock(pk, 7, "0x01"); err != nil { t.Fatalf("block: %v", err) }
+    if err := db.CheckAndSignAttestation(pk, 1, 2, "0x02"); err != nil { t.Fatalf("attestation: %v", err) }
+    // Simulate a torn trailing write.
+    f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+    _, _ = f.Write([]byte(`{"kind":"blo`))
+    _ = f.Close()
+
+    db, err = Open(path, "0xaa")
+    if err != nil { t.Fatalf("reopen: %v", err) }
+    if err := db.CheckAndSignBlock(pk, 7, "0x09"); !errors.Is(err, ErrDoubleProposal) { t.Fatalf("block forgotten: %v", err) }
+    if err := db.CheckAndSignAttestation(pk, 1, 2, "0x09"); !errors.Is(err, ErrDoubleVote) { t.Fatalf("attestation forgotten: %v", err) }
+    if _, err := Open(path, "0xbb"); !errors.Is(err, ErrGenesisMismatch) { t.Fatalf("want genesis mismatch, got %v", err) }
+}
@@ -0,0 +1,142 @@
+package slashing
+
+import (
+    "encoding/json"
+    "fmt"
+    "io"
+    "sort"
+    "strconv"
+    "strings"
+
+    "github.com/zmlAEQ/Aequa-network/pkg/logger"
+)
+
+// InterchangeVersion is the EIP-3076 format version read and written.
+const InterchangeVersion = "5"
+
+// Interchange is the EIP-3076 slashing-protection interchange document.
+type Interchange struct {
+    Metadata InterchangeMetadata  `json:"metadata"`
+    Data     []InterchangeRecord `json:"data"`
+}
+
+// InterchangeMetadata identifies the format version and chain.
+type InterchangeMetadata struct {
+    InterchangeFormatVersion string `json:"interchange_format_version"`
+    GenesisValidatorsRoot    string `json:"genesis_validators_root"`
+}
+
+// InterchangeRecord is the signing history of one validator.
+type InterchangeRecord struct {
+    Pubkey             string                   `json:"pubkey"`
+    SignedBlocks       []InterchangeBlock       `json:"signed_blocks"`
+    SignedAttestations []InterchangeAttestation `json:"signed_attestations"`
+}
+
+// InterchangeBlock is a signed block in interchange form.
+type InterchangeBlock struct {
+    Slot        Quoted `json:"slot"`
+    SigningRoot string `json:"signing_root,omitempty"`
+}
+
+// InterchangeAttestation is a signed attestation in interchange form.
+type InterchangeAttestation struct {
+    SourceEpoch Quoted `json:"source_epoch"`
+    TargetEpoch Quoted `json:"target_epoch"`
+    SigningRoot string `json:"signing_root,omitempty"`
+}
+
+// Quoted is a uint64 encoded as a decimal JSON string, as EIP-3076 requires.
+// Bare JSON numbers are accepted on input.
+type Quoted uint64
+
+func (q Quoted) MarshalJSON() ([]byte, error) { return []byte(`"` + strconv.FormatUint(uint64(q), 10) + `"`), nil }
+
+func (q *Quoted) UnmarshalJSON(b []byte) error {
+    s := strings.Trim(string(b), `"`)
+    v, err := strconv.ParseUint(s, 10, 64)
+    if err != nil { return fmt.Errorf("invalid quoted integer %s", b) }
+    *q = Quoted(v)
+    return nil
+}
+
+// Import merges an EIP-3076 interchange document into the DB. The document
+// must use format version 5 and, if the DB is bound to a chain, the same
+// genesis validators root. Records already present are skipped; the import
+// is persisted as a whole before it takes effect.
+func (db *DB) Import(r io.Reader) error {
+    var doc Interchange
+    dec := json.NewDecoder(r)
+    if err := dec.Decode(&doc); err != nil { return fmt.Errorf("interchange: %w", err) }
+    if doc.Metadata.InterchangeFormatVersion != InterchangeVersion {
+        return fmt.Errorf("interchange: unsupported format version %q", doc.Metadata.InterchangeFormatVersion)
+    }
+    g := normHex(doc.Metadata.GenesisValidatorsRoot)
+    if g == "" { return fmt.Errorf("interchange: missing genesis_validators_root") }
+
+    db.mu.Lock()
+    defer db.mu.Unlock()
+    if db.genesis != "" && db.genesis != g { return ErrGenesisMismatch }
+    var es []entry
+    if db.genesis == "" { es = append(es, entry{Kind: kindGenesis, Root: g}) }
+    seenB := map[string]bool{}
+    seenA := map[string]bool{}
+    for pk, h := range db.vals {
+        for _, b := range h.blocks { seenB[blockKey(pk, b.Slot, b.SigningRoot)] = true }
+        for _, a := range h.attestations { seenA[attKey(pk, a.Source, a.Target, a.SigningRoot)] = true }
+    }
+    var blocks, atts int
+    for _, rec := range doc.Data {
+        pk := normHex(rec.Pubkey)
+        if len(pk) < 3 { return fmt.Errorf("interchange: missing pubkey") }
+        for _, b := range rec.SignedBlocks {
+            e := entry{Kind: kindBlock, Pubkey: pk, Slot: uint64(b.Slot), Root: normHex(b.SigningRoot)}
+            if k := blockKey(pk, e.Slot, e.Root); !seenB[k] { seenB[k] = true; es = append(es, e); blocks++ }
+        }
+        for _, a := range rec.SignedAttestations {
+            e := entry{Kind: kindAttestation, Pubkey: pk, Source: uint64(a.SourceEpoch), Target: uint64(a.TargetEpoch), Root: normHex(a.SigningRoot)}
+            if e.Source > e.Target { return fmt.Errorf("interchange: %s: source epoch %d after target %d", pk, e.Source, e.Target) }
+            if k := attKey(pk, e.Source, e.Target, e.Root); !seenA[k] { seenA[k] = true; es = append(es, e); atts++ }
+        }
+    }
+    if err := db.append(es...); err != nil { return err }
+    for _, e := range es { db.apply(e) }
+    logger.InfoJ("slashing_protection", map[string]any{"op":"import", "result":"ok", "validators": len(doc.Data), "blocks": blocks, "attestations": atts, "trace_id": ""})
+    return nil
+}
+
+// Export writes the complete signing history as an EIP-3076 document, with
+// validators sorted by pubkey and records by slot or epoch. A DB without a
+// genesis validators root cannot be exported (ErrGenesisUnknown).
+func (db *DB) Export(w io.Writer) error {
+    db.mu.Lock()
+    if db.genesis == "" {
+        db.mu.Unlock()
+        return ErrGenesisUnknown
+    }
+    doc := Interchange{Metadata: InterchangeMetadata{InterchangeFormatVersion: InterchangeVersion, GenesisValidatorsRoot: db.genesis}, Data: []InterchangeRecord{}}
+    pks := make([]string, 0, len(db.vals))
+    for pk := range db.vals { pks = append(pks, pk) }
+    sort.Strings(pks)
+    for _, pk := range pks {
+        h := db.vals[pk]
+        rec := InterchangeRecord{Pubkey: pk, SignedBlocks: []InterchangeBlock{}, SignedAttestations: []InterchangeAttestation{}}
+        for _, b := range h.blocks { rec.SignedBlocks = append(rec.SignedBlocks, InterchangeBlock{Slot: Quoted(b.Slot), SigningRoot: b.SigningRoot}) }
+        for _, a := range h.attestations {
+            rec.SignedAttestations = append(rec.SignedAttestations, InterchangeAttestation{SourceEpoch: Quoted(a.Source), TargetEpoch: Quoted(a.Target), SigningRoot: a.SigningRoot})
+        }
+        sort.SliceStable(rec.SignedBlocks, func(i, j int) bool { return rec.SignedBlocks[i].Slot < rec.SignedBlocks[j].Slot })
+        sort.SliceStable(rec.SignedAttestations, func(i, j int) bool { return rec.SignedAttestations[i].TargetEpoch < rec.SignedAttestations[j].TargetEpoch })
+        doc.Data = append(doc.Data, rec)
+    }
+    db.mu.Unlock()
+    enc := json.NewEncoder(w)
+    enc.SetIndent("", "  ")
+    return enc.Encode(doc)
+}
+
+func blockKey(pk string, slot uint64, root string) string { return fmt.Sprintf("%s|%d|%s", pk, slot, root) }
+
+func attKey(pk string, source, target uint64, root string) string {
+    return fmt.Sprintf("%s|%d|%d|%s", pk, source, target, root)
+}
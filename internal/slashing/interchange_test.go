@@ -0,0 +1,69 @@
+package slashing
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "strings"
+    "testing"
+)
+
+// Example document from EIP-3076.
+const eipExample = `{
+  "metadata": {
+    "interchange_format_version": "5",
+    "genesis_validators_root": "0x04700007fabc8282644aed6d1c7c9e21d38a03a0c4ba193f3afe428824b3a673"
+  },
+  "data": [
+    {
+      "pubkey": "0xb845089a1457f811bfc000588fbb4e713669be8ce060ea6be3c6ece09afc3794106c91ca73acda5e5457122d58723bed",
+      "signed_blocks": [
+        {"slot": "81952", "signing_root": "0x4ff6f743a43f3b4f95350831aeaf0a122a1a392922c45d804280284a69eb850b"},
+        {"slot": "81951"}
+      ],
+      "signed_attestations": [
+        {"source_epoch": "2290", "target_epoch": "3007", "signing_root": "0x587d6a4f59a58fe24f406e0502413e77fe1babddee641fda30034ed37ecc884d"},
+        {"source_epoch": "2290", "target_epoch": "3008"}
+      ]
+    }
+  ]
+}`
+
+func TestInterchange_ImportEnforcesHistory(t *testing.T) {
+    db := NewMemoryDB("")
+    if err := db.Import(strings.NewReader(eipExample)); err != nil { t.Fatalf("import: %v", err) }
+    if g := db.GenesisValidatorsRoot(); !strings.HasPrefix(g, "0x0470") { t.Fatalf("genesis not adopted: %q", g) }
+    if err := db.CheckAndSignBlock(pk, 81951, "0x01"); !errors.Is(err, ErrDoubleProposal) { t.Fatalf("imported block ignored: %v", err) }
+    if err := db.CheckAndSignBlock(pk, 81000, "0x01"); !errors.Is(err, ErrBlockSlotTooLow) { t.Fatalf("lower bound ignored: %v", err) }
+    if err := db.CheckAndSignAttestation(pk, 2290, 3008, "0x01"); !errors.Is(err, ErrDoubleVote) { t.Fatalf("imported attestation ignored: %v", err) }
+    if err := db.CheckAndSignAttestation(pk, 2289, 3009, "0x01"); !errors.Is(err, ErrSurroundVote) { t.Fatalf("surround ignored: %v", err) }
+    if err := db.CheckAndSignAttestation(pk, 3008, 3009, "0x01"); err != nil { t.Fatalf("safe attestation: %v", err) }
+
+    // Importing the same document again adds nothing.
+    if err := db.Import(strings.NewReader(eipExample)); err != nil { t.Fatalf("re-import: %v", err) }
+    var buf bytes.Buffer
+    if err := db.Export(&buf); err != nil { t.Fatalf("export: %v", err) }
+    var doc Interchange
+    if err := json.Unmarshal(buf.Bytes(), &doc); err != nil { t.Fatalf("decode export: %v", err) }
+    if len(doc.Data) != 1 || len(doc.Data[0].SignedBlocks) != 2 || len(doc.Data[0].SignedAttestations) != 3 { t.Fatalf("unexpected export: %s", buf.String()) }
+    if doc.Data[0].SignedBlocks[0].Slot != 81951 || !strings.Contains(buf.String(), `"slot": "81951"`) { t.Fatalf("blocks not sorted or not quoted: %s", buf.String()) }
+
+    // The export imports into a fresh DB with the same effect.
+    other := NewMemoryDB("")
+    if err := other.Import(&buf); err != nil { t.Fatalf("import export: %v", err) }
+    if err := other.CheckAndSignAttestation(pk, 3008, 3009, "0x02"); !errors.Is(err, ErrDoubleVote) { t.Fatalf("round trip lost data: %v", err) }
+}
+
+func TestInterchange_ImportRejects(t *testing.T) {
+    if err := NewMemoryDB("").Import(strings.NewReader(strings.Replace(eipExample, `"5"`, `"4"`, 1))); err == nil { t.Fatalf("want version error") }
+    if err := NewMemoryDB("0x01").Import(strings.NewReader(eipExample)); !errors.Is(err, ErrGenesisMismatch) { t.Fatalf("want genesis mismatch, got %v", err) }
+    if err := NewMemoryDB("").Import(strings.NewReader(strings.Replace(eipExample, `"81952"`, `"x"`, 1))); err == nil { t.Fatalf("want parse error") }
+}
+
+// A DB not bound to a chain exports nothing rather than an invalid document.
+func TestInterchange_ExportRequiresGenesis(t *testing.T) {
+    db := NewMemoryDB("")
+    if err := db.CheckAndSignBlock(pk, 1, "0x01"); err != nil { t.Fatalf("sign: %v", err) }
+    var buf bytes.Buffer
+    if err := db.Export(&buf); !errors.Is(err, ErrGenesisUnknown) || buf.Len() != 0 { t.Fatalf("want ErrGenesisUnknown and no output, got %v %q", err, buf.String()) }
+}
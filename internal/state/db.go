@@ -6,11 +6,51 @@ import (
     "sync"
 )
 
-// LastState represents the latest consensus coordinates persisted by the node.
-// It is intentionally small and generic for M3 minimal persistence.
+// LastState represents the latest consensus coordinates persisted by the node,
+// plus (since record version 2) the in-flight instance at those coordinates.
 type LastState struct {
     Height uint64
     Round  uint64
+    // Instance is nil for coordinate-only states (e.g. migrated v1 records).
+    Instance *InstanceState
+}
+
+// InstanceState is the durable part of an in-flight QBFT instance: enough for
+// a restarted node to never vote inconsistently with what it already sent.
+type InstanceState struct {
+    Duty          string
+    Phase         string
+    ProposalID    string
+    Proposal      []byte
+    PreparedRound uint64
+    PreparedID    string
+    PreparedValue []byte
+    // OwnVotes are this node's sent messages, canonically encoded by the caller.
+    OwnVotes [][]byte
+    // PreparedCert holds the encoded prepares certifying PreparedID.
+    PreparedCert [][]byte
+    // Votes holds the encoded votes the instance had counted.
+    Votes [][]byte
+}
+
+// clone deep-copies s so stores never share buffers with callers.
+func (s LastState) clone() LastState {
+    if s.Instance == nil { return s }
+    in := *s.Instance
+    in.Proposal = append([]byte(nil), in.Proposal...)
+    in.PreparedValue = append([]byte(nil), in.PreparedValue...)
+    in.OwnVotes = cloneFields(in.OwnVotes)
+    in.PreparedCert = cloneFields(in.PreparedCert)
+    in.Votes = cloneFields(in.Votes)
+    s.Instance = &in
+    return s
+}
+
+func cloneFields(vs [][]byte) [][]byte {
+    if vs == nil { return nil }
+    out := make([][]byte, len(vs))
+    for i, v := range vs { out[i] = append([]byte(nil), v...) }
+    return out
 }
 
 // ErrNotFound is returned when a requested state is not available.
@@ -27,9 +67,12 @@ type Store interface {
 // MemoryStore is a minimal in-memory implementation of Store.
 // It is intended as a stub for wiring and tests in M3 and is not durable.
 type MemoryStore struct {
-    mu   sync.RWMutex
-    have bool
-    last LastState
+    mu         sync.RWMutex
+    have       bool
+    last       LastState
+    haveReplay bool
+    replay     ReplayState
+    instances  map[instanceKey]LastState
 }
 
 // NewMemoryStore constructs a new empty MemoryStore.
@@ -38,7 +81,7 @@ func NewMemoryStore() *MemoryStore { return &MemoryStore{} }
 // SaveLastState stores the provided state atomically.
 func (m *MemoryStore) SaveLastState(_ context.Context, s LastState) error {
     m.mu.Lock()
-    m.last = s
+    m.last = s.clone()
     m.have = true
     m.mu.Unlock()
     return nil
@@ -50,7 +93,7 @@ func (m *MemoryStore) LoadLastState(_ context.Context) (LastState, error) {
     have, s := m.have, m.last
     m.mu.RUnlock()
     if !have { return LastState{}, ErrNotFound }
-    return s, nil
+    return s.clone(), nil
 }
 
 // Close implements Store. For MemoryStore it is a no-op.
@@ -0,0 +1,126 @@
+package state
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "os"
+    "sync"
+
+    "github.com/zmlAEQ/Aequa-network/pkg/logger"
+    "github.com/zmlAEQ/Aequa-network/pkg/metrics"
+)
+
+// Evidence is a persisted misbehaviour record. Body holds the full proof
+// (e.g. both conflicting consensus messages) as JSON; the remaining fields
+// index it.
+type Evidence struct {
+    ID       string          `json:"id"`
+    Kind     string          `json:"kind"`
+    Offender string          `json:"offender"`
+    Duty     string          `json:"duty,omitempty"`
+    Height   uint64          `json:"height"`
+    Round    uint64          `json:"round"`
+    Body     json.RawMessage `json:"body"`
+}
+
+// EvidenceStore persists evidence records. Saving a record whose ID is
+// already stored is a no-op. Implementations should be concurrency-safe.
+type EvidenceStore interface {
+    SaveEvidence(ctx context.Context, e Evidence) error
+    ListEvidence(ctx context.Context) ([]Evidence, error)
+}
+
+// MemoryEvidenceStore keeps evidence in memory (tests and default wiring).
+type MemoryEvidenceStore struct {
+    mu    sync.RWMutex
+    ids   map[string]struct{}
+    items []Evidence
+}
+
+// NewMemoryEvidenceStore constructs an empty MemoryEvidenceStore.
+func NewMemoryEvidenceStore() *MemoryEvidenceStore {
+    return &MemoryEvidenceStore{ids: map[string]struct{}{}}
+}
+
+// SaveEvidence appends e unless its ID is already known.
+func (m *MemoryEvidenceStore) SaveEvidence(_ context.Context, e Evidence) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    if _, ok := m.ids[e.ID]; ok { return nil }
+    m.ids[e.ID] = struct{}{}
+    m.items = append(m.items, e)
+    return nil
+}
+
+// ListEvidence returns the stored records in insertion order.
+func (m *MemoryEvidenceStore) ListEvidence(_ context.Context) ([]Evidence, error) {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+    return append(make([]Evidence, 0, len(m.items)), m.items...), nil
+}
+
+// FileEvidenceStore appends evidence as JSON lines to a single file, fsyncing
+// every record. Evidence is rare, so simplicity beats throughput here.
+type FileEvidenceStore struct {
+    mem  *MemoryEvidenceStore
+    mu   sync.Mutex
+    path string
+}
+
+// NewFileEvidenceStore opens (or creates) the evidence log at path and loads
+// existing records. A torn trailing line from a crash is truncated away.
+func NewFileEvidenceStore(path string) (*FileEvidenceStore, error) {
+    fs := &FileEvidenceStore{mem: NewMemoryEvidenceStore(), path: path}
+    b, err := os.ReadFile(path)
+    if os.IsNotExist(err) { return fs, nil }
+    if err != nil { return nil, err }
+    if i := bytes.LastIndexByte(b, '\n'); i+1 < len(b) {
+        if err := os.Truncate(path, int64(i+1)); err != nil { return nil, err }
+        b = b[:i+1]
+    }
+    for _, line := range bytes.Split(b, []byte{'\n'}) {
+        if len(line) == 0 { continue }
+        var e Evidence
+        if err := json.Unmarshal(line, &e); err != nil {
+            logger.ErrorJ("consensus_state", map[string]any{"op":"evidence_load", "result":"skip", "err": err.Error(), "trace_id": ""})
+            continue
+        }
+        _ = fs.mem.SaveEvidence(context.Background(), e)
+    }
+    return fs, nil
+}
+
+// SaveEvidence durably appends e unless its ID is already stored.
+func (fs *FileEvidenceStore) SaveEvidence(ctx context.Context, e Evidence) error {
+    fs.mu.Lock()
+    defer fs.mu.Unlock()
+    if fs.mem.has(e.ID) { return nil }
+    line, err := json.Marshal(e)
+    if err != nil { return err }
+    f, err := os.OpenFile(fs.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+    if err != nil { return fs.fail(err) }
+    if _, err = f.Write(append(line, '\n')); err != nil { _ = f.Close(); return fs.fail(err) }
+    if err = f.Sync(); err != nil { _ = f.Close(); return fs.fail(err) }
+    if err = f.Close(); err != nil { return fs.fail(err) }
+    logger.InfoJ("consensus_state", map[string]any{"op":"evidence_save", "result":"ok", "id": e.ID, "kind": e.Kind, "offender": e.Offender, "trace_id": ""})
+    return fs.mem.SaveEvidence(ctx, e)
+}
+
+// ListEvidence returns all records in the order they were persisted.
+func (fs *FileEvidenceStore) ListEvidence(ctx context.Context) ([]Evidence, error) {
+    return fs.mem.ListEvidence(ctx)
+}
+
+func (fs *FileEvidenceStore) fail(err error) error {
+    metrics.Inc("state_persist_errors_total", nil)
+    logger.ErrorJ("consensus_state", map[string]any{"op":"evidence_save", "result":"error", "err": err.Error(), "trace_id": ""})
+    return err
+}
+
+func (m *MemoryEvidenceStore) has(id string) bool {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+    _, ok := m.ids[id]
+    return ok
+}
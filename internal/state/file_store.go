@@ -4,6 +4,7 @@ import (
     "context"
     "encoding/binary"
     "errors"
+    "fmt"
     "hash/crc32"
     "io"
     "os"
@@ -21,6 +22,17 @@ import (
 type FileStore struct {
     mu   sync.Mutex
     path string // main path, e.g. laststate.dat
+    // instances caches the persisted instance snapshots once read.
+    instances map[instanceKey]LastState
+    // instLog is the instances log open for appends; nil until the first
+    // write, or while the file on disk needs compacting first.
+    instLog     *os.File
+    instAppend  bool // the file on disk is a current log that can be appended to
+    instEntries int  // entries in the log on disk
+    // replayLog is the replay log open for appends; replayTorn is set while
+    // it must be compacted by SaveReplay before the next append.
+    replayLog  *os.File
+    replayTorn bool
 }
 
 // NewFileStore constructs a file-backed store at the provided path.
@@ -28,36 +40,146 @@ func NewFileStore(path string) *FileStore { return &FileStore{path: path} }
 
 const (
     magic   uint32 = 0x53544442 // 'STDB' (State-DB)
-    version uint16 = 1
+    version uint16 = 2
 )
 
 // on-disk layout:
 // [magic u32][version u16][reserved u16][length u32][crc32 u32][payload bytes...]
-// payload = Height u64 | Round u64 (big endian)
+// v1 payload = Height u64 | Round u64 (big endian)
+// v2 payload = v1 payload | hasInstance u8 | instance, where instance =
+//   Duty str | Phase str | ProposalID str | Proposal bytes | PreparedRound u64 |
+//   PreparedID str | PreparedValue bytes | OwnVotes list | PreparedCert list |
+//   Votes list
+// with str/bytes u32-length-prefixed and a list a u32 count of bytes.
+//
+// v1 records still load, as coordinates only; LoadLastState rewrites them as
+// v2 and the v1 record stays readable as .bak.
 
 func writeFileAtomic(path string, s LastState) error {
-    dir := filepath.Dir(path)
-    tmp := path + ".tmp"
+    return writeRecordAtomic(path, magic, version, encodeLastState(s))
+}
 
-    f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
-    if err != nil { return err }
-    // Build payload
-    var payload [16]byte
-    binary.BigEndian.PutUint64(payload[0:8], s.Height)
-    binary.BigEndian.PutUint64(payload[8:16], s.Round)
+func encodeLastState(s LastState) []byte {
+    b := make([]byte, 0, 64)
+    b = binary.BigEndian.AppendUint64(b, s.Height)
+    b = binary.BigEndian.AppendUint64(b, s.Round)
+    in := s.Instance
+    if in == nil { return append(b, 0) }
+    b = append(b, 1)
+    field := func(v []byte) { b = binary.BigEndian.AppendUint32(b, uint32(len(v))); b = append(b, v...) }
+    field([]byte(in.Duty))
+    field([]byte(in.Phase))
+    field([]byte(in.ProposalID))
+    field(in.Proposal)
+    b = binary.BigEndian.AppendUint64(b, in.PreparedRound)
+    field([]byte(in.PreparedID))
+    field(in.PreparedValue)
+    b = binary.BigEndian.AppendUint32(b, uint32(len(in.OwnVotes)))
+    for _, v := range in.OwnVotes { field(v) }
+    b = binary.BigEndian.AppendUint32(b, uint32(len(in.PreparedCert)))
+    for _, v := range in.PreparedCert { field(v) }
+    b = binary.BigEndian.AppendUint32(b, uint32(len(in.Votes)))
+    for _, v := range in.Votes { field(v) }
+    return b
+}
+
+func decodeLastState(ver uint16, p []byte) (LastState, error) {
+    if len(p) < 16 { return LastState{}, errors.New("bad length") }
+    s := LastState{Height: binary.BigEndian.Uint64(p[0:8]), Round: binary.BigEndian.Uint64(p[8:16])}
+    if ver == 1 {
+        if len(p) != 16 { return LastState{}, errors.New("bad length") }
+        return s, nil
+    }
+    if ver != version || len(p) < 17 { return LastState{}, errors.New("unsupported record") }
+    if p[16] == 0 {
+        if len(p) != 17 { return LastState{}, errors.New("bad length") }
+        return s, nil
+    }
+    r := &recordReader{b: p[17:]}
+    in := &InstanceState{}
+    in.Duty = string(r.field())
+    in.Phase = string(r.field())
+    in.ProposalID = string(r.field())
+    in.Proposal = r.field()
+    in.PreparedRound = r.u64()
+    in.PreparedID = string(r.field())
+    in.PreparedValue = r.field()
+    in.OwnVotes = r.fields()
+    in.PreparedCert = r.fields()
+    in.Votes = r.fields()
+    if r.err == nil && len(r.b) != 0 { r.err = errors.New("trailing bytes") }
+    if r.err != nil { return LastState{}, r.err }
+    s.Instance = in
+    return s, nil
+}
+
+// recordReader decodes length-prefixed fields, latching the first error.
+type recordReader struct {
+    b   []byte
+    err error
+}
+
+func (r *recordReader) take(n int) []byte {
+    if r.err != nil { return nil }
+    if n < 0 || len(r.b) < n { r.err = errors.New("truncated record"); return nil }
+    v := r.b[:n]
+    r.b = r.b[n:]
+    return v
+}
+
+func (r *recordReader) u32() uint32 {
+    if v := r.take(4); v != nil { return binary.BigEndian.Uint32(v) }
+    return 0
+}
+
+func (r *recordReader) u64() uint64 {
+    if v := r.take(8); v != nil { return binary.BigEndian.Uint64(v) }
+    return 0
+}
+
+// fields reads a u32 count followed by that many fields.
+func (r *recordReader) fields() [][]byte {
+    n := r.u32()
+    if r.err == nil && uint64(n)*4 > uint64(len(r.b)) { r.err = errors.New("bad field count") }
+    var out [][]byte
+    for i := uint32(0); i < n && r.err == nil; i++ { out = append(out, r.field()) }
+    return out
+}
+
+func (r *recordReader) field() []byte {
+    n := r.u32()
+    if r.err != nil { return nil }
+    if n > uint32(len(r.b)) { r.err = errors.New("truncated record"); return nil }
+    return append([]byte(nil), r.take(int(n))...)
+}
+
+// writeRecordAtomic writes one framed record (see layout above) using
+// replaceFileAtomic.
+func writeRecordAtomic(path string, mg uint32, ver uint16, payload []byte) error {
     // Header (with length + crc)
     length := uint32(len(payload))
-    crc := crc32.ChecksumIEEE(payload[:])
+    crc := crc32.ChecksumIEEE(payload)
     var hdr [4 + 2 + 2 + 4 + 4]byte
     off := 0
-    binary.BigEndian.PutUint32(hdr[off:], magic); off += 4
-    binary.BigEndian.PutUint16(hdr[off:], version); off += 2
+    binary.BigEndian.PutUint32(hdr[off:], mg); off += 4
+    binary.BigEndian.PutUint16(hdr[off:], ver); off += 2
     binary.BigEndian.PutUint16(hdr[off:], 0); off += 2 // reserved
     binary.BigEndian.PutUint32(hdr[off:], length); off += 4
     binary.BigEndian.PutUint32(hdr[off:], crc)
+    return replaceFileAtomic(path, hdr[:], payload)
+}
 
-    if _, err = f.Write(hdr[:]); err != nil { _ = f.Close(); return err }
-    if _, err = f.Write(payload[:]); err != nil { _ = f.Close(); return err }
+// replaceFileAtomic replaces path with the concatenated parts using
+// tmp write + fsync + rename, keeping the previous file as .bak.
+func replaceFileAtomic(path string, parts ...[]byte) error {
+    dir := filepath.Dir(path)
+    tmp := path + ".tmp"
+
+    f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+    if err != nil { return err }
+    for _, p := range parts {
+        if _, err = f.Write(p); err != nil { _ = f.Close(); return err }
+    }
     if err = f.Sync(); err != nil { _ = f.Close(); return err }
     if err = f.Close(); err != nil { return err }
 
@@ -77,30 +199,35 @@ func writeFileAtomic(path string, s LastState) error {
     return nil
 }
 
-func readFile(path string) (LastState, error) {
+// maxRecord bounds the payload a framed record may declare.
+const maxRecord = 64 << 20
+
+// readRecord reads one framed record and returns its version and payload.
+func readRecord(path string, mg uint32) (uint16, []byte, error) {
     f, err := os.Open(path)
-    if err != nil { return LastState{}, err }
+    if err != nil { return 0, nil, err }
     defer f.Close()
     var hdr [4 + 2 + 2 + 4 + 4]byte
-    if _, err = io.ReadFull(f, hdr[:]); err != nil { return LastState{}, err }
+    if _, err = io.ReadFull(f, hdr[:]); err != nil { return 0, nil, err }
     off := 0
-    mg := binary.BigEndian.Uint32(hdr[off:]); off += 4
-    if mg != magic { return LastState{}, errors.New("bad magic") }
+    if binary.BigEndian.Uint32(hdr[off:]) != mg { return 0, nil, errors.New("bad magic") }
+    off += 4
     ver := binary.BigEndian.Uint16(hdr[off:]); off += 2
-    _ = ver // reserved for future use
     off += 2 // reserved
     length := binary.BigEndian.Uint32(hdr[off:]); off += 4
     wantCRC := binary.BigEndian.Uint32(hdr[off:])
-    if length != 16 { return LastState{}, errors.New("bad length") }
-    var payload [16]byte
-    if _, err = io.ReadFull(f, payload[:]); err != nil { return LastState{}, err }
-    got := crc32.ChecksumIEEE(payload[:])
-    if got != wantCRC { return LastState{}, errors.New("crc mismatch") }
-    s := LastState{
-        Height: binary.BigEndian.Uint64(payload[0:8]),
-        Round:  binary.BigEndian.Uint64(payload[8:16]),
-    }
-    return s, nil
+    if length > maxRecord { return 0, nil, errors.New("bad length") }
+    payload := make([]byte, length)
+    if _, err = io.ReadFull(f, payload); err != nil { return 0, nil, err }
+    if crc32.ChecksumIEEE(payload) != wantCRC { return 0, nil, errors.New("crc mismatch") }
+    return ver, payload, nil
+}
+
+func readFile(path string) (LastState, uint16, error) {
+    ver, payload, err := readRecord(path, magic)
+    if err != nil { return LastState{}, 0, err }
+    s, err := decodeLastState(ver, payload)
+    return s, ver, err
 }
 
 // SaveLastState persists the last state using atomic file replace.
@@ -126,15 +253,17 @@ func (fs *FileStore) LoadLastState(_ context.Context) (LastState, error) {
     fs.mu.Lock()
     defer fs.mu.Unlock()
     // Try main
-    if s, err := readFile(fs.path); err == nil {
+    if s, ver, err := readFile(fs.path); err == nil {
         metrics.Inc("state_recovery_total", map[string]string{"result": "ok"})
         logger.InfoJ("consensus_state", map[string]any{"op":"recovery", "result":"ok", "height": s.Height, "round": s.Round, "trace_id": ""})
+        fs.migrate(s, ver)
         return s, nil
     }
     // Try backup
-    if s, err := readFile(fs.path + ".bak"); err == nil {
+    if s, ver, err := readFile(fs.path + ".bak"); err == nil {
         metrics.Inc("state_recovery_total", map[string]string{"result": "fallback"})
         logger.InfoJ("consensus_state", map[string]any{"op":"recovery", "result":"fallback", "height": s.Height, "round": s.Round, "trace_id": ""})
+        fs.migrate(s, ver)
         return s, nil
     }
     metrics.Inc("state_recovery_total", map[string]string{"result": "fail"})
@@ -142,5 +271,26 @@ func (fs *FileStore) LoadLastState(_ context.Context) (LastState, error) {
     return LastState{}, ErrNotFound
 }
 
-// Close implements Store. For FileStore it is a no-op.
-func (fs *FileStore) Close() error { return nil }
+// migrate rewrites a v1 record in the current format
+// (best effort; the old record stays readable as .bak).
+func (fs *FileStore) migrate(s LastState, from uint16) {
+    if from == version { return }
+    err := writeFileAtomic(fs.path, s)
+    result := "ok"
+    if err != nil { result = "error" }
+    metrics.Inc("state_migrations_total", map[string]string{"from": fmt.Sprintf("%d", from), "result": result})
+    logger.InfoJ("consensus_state", map[string]any{"op":"migrate", "result": result, "from": from, "to": version, "trace_id": ""})
+}
+
+// Close implements Store. It closes the instances and replay logs, if open.
+func (fs *FileStore) Close() error {
+    fs.mu.Lock()
+    defer fs.mu.Unlock()
+    var err error
+    if fs.instLog != nil { err = fs.instLog.Close() }
+    if fs.replayLog != nil {
+        if rerr := fs.replayLog.Close(); err == nil { err = rerr }
+    }
+    fs.instLog, fs.replayLog = nil, nil
+    return err
+}
@@ -2,8 +2,10 @@ package state
 
 import (
     "context"
+    "encoding/binary"
     "os"
     "path/filepath"
+    "reflect"
     "testing"
 )
 
@@ -49,3 +51,128 @@ func TestFileStore_Load_NotFound(t *testing.T) {
     }
 }
 
+
+func TestFileStore_Replay_SaveLoadAndFallback(t *testing.T) {
+    ctx := context.Background()
+    fs := NewFileStore(filepath.Join(t.TempDir(), "laststate.dat"))
+    if _, err := fs.LoadReplay(ctx); err != ErrNotFound { t.Fatalf("want ErrNotFound, got %v", err) }
+    v1 := ReplayState{High: 5, IDs: map[string]uint64{"a": 4, "b": 5}}
+    if err := fs.SaveReplay(ctx, v1); err != nil { t.Fatalf("save: %v", err) }
+    if err := fs.SaveReplay(ctx, ReplayState{High: 6, IDs: map[string]uint64{"c": 6}}); err != nil { t.Fatalf("save2: %v", err) }
+    got, err := fs.LoadReplay(ctx)
+    if err != nil || got.High != 6 || len(got.IDs) != 1 || got.IDs["c"] != 6 { t.Fatalf("load: %+v %v", got, err) }
+    // Corrupt the main copy: recovery falls back to the previous window.
+    if err := os.Truncate(fs.replayPath(), 20); err != nil { t.Fatalf("truncate: %v", err) }
+    got, err = fs.LoadReplay(ctx)
+    if err != nil || got.High != 5 || got.IDs["a"] != 4 || got.IDs["b"] != 5 { t.Fatalf("fallback: %+v %v", got, err) }
+}
+
+// Appended ids load on top of the saved window until the next save compacts
+// them into it; a torn tail loses only the torn entry.
+func TestFileStore_Replay_AppendCompactAndTornTail(t *testing.T) {
+    ctx := context.Background()
+    path := filepath.Join(t.TempDir(), "laststate.dat")
+    fs := NewFileStore(path)
+    defer fs.Close()
+    if err := fs.AppendReplay(ctx, "a", 3); err != nil { t.Fatalf("append: %v", err) }
+    got, err := NewFileStore(path).LoadReplay(ctx)
+    if err != nil || got.IDs["a"] != 3 { t.Fatalf("log only: %+v %v", got, err) }
+    if err := fs.SaveReplay(ctx, ReplayState{High: 4, IDs: map[string]uint64{"a": 3, "b": 4}}); err != nil { t.Fatalf("save: %v", err) }
+    if info, err := os.Stat(fs.replayLogPath()); err != nil || info.Size() != 8 { t.Fatalf("save must empty the log: %v %v", info, err) }
+    for _, id := range []string{"c", "d"} {
+        if err := fs.AppendReplay(ctx, id, 5); err != nil { t.Fatalf("append %s: %v", id, err) }
+    }
+    info, _ := os.Stat(fs.replayLogPath())
+    if err := os.Truncate(fs.replayLogPath(), info.Size()-1); err != nil { t.Fatalf("truncate: %v", err) }
+
+    re := NewFileStore(path)
+    defer re.Close()
+    got, err = re.LoadReplay(ctx)
+    want := map[string]uint64{"a": 3, "b": 4, "c": 5}
+    if err != nil || got.High != 4 || !reflect.DeepEqual(got.IDs, want) { t.Fatalf("load: %+v %v, want %v", got, err, want) }
+    if err := re.AppendReplay(ctx, "e", 6); err != nil { t.Fatalf("append after torn tail: %v", err) }
+    got, _ = NewFileStore(path).LoadReplay(ctx)
+    if got.IDs["c"] != 5 || got.IDs["e"] != 6 || len(got.IDs) != 4 { t.Fatalf("append after the valid prefix: %+v", got) }
+}
+
+func TestFileStore_InstanceRoundTrip(t *testing.T) {
+    fs := NewFileStore(filepath.Join(t.TempDir(), "laststate.dat"))
+    want := LastState{Height: 9, Round: 2, Instance: &InstanceState{
+        Duty: "attester", Phase: "prepared", ProposalID: "abc", Proposal: []byte(`{"v":1}`),
+        PreparedRound: 2, PreparedID: "abc", PreparedValue: []byte(`{"v":1}`),
+        OwnVotes: [][]byte{[]byte("prepare"), []byte("commit")},
+        PreparedCert: [][]byte{[]byte("p1"), []byte("p2")},
+        Votes: [][]byte{[]byte("v1"), []byte("v2"), []byte("v3")},
+    }}
+    if err := fs.SaveLastState(context.Background(), want); err != nil { t.Fatalf("save: %v", err) }
+    got, err := fs.LoadLastState(context.Background())
+    if err != nil { t.Fatalf("load: %v", err) }
+    if !reflect.DeepEqual(got, want) { t.Fatalf("mismatch:\n got=%+v\nwant=%+v", got.Instance, want.Instance) }
+}
+
+// A version-1 record loads unchanged and is rewritten in the current format.
+func TestFileStore_MigratesV1(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "laststate.dat")
+    var v1 [16]byte
+    binary.BigEndian.PutUint64(v1[0:8], 77)
+    binary.BigEndian.PutUint64(v1[8:16], 3)
+    if err := writeRecordAtomic(path, magic, 1, v1[:]); err != nil { t.Fatalf("write v1: %v", err) }
+    fs := NewFileStore(path)
+    got, err := fs.LoadLastState(context.Background())
+    if err != nil || got != (LastState{Height: 77, Round: 3}) { t.Fatalf("load v1: %+v %v", got, err) }
+    if ver, _, err := readRecord(path, magic); err != nil || ver != version { t.Fatalf("not migrated: ver=%d err=%v", ver, err) }
+    if again, err := fs.LoadLastState(context.Background()); err != nil || again != got { t.Fatalf("reload: %+v %v", again, err) }
+}
+
+// Concurrent instances persist side by side; deleting one keeps the others.
+func TestFileStore_Instances_PerKey(t *testing.T) {
+    ctx := context.Background()
+    path := filepath.Join(t.TempDir(), "laststate.dat")
+    fs := NewFileStore(path)
+    a := LastState{Height: 5, Round: 1, Instance: &InstanceState{Duty: "attester", Phase: "prepared", OwnVotes: [][]byte{[]byte("a")}}}
+    b := LastState{Height: 6, Instance: &InstanceState{Duty: "attester", Phase: "preprepared"}}
+    p := LastState{Height: 5, Instance: &InstanceState{Duty: "proposer", Phase: "roundchange"}}
+    for _, s := range []LastState{b, a, p} {
+        if err := fs.SaveInstance(ctx, s); err != nil { t.Fatalf("save: %v", err) }
+    }
+    a.Round = 2
+    if err := fs.SaveInstance(ctx, a); err != nil { t.Fatalf("update: %v", err) }
+    if err := fs.SaveInstance(ctx, LastState{Height: 1}); err == nil { t.Fatalf("want error for a state without instance") }
+    if err := fs.DeleteInstance(ctx, "attester", 6); err != nil { t.Fatalf("delete: %v", err) }
+    got, err := NewFileStore(path).LoadInstances(ctx)
+    if err != nil { t.Fatalf("load: %v", err) }
+    if !reflect.DeepEqual(got, []LastState{a, p}) { t.Fatalf("instances mismatch: %+v", got) }
+}
+
+
+// Saves append to the instances log instead of rewriting it; the log is
+// compacted once it outgrows the live snapshots, and a torn tail is dropped.
+func TestFileStore_Instances_AppendCompactAndTornTail(t *testing.T) {
+    ctx := context.Background()
+    path := filepath.Join(t.TempDir(), "laststate.dat")
+    fs := NewFileStore(path)
+    s := LastState{Height: 3, Instance: &InstanceState{Duty: "attester", Phase: "preprepared"}}
+    if err := fs.SaveInstance(ctx, s); err != nil { t.Fatalf("save: %v", err) }
+    before, _ := os.Stat(fs.instancesPath())
+    s.Instance.Votes = [][]byte{[]byte("prepare-b")}
+    if err := fs.SaveInstance(ctx, s); err != nil { t.Fatalf("save: %v", err) }
+    after, _ := os.Stat(fs.instancesPath())
+    if fs.instEntries != 2 || after.Size() <= before.Size() || !os.SameFile(before, after) { t.Fatalf("save did not append: %d entries", fs.instEntries) }
+
+    for i := 0; i < instancesCompactMin; i++ {
+        if err := fs.SaveInstance(ctx, s); err != nil { t.Fatalf("save %d: %v", i, err) }
+    }
+    if fs.instEntries >= instancesCompactMin { t.Fatalf("log not compacted: %d entries", fs.instEntries) }
+    if err := fs.Close(); err != nil { t.Fatalf("close: %v", err) }
+
+    // A crash mid-append leaves a partial entry behind.
+    f, err := os.OpenFile(fs.instancesPath(), os.O_WRONLY|os.O_APPEND, 0o600)
+    if err != nil { t.Fatal(err) }
+    _, _ = f.Write(frameEntry(deleteEntry(instanceKey{"attester", 3}))[:9])
+    _ = f.Close()
+    fs2 := NewFileStore(path)
+    got, err := fs2.LoadInstances(ctx)
+    if err != nil || !reflect.DeepEqual(got, []LastState{s}) { t.Fatalf("torn tail: %+v %v", got, err) }
+    if err := fs2.DeleteInstance(ctx, "attester", 3); err != nil { t.Fatalf("delete: %v", err) }
+    if got, err := NewFileStore(path).LoadInstances(ctx); err != nil || len(got) != 0 { t.Fatalf("after delete: %+v %v", got, err) }
+}
@@ -0,0 +1,279 @@
+package state
+
+import (
+    "context"
+    "encoding/binary"
+    "errors"
+    "hash/crc32"
+    "io"
+    "os"
+    "sort"
+    "time"
+
+    "github.com/zmlAEQ/Aequa-network/pkg/logger"
+    "github.com/zmlAEQ/Aequa-network/pkg/metrics"
+)
+
+// InstanceStore is implemented by stores that persist one snapshot per
+// in-flight QBFT instance, keyed by (Instance.Duty, Height), so concurrent
+// instances never overwrite each other. It is optional like ReplayStore.
+type InstanceStore interface {
+    // SaveInstance stores s, which must carry an Instance, replacing the
+    // snapshot of the same instance.
+    SaveInstance(ctx context.Context, s LastState) error
+    // DeleteInstance drops the snapshot of a decided or evicted instance.
+    DeleteInstance(ctx context.Context, duty string, height uint64) error
+    // LoadInstances returns the stored snapshots ordered by duty, then height.
+    LoadInstances(ctx context.Context) ([]LastState, error)
+}
+
+type instanceKey struct {
+    duty   string
+    height uint64
+}
+
+var errNoInstance = errors.New("state without instance")
+
+// sortedInstances returns the snapshots of m ordered by duty, then height.
+func sortedInstances(m map[instanceKey]LastState) []LastState {
+    out := make([]LastState, 0, len(m))
+    for _, s := range m { out = append(out, s.clone()) }
+    sort.Slice(out, func(i, j int) bool {
+        if out[i].Instance.Duty != out[j].Instance.Duty { return out[i].Instance.Duty < out[j].Instance.Duty }
+        return out[i].Height < out[j].Height
+    })
+    return out
+}
+
+// SaveInstance stores a copy of the instance snapshot.
+func (m *MemoryStore) SaveInstance(_ context.Context, s LastState) error {
+    if s.Instance == nil { return errNoInstance }
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    if m.instances == nil { m.instances = map[instanceKey]LastState{} }
+    m.instances[instanceKey{s.Instance.Duty, s.Height}] = s.clone()
+    return nil
+}
+
+// DeleteInstance drops the snapshot of (duty, height), if any.
+func (m *MemoryStore) DeleteInstance(_ context.Context, duty string, height uint64) error {
+    m.mu.Lock()
+    delete(m.instances, instanceKey{duty, height})
+    m.mu.Unlock()
+    return nil
+}
+
+// LoadInstances returns copies of the stored snapshots.
+func (m *MemoryStore) LoadInstances(_ context.Context) ([]LastState, error) {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+    return sortedInstances(m.instances), nil
+}
+
+const (
+    instancesMagic   uint32 = 0x5354494e // 'STIN' (State-INstances)
+    instancesVersion uint16 = 1
+    // instancesCompactMin is the log length below which it is never compacted.
+    instancesCompactMin = 64
+
+    instanceSave   byte = 1
+    instanceDelete byte = 2
+)
+
+// instances file layout is an append-only log:
+// [magic u32][version u16][reserved u16] then entries framed as
+// [length u32][crc32 u32][op u8 | body], the crc covering op and body. A save
+// body is a current-version last-state payload carrying its instance; a
+// delete body is Duty str | Height u64. Applying the entries in order yields
+// the live snapshots, so each save or delete costs one fsync'd append. A torn
+// tail left by a crash is dropped. Once the log holds more than twice as many
+// entries as live snapshots (and at least instancesCompactMin) it is
+// rewritten atomically with one save per snapshot, keeping the old log as
+// .bak.
+
+func instancesHeader() []byte {
+    b := binary.BigEndian.AppendUint32(nil, instancesMagic)
+    b = binary.BigEndian.AppendUint16(b, instancesVersion)
+    return binary.BigEndian.AppendUint16(b, 0) // reserved
+}
+
+// frameEntry frames one log entry (op plus body).
+func frameEntry(e []byte) []byte {
+    b := binary.BigEndian.AppendUint32(nil, uint32(len(e)))
+    b = binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(e))
+    return append(b, e...)
+}
+
+func saveEntry(s LastState) []byte { return append([]byte{instanceSave}, encodeLastState(s)...) }
+
+func deleteEntry(k instanceKey) []byte {
+    b := binary.BigEndian.AppendUint32([]byte{instanceDelete}, uint32(len(k.duty)))
+    b = append(b, k.duty...)
+    return binary.BigEndian.AppendUint64(b, k.height)
+}
+
+// applyEntry applies one log entry to m.
+func applyEntry(m map[instanceKey]LastState, e []byte) error {
+    if len(e) == 0 { return errors.New("empty entry") }
+    switch e[0] {
+    case instanceSave:
+        s, err := decodeLastState(version, e[1:])
+        if err != nil { return err }
+        if s.Instance == nil { return errNoInstance }
+        m[instanceKey{s.Instance.Duty, s.Height}] = s
+    case instanceDelete:
+        r := &recordReader{b: e[1:]}
+        duty := string(r.field())
+        h := r.u64()
+        if r.err == nil && len(r.b) != 0 { r.err = errors.New("trailing bytes") }
+        if r.err != nil { return r.err }
+        delete(m, instanceKey{duty, h})
+    default:
+        return errors.New("unknown entry")
+    }
+    return nil
+}
+
+// readInstances loads an instances file. It returns the live snapshots, the
+// number of log entries, and whether the file can be appended to as is
+// (false for a torn log, which the next write compacts).
+func readInstances(path string) (map[instanceKey]LastState, int, bool, error) {
+    f, err := os.Open(path)
+    if err != nil { return nil, 0, false, err }
+    defer f.Close()
+    var hdr [8]byte
+    if _, err := io.ReadFull(f, hdr[:]); err != nil { return nil, 0, false, err }
+    if binary.BigEndian.Uint32(hdr[0:]) != instancesMagic { return nil, 0, false, errors.New("bad magic") }
+    if binary.BigEndian.Uint16(hdr[4:]) != instancesVersion { return nil, 0, false, errors.New("unsupported version") }
+    m := map[instanceKey]LastState{}
+    n := 0
+    var frame [8]byte
+    for {
+        if _, err := io.ReadFull(f, frame[:]); err == io.EOF {
+            return m, n, true, nil
+        } else if err != nil {
+            break
+        }
+        length := binary.BigEndian.Uint32(frame[0:])
+        if length > maxRecord { break }
+        e := make([]byte, length)
+        if _, err := io.ReadFull(f, e); err != nil { break }
+        if crc32.ChecksumIEEE(e) != binary.BigEndian.Uint32(frame[4:]) { break }
+        if err := applyEntry(m, e); err != nil { return nil, 0, false, err }
+        n++
+    }
+    // Torn tail: keep the valid prefix.
+    metrics.Inc("state_instances_corrupt_total", map[string]string{"reason": "torn_tail"})
+    logger.InfoJ("consensus_state", map[string]any{"op":"instances_recovery", "result":"truncate", "entries": n, "trace_id": ""})
+    return m, n, false, nil
+}
+
+func (fs *FileStore) instancesPath() string { return fs.path + ".instances" }
+
+// loadInstances fills the cache from disk on first use, falling back to the
+// backup copy; a store without instances starts empty.
+func (fs *FileStore) loadInstances() map[instanceKey]LastState {
+    if fs.instances != nil { return fs.instances }
+    fs.instances = map[instanceKey]LastState{}
+    for _, c := range []struct{ path, result string }{{fs.instancesPath(), "ok"}, {fs.instancesPath() + ".bak", "fallback"}} {
+        if m, n, ok, err := readInstances(c.path); err == nil {
+            fs.instances, fs.instEntries = m, n
+            // Appending to a backup would leave the newer main file behind.
+            fs.instAppend = ok && c.result == "ok"
+            metrics.Inc("state_recovery_total", map[string]string{"result": c.result})
+            logger.InfoJ("consensus_state", map[string]any{"op":"instances_recovery", "result": c.result, "instances": len(m), "trace_id": ""})
+            return fs.instances
+        }
+    }
+    logger.InfoJ("consensus_state", map[string]any{"op":"instances_recovery", "result":"miss", "trace_id": ""})
+    return fs.instances
+}
+
+// writeInstance appends entry e to the instances log, or compacts the log
+// when it has grown too long or cannot be appended to. The cache already
+// reflects e.
+func (fs *FileStore) writeInstance(op string, e []byte) error {
+    start := time.Now()
+    var err error
+    if fs.instLog == nil && fs.instAppend {
+        fs.instLog, err = os.OpenFile(fs.instancesPath(), os.O_WRONLY|os.O_APPEND, 0o600)
+        if err != nil { fs.instLog, fs.instAppend = nil, false }
+    }
+    if fs.instLog == nil || (fs.instEntries >= instancesCompactMin && fs.instEntries > 2*len(fs.instances)) {
+        err = fs.compactInstances()
+    } else {
+        err = fs.appendInstance(e)
+    }
+    ms := float64(time.Since(start).Milliseconds())
+    if err != nil {
+        metrics.Inc("state_persist_errors_total", nil)
+        logger.ErrorJ("consensus_state", map[string]any{"op": op, "result":"error", "err": err.Error(), "trace_id": ""})
+        return err
+    }
+    metrics.ObserveSummary("state_persist_ms", nil, ms)
+    logger.InfoJ("consensus_state", map[string]any{"op": op, "result":"ok", "instances": len(fs.instances), "latency_ms": ms, "trace_id": ""})
+    return nil
+}
+
+func (fs *FileStore) appendInstance(e []byte) error {
+    _, err := fs.instLog.Write(frameEntry(e))
+    if err == nil { err = fs.instLog.Sync() }
+    if err != nil {
+        // A partial entry may be on disk: compact on the next write.
+        _ = fs.instLog.Close()
+        fs.instLog, fs.instAppend = nil, false
+        return err
+    }
+    fs.instEntries++
+    return nil
+}
+
+// compactInstances atomically replaces the log with one save per cached
+// snapshot and reopens it for appends.
+func (fs *FileStore) compactInstances() error {
+    if fs.instLog != nil { _ = fs.instLog.Close() }
+    fs.instLog, fs.instAppend = nil, false
+    parts := [][]byte{instancesHeader()}
+    for _, s := range sortedInstances(fs.instances) { parts = append(parts, frameEntry(saveEntry(s))) }
+    if err := replaceFileAtomic(fs.instancesPath(), parts...); err != nil { return err }
+    fs.instEntries = len(parts) - 1
+    fs.instAppend = true
+    metrics.Inc("state_instances_compactions_total", nil)
+    f, err := os.OpenFile(fs.instancesPath(), os.O_WRONLY|os.O_APPEND, 0o600)
+    if err != nil { return nil } // reopened on the next write
+    fs.instLog = f
+    return nil
+}
+
+// SaveInstance persists the instance snapshot next to the last state.
+func (fs *FileStore) SaveInstance(_ context.Context, s LastState) error {
+    if s.Instance == nil { return errNoInstance }
+    fs.mu.Lock()
+    defer fs.mu.Unlock()
+    s = s.clone()
+    fs.loadInstances()[instanceKey{s.Instance.Duty, s.Height}] = s
+    return fs.writeInstance("instance_persist", saveEntry(s))
+}
+
+// DeleteInstance drops the snapshot of (duty, height), if any.
+func (fs *FileStore) DeleteInstance(_ context.Context, duty string, height uint64) error {
+    fs.mu.Lock()
+    defer fs.mu.Unlock()
+    m := fs.loadInstances()
+    k := instanceKey{duty, height}
+    if _, ok := m[k]; !ok { return nil }
+    delete(m, k)
+    return fs.writeInstance("instance_delete", deleteEntry(k))
+}
+
+// LoadInstances returns the persisted instance snapshots.
+func (fs *FileStore) LoadInstances(_ context.Context) ([]LastState, error) {
+    fs.mu.Lock()
+    defer fs.mu.Unlock()
+    return sortedInstances(fs.loadInstances()), nil
+}
+
+var (
+    _ InstanceStore = (*MemoryStore)(nil)
+    _ InstanceStore = (*FileStore)(nil)
+)
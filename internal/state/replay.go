@@ -0,0 +1,259 @@
+package state
+
+import (
+    "context"
+    "encoding/binary"
+    "errors"
+    "hash/crc32"
+    "io"
+    "os"
+    "sort"
+    "time"
+
+    "github.com/zmlAEQ/Aequa-network/pkg/logger"
+    "github.com/zmlAEQ/Aequa-network/pkg/metrics"
+)
+
+// ReplayState is the persisted anti-replay window: recently seen message ids
+// with the height they were seen at, plus the decided-height watermark.
+type ReplayState struct {
+    High uint64
+    IDs  map[string]uint64
+}
+
+// ReplayStore is implemented by stores that can also persist the anti-replay
+// window. It is optional so minimal Store implementations keep working.
+type ReplayStore interface {
+    SaveReplay(ctx context.Context, r ReplayState) error
+    LoadReplay(ctx context.Context) (ReplayState, error)
+}
+
+// ReplayAppender is implemented by replay stores that can also record single
+// ids. An append costs O(1) where SaveReplay rewrites the whole window; the
+// appended ids are part of the window LoadReplay returns until the next
+// SaveReplay, which compacts them into it.
+type ReplayAppender interface {
+    AppendReplay(ctx context.Context, id string, height uint64) error
+}
+
+func copyReplay(r ReplayState) ReplayState {
+    out := ReplayState{High: r.High, IDs: make(map[string]uint64, len(r.IDs))}
+    for id, h := range r.IDs { out.IDs[id] = h }
+    return out
+}
+
+// SaveReplay stores a copy of the replay window.
+func (m *MemoryStore) SaveReplay(_ context.Context, r ReplayState) error {
+    m.mu.Lock()
+    m.replay = copyReplay(r)
+    m.haveReplay = true
+    m.mu.Unlock()
+    return nil
+}
+
+// AppendReplay adds id at height to the stored replay window.
+func (m *MemoryStore) AppendReplay(_ context.Context, id string, height uint64) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    if m.replay.IDs == nil { m.replay.IDs = map[string]uint64{} }
+    m.replay.IDs[id] = height
+    m.haveReplay = true
+    return nil
+}
+
+// LoadReplay returns the stored replay window, or ErrNotFound if none.
+func (m *MemoryStore) LoadReplay(_ context.Context) (ReplayState, error) {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+    if !m.haveReplay { return ReplayState{}, ErrNotFound }
+    return copyReplay(m.replay), nil
+}
+
+const (
+    replayMagic   uint32 = 0x53545250 // 'STRP' (State-RePlay)
+    replayVersion uint16 = 1
+
+    replayLogMagic   uint32 = 0x5354524c // 'STRL' (State-Replay-Log)
+    replayLogVersion uint16 = 1
+)
+
+var errReplayLogTorn = errors.New("replay log needs compaction")
+
+// replay payload = High u64 | count u32 | count × (height u64 | len u16 | id)
+// with entries sorted by id so equal windows encode identically.
+
+func encodeReplay(r ReplayState) ([]byte, error) {
+    ids := make([]string, 0, len(r.IDs))
+    for id := range r.IDs { ids = append(ids, id) }
+    sort.Strings(ids)
+    b := make([]byte, 12, 12+len(ids)*16)
+    binary.BigEndian.PutUint64(b[0:8], r.High)
+    binary.BigEndian.PutUint32(b[8:12], uint32(len(ids)))
+    for _, id := range ids {
+        if len(id) > 0xffff { return nil, errors.New("replay id too long") }
+        b = binary.BigEndian.AppendUint64(b, r.IDs[id])
+        b = binary.BigEndian.AppendUint16(b, uint16(len(id)))
+        b = append(b, id...)
+    }
+    return b, nil
+}
+
+func decodeReplay(b []byte) (ReplayState, error) {
+    if len(b) < 12 { return ReplayState{}, errors.New("short replay record") }
+    r := ReplayState{High: binary.BigEndian.Uint64(b[0:8])}
+    n := binary.BigEndian.Uint32(b[8:12])
+    b = b[12:]
+    if uint64(n)*10 > uint64(len(b)) { return ReplayState{}, errors.New("bad replay count") }
+    r.IDs = make(map[string]uint64, n)
+    for i := uint32(0); i < n; i++ {
+        if len(b) < 10 { return ReplayState{}, errors.New("truncated replay entry") }
+        h := binary.BigEndian.Uint64(b[0:8])
+        l := int(binary.BigEndian.Uint16(b[8:10]))
+        b = b[10:]
+        if len(b) < l { return ReplayState{}, errors.New("truncated replay id") }
+        r.IDs[string(b[:l])] = h
+        b = b[l:]
+    }
+    if len(b) != 0 { return ReplayState{}, errors.New("trailing replay bytes") }
+    return r, nil
+}
+
+func readReplay(path string) (ReplayState, error) {
+    _, payload, err := readRecord(path, replayMagic)
+    if err != nil { return ReplayState{}, err }
+    return decodeReplay(payload)
+}
+
+// replay log layout: [magic u32][version u16][reserved u16] then entries
+// framed like the instances log, each Height u64 | id. It holds the ids
+// appended since the last SaveReplay, which empties it.
+
+func replayLogHeader() []byte {
+    b := binary.BigEndian.AppendUint32(nil, replayLogMagic)
+    b = binary.BigEndian.AppendUint16(b, replayLogVersion)
+    return binary.BigEndian.AppendUint16(b, 0) // reserved
+}
+
+func replayEntry(id string, h uint64) []byte { return append(binary.BigEndian.AppendUint64(nil, h), id...) }
+
+// readReplayLog applies the entries of the replay log to ids. A torn tail is
+// cut off so later appends follow the valid prefix; it reports whether the
+// log can be appended to.
+func readReplayLog(path string, ids map[string]uint64) (int, bool, error) {
+    f, err := os.Open(path)
+    if err != nil { return 0, false, err }
+    defer f.Close()
+    var hdr [8]byte
+    if _, err := io.ReadFull(f, hdr[:]); err != nil { return 0, false, err }
+    if binary.BigEndian.Uint32(hdr[0:]) != replayLogMagic || binary.BigEndian.Uint16(hdr[4:]) != replayLogVersion { return 0, false, errors.New("bad replay log header") }
+    n, off := 0, int64(len(hdr))
+    var frame [8]byte
+    for {
+        if _, err := io.ReadFull(f, frame[:]); err == io.EOF {
+            return n, true, nil
+        } else if err != nil {
+            break
+        }
+        length := binary.BigEndian.Uint32(frame[0:])
+        if length < 8 || length > maxRecord { break }
+        e := make([]byte, length)
+        if _, err := io.ReadFull(f, e); err != nil { break }
+        if crc32.ChecksumIEEE(e) != binary.BigEndian.Uint32(frame[4:]) { break }
+        ids[string(e[8:])] = binary.BigEndian.Uint64(e[0:8])
+        n++
+        off += int64(len(frame)) + int64(length)
+    }
+    metrics.Inc("state_replay_corrupt_total", map[string]string{"reason": "torn_tail"})
+    logger.InfoJ("consensus_state", map[string]any{"op":"replay_log_recovery", "result":"truncate", "entries": n, "trace_id": ""})
+    return n, os.Truncate(path, off) == nil, nil
+}
+
+func (fs *FileStore) replayPath() string    { return fs.path + ".replay" }
+func (fs *FileStore) replayLogPath() string { return fs.path + ".replay.log" }
+
+// resetReplayLog replaces the replay log with an empty one.
+func (fs *FileStore) resetReplayLog() error {
+    if fs.replayLog != nil { _ = fs.replayLog.Close() }
+    fs.replayLog, fs.replayTorn = nil, true
+    path := fs.replayLogPath()
+    if err := replaceFileAtomic(path, replayLogHeader()); err != nil { return err }
+    _ = os.Remove(path + ".bak")
+    fs.replayTorn = false
+    return nil
+}
+
+// AppendReplay appends id at height to the replay log with one fsync'd write.
+// After a failed append it refuses until SaveReplay has compacted the log.
+func (fs *FileStore) AppendReplay(_ context.Context, id string, height uint64) error {
+    fs.mu.Lock()
+    defer fs.mu.Unlock()
+    if fs.replayTorn { return errReplayLogTorn }
+    var err error
+    if fs.replayLog == nil {
+        if _, serr := os.Stat(fs.replayLogPath()); os.IsNotExist(serr) { err = fs.resetReplayLog() }
+        if err == nil { fs.replayLog, err = os.OpenFile(fs.replayLogPath(), os.O_WRONLY|os.O_APPEND, 0o600) }
+    }
+    if err == nil { _, err = fs.replayLog.Write(frameEntry(replayEntry(id, height))) }
+    if err == nil { err = fs.replayLog.Sync() }
+    if err != nil {
+        // A partial entry may be on disk: refuse appends until compacted.
+        if fs.replayLog != nil { _ = fs.replayLog.Close() }
+        fs.replayLog, fs.replayTorn = nil, true
+        metrics.Inc("state_persist_errors_total", nil)
+        logger.ErrorJ("consensus_state", map[string]any{"op":"replay_append", "result":"error", "err": err.Error(), "trace_id": ""})
+        return err
+    }
+    return nil
+}
+
+// SaveReplay atomically persists the replay window next to the last state
+// and empties the replay log it now includes.
+func (fs *FileStore) SaveReplay(_ context.Context, r ReplayState) error {
+    start := time.Now()
+    fs.mu.Lock()
+    defer fs.mu.Unlock()
+    payload, err := encodeReplay(r)
+    if err == nil { err = writeRecordAtomic(fs.replayPath(), replayMagic, replayVersion, payload) }
+    if err == nil { err = fs.resetReplayLog() }
+    ms := float64(time.Since(start).Milliseconds())
+    if err != nil {
+        metrics.Inc("state_persist_errors_total", nil)
+        logger.ErrorJ("consensus_state", map[string]any{"op":"replay_persist", "result":"error", "err": err.Error(), "trace_id": ""})
+        return err
+    }
+    metrics.ObserveSummary("state_persist_ms", nil, ms)
+    logger.InfoJ("consensus_state", map[string]any{"op":"replay_persist", "result":"ok", "entries": len(r.IDs), "high": r.High, "latency_ms": ms, "trace_id": ""})
+    return nil
+}
+
+// LoadReplay loads the replay window, falling back to the backup copy, plus
+// the ids appended to the replay log since it was saved.
+func (fs *FileStore) LoadReplay(_ context.Context) (ReplayState, error) {
+    fs.mu.Lock()
+    defer fs.mu.Unlock()
+    r, result := ReplayState{IDs: map[string]uint64{}}, "miss"
+    for _, c := range []struct{ path, result string }{{fs.replayPath(), "ok"}, {fs.replayPath() + ".bak", "fallback"}} {
+        if rr, err := readReplay(c.path); err == nil {
+            r, result = rr, c.result
+            metrics.Inc("state_recovery_total", map[string]string{"result": c.result})
+            break
+        }
+    }
+    if fs.replayLog != nil { _ = fs.replayLog.Close(); fs.replayLog = nil }
+    n, ok, err := readReplayLog(fs.replayLogPath(), r.IDs)
+    // A log that cannot be appended to is compacted by the next SaveReplay.
+    fs.replayTorn = (err == nil && !ok) || (err != nil && !os.IsNotExist(err))
+    if result == "miss" && n == 0 {
+        logger.InfoJ("consensus_state", map[string]any{"op":"replay_recovery", "result":"miss", "trace_id": ""})
+        return ReplayState{}, ErrNotFound
+    }
+    logger.InfoJ("consensus_state", map[string]any{"op":"replay_recovery", "result": result, "entries": len(r.IDs), "logged": n, "high": r.High, "trace_id": ""})
+    return r, nil
+}
+
+var (
+    _ ReplayStore    = (*MemoryStore)(nil)
+    _ ReplayStore    = (*FileStore)(nil)
+    _ ReplayAppender = (*MemoryStore)(nil)
+    _ ReplayAppender = (*FileStore)(nil)
+)
@@ -0,0 +1,339 @@
+package state
+
+import (
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "hash/crc32"
+    "io"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/zmlAEQ/Aequa-network/pkg/logger"
+    "github.com/zmlAEQ/Aequa-network/pkg/metrics"
+)
+
+// WALSync selects when appended WAL records are fsynced.
+type WALSync int
+
+const (
+    // WALSyncAlways fsyncs every record before Append returns.
+    WALSyncAlways WALSync = iota
+    // WALSyncBatch fsyncs at most once per SyncEvery (and on Sync/Close).
+    WALSyncBatch
+    // WALSyncNever leaves flushing to the OS (and Sync/Close).
+    WALSyncNever
+)
+
+// ParseWALSync maps "always", "batch" or "never" to a WALSync.
+func ParseWALSync(s string) (WALSync, error) {
+    switch s {
+    case "", "always": return WALSyncAlways, nil
+    case "batch": return WALSyncBatch, nil
+    case "never": return WALSyncNever, nil
+    }
+    return 0, fmt.Errorf("unknown wal sync policy %q", s)
+}
+
+// WALOptions configures a WAL. Zero values select the defaults.
+type WALOptions struct {
+    SegmentSize int64         // rotate once a segment would exceed this (default 16 MiB)
+    Sync        WALSync
+    SyncEvery   time.Duration // batch interval for WALSyncBatch (default 100ms)
+}
+
+const (
+    walMagic   uint32 = 0x5357414C // 'SWAL'
+    walVersion uint16 = 1
+    walExt            = ".wal"
+    // segment header: [magic u32][version u16][reserved u16]
+    walHeaderLen = 8
+    // record frame: [length u32][crc32 u32] then length bytes of
+    // [height u64][data...]; the crc covers those length bytes.
+    walFrameLen  = 8
+    walMinRecord = 8
+
+    defaultSegmentSize = 16 << 20
+    defaultSyncEvery   = 100 * time.Millisecond
+)
+
+// WAL is an append-only log of opaque records tagged with a height, split
+// into numbered segment files in one directory. A torn tail left by a crash
+// is truncated on open; whole segments are dropped once every record in them
+// is below a pruning height.
+type WAL struct {
+    mu       sync.Mutex
+    dir      string
+    opts     WALOptions
+    segs     []walSegment // sorted by seq; the last one is open for appends
+    f        *os.File
+    size     int64
+    dirty    bool
+    lastSync time.Time
+}
+
+type walSegment struct {
+    seq   uint64
+    maxH  uint64
+    empty bool
+}
+
+// OpenWAL opens (or creates) the WAL in dir.
+func OpenWAL(dir string, opts WALOptions) (*WAL, error) {
+    if opts.SegmentSize <= 0 { opts.SegmentSize = defaultSegmentSize }
+    if opts.SyncEvery <= 0 { opts.SyncEvery = defaultSyncEvery }
+    if err := os.MkdirAll(dir, 0o700); err != nil { return nil, err }
+    w := &WAL{dir: dir, opts: opts, lastSync: time.Now()}
+    seqs, err := w.listSegments()
+    if err != nil { return nil, err }
+    for i, seq := range seqs {
+        seg := walSegment{seq: seq, empty: true}
+        end, err := scanSegment(w.segPath(seq), func(h uint64, _ []byte) error {
+            if seg.empty || h > seg.maxH { seg.maxH = h }
+            seg.empty = false
+            return nil
+        })
+        var ce *walCorruption
+        if err != nil && !errors.As(err, &ce) { return nil, err }
+        if err != nil && i == len(seqs)-1 {
+            // Torn tail of the active segment: keep the valid prefix.
+            metrics.Inc("state_wal_corrupt_total", map[string]string{"reason": "torn_tail"})
+            logger.InfoJ("consensus_state", map[string]any{"op":"wal_open", "result":"truncate", "segment": seq, "offset": end, "err": err.Error(), "trace_id": ""})
+            if end < walHeaderLen {
+                if err := os.Remove(w.segPath(seq)); err != nil { return nil, err }
+                continue
+            }
+            if err := os.Truncate(w.segPath(seq), end); err != nil { return nil, err }
+        }
+        w.segs = append(w.segs, seg)
+    }
+    if len(w.segs) == 0 {
+        var next uint64 = 1
+        if len(seqs) > 0 { next = seqs[len(seqs)-1] + 1 }
+        if err := w.create(next); err != nil { return nil, err }
+    } else if err := w.openActive(); err != nil {
+        return nil, err
+    }
+    metrics.SetGauge("state_wal_segments", nil, int64(len(w.segs)))
+    return w, nil
+}
+
+// Append adds one record for height h and applies the sync policy.
+func (w *WAL) Append(h uint64, data []byte) error {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    if w.f == nil { return errors.New("wal closed") }
+    rec := make([]byte, walFrameLen+walMinRecord, walFrameLen+walMinRecord+len(data))
+    binary.BigEndian.PutUint64(rec[walFrameLen:], h)
+    rec = append(rec, data...)
+    if int64(len(rec)-walFrameLen) > maxRecord { return errors.New("wal record too large") }
+    binary.BigEndian.PutUint32(rec[0:], uint32(len(rec)-walFrameLen))
+    binary.BigEndian.PutUint32(rec[4:], crc32.ChecksumIEEE(rec[walFrameLen:]))
+    if w.size > walHeaderLen && w.size+int64(len(rec)) > w.opts.SegmentSize {
+        if err := w.rotate(); err != nil { return w.fail("rotate", err) }
+    }
+    if _, err := w.f.Write(rec); err != nil { return w.fail("append", err) }
+    w.size += int64(len(rec))
+    w.dirty = true
+    seg := &w.segs[len(w.segs)-1]
+    if seg.empty || h > seg.maxH { seg.maxH = h }
+    seg.empty = false
+    metrics.Inc("state_wal_appends_total", nil)
+    switch w.opts.Sync {
+    case WALSyncAlways:
+        if err := w.syncLocked(); err != nil { return w.fail("sync", err) }
+    case WALSyncBatch:
+        if time.Since(w.lastSync) >= w.opts.SyncEvery {
+            if err := w.syncLocked(); err != nil { return w.fail("sync", err) }
+        }
+    }
+    return nil
+}
+
+// Replay calls fn for every record, oldest first. A corrupt record ends its
+// segment (counted in state_wal_corrupt_total); an error from fn stops replay.
+// Records appended meanwhile may or may not be visited; fn may itself use w.
+func (w *WAL) Replay(fn func(h uint64, data []byte) error) error {
+    w.mu.Lock()
+    segs := append([]walSegment(nil), w.segs...)
+    w.mu.Unlock()
+    n := 0
+    for _, seg := range segs {
+        _, err := scanSegment(w.segPath(seg.seq), func(h uint64, data []byte) error {
+            n++
+            return fn(h, data)
+        })
+        if os.IsNotExist(err) { continue } // pruned meanwhile
+        var ce *walCorruption
+        if errors.As(err, &ce) {
+            metrics.Inc("state_wal_corrupt_total", map[string]string{"reason": ce.reason})
+            logger.ErrorJ("consensus_state", map[string]any{"op":"wal_replay", "result":"corrupt", "segment": seg.seq, "reason": ce.reason, "trace_id": ""})
+            continue
+        }
+        if err != nil { return err }
+    }
+    logger.InfoJ("consensus_state", map[string]any{"op":"wal_replay", "result":"ok", "records": n, "segments": len(segs), "trace_id": ""})
+    return nil
+}
+
+// PruneBelow removes sealed segments whose records are all below height h.
+// The active segment is never removed.
+func (w *WAL) PruneBelow(h uint64) error {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    keep := w.segs[:0]
+    var err error
+    for i, seg := range w.segs {
+        if i < len(w.segs)-1 && err == nil && (seg.empty || seg.maxH < h) {
+            if err = os.Remove(w.segPath(seg.seq)); err == nil || os.IsNotExist(err) {
+                err = nil
+                metrics.Inc("state_wal_pruned_total", nil)
+                continue
+            }
+        }
+        keep = append(keep, seg)
+    }
+    w.segs = keep
+    metrics.SetGauge("state_wal_segments", nil, int64(len(w.segs)))
+    if err != nil { return w.fail("prune", err) }
+    return nil
+}
+
+// Sync flushes appended records to stable storage.
+func (w *WAL) Sync() error {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    if w.f == nil || !w.dirty { return nil }
+    if err := w.syncLocked(); err != nil { return w.fail("sync", err) }
+    return nil
+}
+
+// Flush fsyncs records left pending under WALSyncBatch once SyncEvery has
+// elapsed, so an idle log does not keep an unsynced tail. Callers tick it.
+func (w *WAL) Flush() error {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    if w.f == nil || !w.dirty || w.opts.Sync != WALSyncBatch || time.Since(w.lastSync) < w.opts.SyncEvery { return nil }
+    if err := w.syncLocked(); err != nil { return w.fail("sync", err) }
+    return nil
+}
+
+// Close syncs and closes the active segment.
+func (w *WAL) Close() error {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    if w.f == nil { return nil }
+    var err error
+    if w.dirty { err = w.syncLocked() }
+    if cerr := w.f.Close(); err == nil { err = cerr }
+    w.f = nil
+    return err
+}
+
+// Segments returns the number of segment files.
+func (w *WAL) Segments() int {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    return len(w.segs)
+}
+
+func (w *WAL) syncLocked() error {
+    if err := w.f.Sync(); err != nil { return err }
+    w.dirty = false
+    w.lastSync = time.Now()
+    metrics.Inc("state_wal_fsync_total", nil)
+    return nil
+}
+
+func (w *WAL) rotate() error {
+    if err := w.syncLocked(); err != nil { return err }
+    if err := w.f.Close(); err != nil { return err }
+    w.f = nil
+    return w.create(w.segs[len(w.segs)-1].seq + 1)
+}
+
+// create starts segment seq with a synced header and makes it active.
+func (w *WAL) create(seq uint64) error {
+    f, err := os.OpenFile(w.segPath(seq), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+    if err != nil { return err }
+    var hdr [walHeaderLen]byte
+    binary.BigEndian.PutUint32(hdr[0:], walMagic)
+    binary.BigEndian.PutUint16(hdr[4:], walVersion)
+    if _, err = f.Write(hdr[:]); err != nil { _ = f.Close(); return err }
+    if err = f.Sync(); err != nil { _ = f.Close(); return err }
+    if d, err2 := os.Open(w.dir); err2 == nil { _ = d.Sync(); _ = d.Close() }
+    w.f, w.size = f, walHeaderLen
+    w.segs = append(w.segs, walSegment{seq: seq, empty: true})
+    metrics.SetGauge("state_wal_segments", nil, int64(len(w.segs)))
+    return nil
+}
+
+func (w *WAL) openActive() error {
+    f, err := os.OpenFile(w.segPath(w.segs[len(w.segs)-1].seq), os.O_WRONLY|os.O_APPEND, 0o600)
+    if err != nil { return err }
+    st, err := f.Stat()
+    if err != nil { _ = f.Close(); return err }
+    w.f, w.size = f, st.Size()
+    return nil
+}
+
+func (w *WAL) segPath(seq uint64) string { return filepath.Join(w.dir, fmt.Sprintf("%020d%s", seq, walExt)) }
+
+func (w *WAL) listSegments() ([]uint64, error) {
+    ents, err := os.ReadDir(w.dir)
+    if err != nil { return nil, err }
+    var seqs []uint64
+    for _, e := range ents {
+        name := e.Name()
+        if e.IsDir() || !strings.HasSuffix(name, walExt) { continue }
+        var seq uint64
+        if _, err := fmt.Sscanf(strings.TrimSuffix(name, walExt), "%d", &seq); err != nil { continue }
+        seqs = append(seqs, seq)
+    }
+    sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+    return seqs, nil
+}
+
+func (w *WAL) fail(op string, err error) error {
+    metrics.Inc("state_persist_errors_total", nil)
+    logger.ErrorJ("consensus_state", map[string]any{"op":"wal_" + op, "result":"error", "err": err.Error(), "trace_id": ""})
+    return err
+}
+
+// walCorruption reports an unreadable record and where the valid prefix ends.
+type walCorruption struct{ reason string }
+
+func (e *walCorruption) Error() string { return "wal corrupt: " + e.reason }
+
+// scanSegment calls fn for every valid record in the segment at path and
+// returns the offset where the valid prefix ends. Errors from fn are
+// returned as is; unreadable data yields a *walCorruption.
+func scanSegment(path string, fn func(h uint64, data []byte) error) (int64, error) {
+    f, err := os.Open(path)
+    if err != nil { return 0, err }
+    defer f.Close()
+    var hdr [walHeaderLen]byte
+    if _, err := io.ReadFull(f, hdr[:]); err != nil { return 0, &walCorruption{"header"} }
+    if binary.BigEndian.Uint32(hdr[0:]) != walMagic { return 0, &walCorruption{"magic"} }
+    if binary.BigEndian.Uint16(hdr[4:]) != walVersion { return 0, &walCorruption{"version"} }
+    off := int64(walHeaderLen)
+    var frame [walFrameLen]byte
+    for {
+        if _, err := io.ReadFull(f, frame[:]); err == io.EOF {
+            return off, nil
+        } else if err != nil {
+            return off, &walCorruption{"truncated"}
+        }
+        length := binary.BigEndian.Uint32(frame[0:])
+        if length < walMinRecord || length > maxRecord { return off, &walCorruption{"length"} }
+        rec := make([]byte, length)
+        if _, err := io.ReadFull(f, rec); err != nil { return off, &walCorruption{"truncated"} }
+        if crc32.ChecksumIEEE(rec) != binary.BigEndian.Uint32(frame[4:]) { return off, &walCorruption{"crc"} }
+        if err := fn(binary.BigEndian.Uint64(rec), rec[walMinRecord:]); err != nil { return off, err }
+        off += walFrameLen + int64(length)
+    }
+}
@@ -8,8 +8,19 @@ type Kind string
 
 const (
 	KindDuty Kind = "duty"
+	// KindConsensus events carry an inbound consensus message in Body.
+	KindConsensus Kind = "consensus"
 )
 
+// Duty is a validator duty submitted through /v1/duty; KindDuty events carry
+// it in Body. Payload is the raw JSON value consensus decides on.
+type Duty struct {
+	Type    string
+	Height  uint64
+	Round   uint64
+	Payload []byte
+}
+
 type Event struct {
 	Kind    Kind
 	Height  uint64
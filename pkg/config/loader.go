@@ -5,7 +5,14 @@ import (
     "os"
 )
 
-type Operator struct { Index int `json:"index"`; PeerID string `json:"peer_id"` }
+type Operator struct {
+    Index  int    `json:"index"`
+    PeerID string `json:"peer_id"`
+    // Weight biases leader selection (optional; 0 means unweighted).
+    Weight int    `json:"weight,omitempty"`
+    // PubKey is the hex-encoded ed25519 key signing consensus messages.
+    PubKey string `json:"pubkey,omitempty"`
+}
 
 type ClusterLock struct {
     Name      string     `json:"name"`
@@ -2,6 +2,7 @@ package logger
 
 import (
     "encoding/json"
+    "io"
     "log"
     "os"
     "time"
@@ -9,6 +10,9 @@ import (
 
 var std = log.New(os.Stdout, "", 0)
 
+// SetOutput redirects all log lines to w (stdout by default).
+func SetOutput(w io.Writer) { std.SetOutput(w) }
+
 func Info(msg string)  { std.Printf("%s INFO %s\n", time.Now().Format(time.RFC3339Nano), msg) }
 func Warn(msg string)  { std.Printf("%s WARN %s\n", time.Now().Format(time.RFC3339Nano), msg) }
 func Error(msg string) { std.Printf("%s ERRO %s\n", time.Now().Format(time.RFC3339Nano), msg) }
@@ -0,0 +1,207 @@
+// Package byzantine provides faulty node behaviours for the in-process
+// simulator (test/sim). Each Strategy builds a sim.Node that takes the place
+// of an honest member; most wrap sim.Honest and corrupt only what they send,
+// so the rest of the protocol keeps running around the fault.
+//
+// With n = 3f+1 members and at most f of them Byzantine, Check asserts what
+// the honest members must still guarantee: they never decide different
+// values, and each of them decides every height.
+package byzantine
+
+import (
+    "crypto/ed25519"
+    "fmt"
+    "time"
+
+    qbft "github.com/zmlAEQ/Aequa-network/internal/consensus/qbft"
+    "github.com/zmlAEQ/Aequa-network/test/sim"
+)
+
+// Strategy is a named Byzantine behaviour.
+type Strategy struct {
+    Name string
+    New  sim.NodeFactory
+}
+
+// All returns every strategy in this package with default settings.
+func All() []Strategy {
+    return []Strategy{
+        {Name: "equivocating_leader", New: EquivocatingLeader()},
+        {Name: "silent", New: Silent()},
+        {Name: "withhold_votes", New: WithholdVotes()},
+        {Name: "stale_round_spammer", New: StaleRoundSpammer(100 * time.Millisecond)},
+        {Name: "forged_signatures", New: ForgedSignatures()},
+    }
+}
+
+// Run starts heights 1..heights of duty one after another, giving each up to
+// limit of virtual time, and returns Check over the result.
+func Run(s *sim.Sim, duty string, heights uint64, limit time.Duration) error {
+    for h := uint64(1); h <= heights; h++ {
+        s.StartDuty(duty, h, nil)
+        s.RunUntil(func() bool { return s.AllDecided(duty, h) }, limit)
+    }
+    return Check(s, duty, heights)
+}
+
+// Check asserts agreement among the honest nodes (sim.CheckAgreement) and
+// that every honest node decided heights 1..heights of duty.
+func Check(s *sim.Sim, duty string, heights uint64) error {
+    if err := s.CheckAgreement(); err != nil { return err }
+    for h := uint64(1); h <= heights; h++ {
+        if !s.AllDecided(duty, h) {
+            return fmt.Errorf("%s/%d: only %d of %d honest nodes decided", duty, h, s.DecidedCount(duty, h), len(s.Decisions()))
+        }
+    }
+    return nil
+}
+
+// silent never sends anything: a crashed or partitioned member.
+type silent struct{}
+
+func (silent) Propose(qbft.InstanceKey, []byte) {}
+func (silent) Deliver(qbft.Message)             {}
+func (silent) Tick(time.Time)                   {}
+
+// Silent returns a node that ignores every input and sends nothing.
+func Silent() sim.NodeFactory { return func(*sim.Env) sim.Node { return silent{} } }
+
+// WithholdVotes returns an otherwise honest node that never sends messages
+// of the given types (default: prepare and commit), so it proposes and
+// round-changes but never helps a quorum form.
+func WithholdVotes(types ...qbft.Type) sim.NodeFactory {
+    if len(types) == 0 { types = []qbft.Type{qbft.MsgPrepare, qbft.MsgCommit} }
+    return func(env *sim.Env) sim.Node {
+        h := sim.NewHonest(env)
+        h.OnSend = func(msg qbft.Message) bool {
+            for _, t := range types { if msg.Type == t { return false } }
+            return true
+        }
+        return h
+    }
+}
+
+// EquivocatingLeader returns an otherwise honest node that, whenever it
+// leads a round, sends its proposal to half of the cluster and a conflicting
+// proposal to the other half. It withholds its own votes for instances it
+// equivocated in, so neither proposal can gather a quorum with its help.
+func EquivocatingLeader() sim.NodeFactory {
+    return func(env *sim.Env) sim.Node {
+        h := sim.NewHonest(env)
+        split := map[qbft.InstanceKey]bool{}
+        h.OnSend = func(msg qbft.Message) bool {
+            key := qbft.InstanceKey{Duty: msg.Duty, Height: msg.Height}
+            switch msg.Type {
+            case qbft.MsgPreprepare:
+                split[key] = true
+                alt := msg
+                alt.Payload = []byte(fmt.Sprintf(`{"equivocation":%q,"by":%q}`, msg.ProposalID, env.ID))
+                alt.ProposalID, _ = qbft.ProposalIDOf(nil, alt.Payload)
+                alt.ID = qbft.ContentID(alt)
+                alt = env.Signer.Sign(alt)
+                for i, to := range env.IDs {
+                    if i%2 == 0 { env.Send(to, msg) } else { env.Send(to, alt) }
+                }
+                return false
+            case qbft.MsgPrepare, qbft.MsgCommit:
+                return !split[key]
+            }
+            return true
+        }
+        return h
+    }
+}
+
+// staleSpammer is an honest node that also floods validly signed votes and
+// round changes for rounds and heights the cluster has already left.
+type staleSpammer struct {
+    *sim.Honest
+    env   *sim.Env
+    every time.Duration
+    last  time.Time
+    cur   qbft.Message // highest (height, round) seen per the last duty
+}
+
+// StaleRoundSpammer returns an otherwise honest node that, every interval,
+// broadcasts freshly signed prepares, commits and round changes for random
+// values at every round below the highest one it has seen, and at the
+// previous height.
+func StaleRoundSpammer(every time.Duration) sim.NodeFactory {
+    return func(env *sim.Env) sim.Node { return &staleSpammer{Honest: sim.NewHonest(env), env: env, every: every} }
+}
+
+func (n *staleSpammer) Deliver(msg qbft.Message) {
+    if msg.Height > n.cur.Height || (msg.Height == n.cur.Height && msg.Round > n.cur.Round) { n.cur = msg }
+    n.Honest.Deliver(msg)
+}
+
+func (n *staleSpammer) Tick(now time.Time) {
+    n.Honest.Tick(now)
+    if n.cur.Duty == "" || now.Sub(n.last) < n.every { return }
+    n.last = now
+    for r := uint64(0); r < n.cur.Round; r++ { n.spam(n.cur.Height, r) }
+    if n.cur.Height > 1 { n.spam(n.cur.Height-1, 1) }
+}
+
+func (n *staleSpammer) spam(height, round uint64) {
+    v := make([]byte, 16)
+    n.env.Rand.Read(v)
+    pid, _ := qbft.ProposalIDOf(nil, v)
+    for _, t := range []qbft.Type{qbft.MsgPrepare, qbft.MsgCommit, qbft.MsgRoundChange} {
+        m := qbft.Message{From: n.env.ID, Duty: n.cur.Duty, Height: height, Round: round, Type: t, ProposalID: pid}
+        m.ID = qbft.ContentID(m)
+        n.env.Broadcast(n.env.Signer.Sign(m))
+    }
+}
+
+// forger is an honest node that also impersonates the other members.
+type forger struct {
+    *sim.Honest
+    env  *sim.Env
+    done map[qbft.InstanceKey]bool
+}
+
+// ForgedSignatures returns an otherwise honest node that, on each proposal
+// it sees, tries to forge a decision for a value of its own: it broadcasts a
+// preprepare, prepares and commits claiming to come from the other members
+// (signed with its own key), plus tampered and badly signed copies of the
+// genuine proposal.
+func ForgedSignatures() sim.NodeFactory {
+    return func(env *sim.Env) sim.Node { return &forger{Honest: sim.NewHonest(env), env: env, done: map[qbft.InstanceKey]bool{}} }
+}
+
+func (n *forger) Deliver(msg qbft.Message) {
+    n.Honest.Deliver(msg)
+    key := qbft.InstanceKey{Duty: msg.Duty, Height: msg.Height}
+    if msg.Type != qbft.MsgPreprepare || msg.From == n.env.ID || n.done[key] { return }
+    n.done[key] = true
+
+    value := []byte(fmt.Sprintf(`{"forged_by":%q}`, n.env.ID))
+    pid, _ := qbft.ProposalIDOf(nil, value)
+    pp := msg
+    pp.Payload, pp.ProposalID = value, pid
+    pp.ID = qbft.ContentID(pp)
+    n.env.Broadcast(n.forge(pp)) // a proposal in the leader's name
+    tampered := pp
+    tampered.Sig = msg.Sig
+    n.env.Broadcast(tampered) // the leader's genuine signature over other content
+    bad := msg
+    bad.Sig = append([]byte(nil), msg.Sig...)
+    if len(bad.Sig) > 0 { bad.Sig[0] ^= 0xff }
+    n.env.Broadcast(bad) // the genuine proposal, id and all, with a broken signature
+    for _, id := range n.env.IDs {
+        if id == n.env.ID { continue }
+        for _, t := range []qbft.Type{qbft.MsgPrepare, qbft.MsgCommit} {
+            m := qbft.Message{From: id, Duty: msg.Duty, Height: msg.Height, Round: msg.Round, Type: t, ProposalID: pid}
+            m.ID = qbft.ContentID(m)
+            n.env.Broadcast(n.forge(m))
+        }
+    }
+}
+
+// forge signs msg with the forger's key while keeping msg.From.
+func (n *forger) forge(msg qbft.Message) qbft.Message {
+    d := msg.Digest()
+    msg.Sig = ed25519.Sign(n.env.Key, d[:])
+    return msg
+}
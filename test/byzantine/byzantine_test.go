@@ -0,0 +1,46 @@
+package byzantine
+
+import (
+    "testing"
+    "time"
+
+    "github.com/zmlAEQ/Aequa-network/test/sim"
+)
+
+var net = sim.NetConfig{MinDelay: 5 * time.Millisecond, MaxDelay: 60 * time.Millisecond, ReorderRate: 0.2, ReorderDelay: 100 * time.Millisecond}
+
+// One faulty member out of four, at every position (so it leads some
+// rounds and follows in others), across several seeds.
+func TestStrategies_HonestNodesAgreeAndDecide(t *testing.T) {
+    for _, st := range All() {
+        st := st
+        t.Run(st.Name, func(t *testing.T) {
+            for idx := 0; idx < 4; idx++ {
+                for seed := int64(1); seed <= 3; seed++ {
+                    s := sim.New(sim.Config{Seed: seed, Net: net, Behaviours: map[int]sim.NodeFactory{idx: st.New}})
+                    if err := Run(s, "attester", 4, time.Minute); err != nil { t.Fatalf("node%d seed %d: %v", idx, seed, err) }
+                }
+            }
+        })
+    }
+}
+
+// Two faulty members out of four exceed f, so Check's guarantees no longer
+// apply in general. Members that never vote leave the two honest ones short
+// of a quorum of three: nothing may be decided, and liveness is lost.
+func TestStrategies_TooManyNonVotingFaultsDecideNothing(t *testing.T) {
+    for _, st := range []Strategy{{Name: "silent", New: Silent()}, {Name: "withhold_votes", New: WithholdVotes()}} {
+        s := sim.New(sim.Config{Seed: 5, Net: net, Behaviours: map[int]sim.NodeFactory{0: st.New, 1: st.New}})
+        if err := Run(s, "attester", 1, 20*time.Second); err == nil { t.Fatalf("%s: want lost liveness with two faulty members", st.Name) }
+        if n := s.DecidedCount("attester", 1); n != 0 { t.Fatalf("%s: %d honest nodes decided without a quorum", st.Name, n) }
+    }
+}
+
+func TestEquivocatingLeader_ForcesRoundChange(t *testing.T) {
+    s := sim.New(sim.Config{Seed: 1, Net: net, Behaviours: map[int]sim.NodeFactory{1: EquivocatingLeader()}})
+    // RoundRobin puts node1 in charge of round 0 at height 1.
+    if err := Run(s, "attester", 1, time.Minute); err != nil { t.Fatal(err) }
+    for id, ds := range s.Decisions() {
+        if ds[0].Round < 1 { t.Fatalf("%s decided in round %d despite the equivocation", id, ds[0].Round) }
+    }
+}
@@ -1,6 +1,85 @@
 package e2e
 
-import "testing"
+import (
+    "fmt"
+    "testing"
+    "time"
 
-func TestLeaderFailurePlaceholder(t *testing.T) { t.Skip("placeholder") }
+    qbft "github.com/zmlAEQ/Aequa-network/internal/consensus/qbft"
+)
 
+// TestLeaderFailure_RoundChangeDecides runs four in-process QBFT states where
+// the round-0 leader is down. The remaining three nodes (exactly a quorum)
+// must time out, agree on a round change and commit the value proposed by
+// the next leader, then stay decided while their round timers keep firing.
+func TestLeaderFailure_RoundChangeDecides(t *testing.T) {
+    ids := []string{"node0", "node1", "node2", "node3"}
+    down := "node0" // leader of height 4, round 0 under round-robin
+    const height = 4
+
+    now := time.Unix(0, 0)
+    var queue []qbft.Message
+    nodes := map[string]*qbft.State{}
+    decisions := map[string]int{}
+    for _, id := range ids {
+        if id == down { continue }
+        id := id
+        st := &qbft.State{
+            Self:       id,
+            Broadcast:  func(m qbft.Message) { queue = append(queue, m) },
+            LeaderFn:   qbft.RoundRobin(ids...),
+            Validators: qbft.NewValidators(ids, 0),
+            Timer:      qbft.RoundTimer{Base: 200 * time.Millisecond, Backoff: 2, Max: 2 * time.Second},
+            Now:        func() time.Time { return now },
+            OnDecided:  func(qbft.Decided) { decisions[id]++ },
+        }
+        st.SetInput(fmt.Sprintf("blk-%s", id), []byte(id))
+        nodes[id] = st
+    }
+    for _, st := range nodes {
+        if err := st.Start(height); err != nil { t.Fatalf("start: %v", err) }
+    }
+
+    committed := func() bool {
+        for _, st := range nodes { if st.Phase != "commit" { return false } }
+        return true
+    }
+    for step := 0; step < 1000 && !committed(); step++ {
+        if len(queue) == 0 {
+            now = now.Add(50 * time.Millisecond)
+            for _, st := range nodes { _ = st.Tick(now) }
+            continue
+        }
+        msg := queue[0]
+        queue = queue[1:]
+        for _, st := range nodes { _ = st.Process(msg) }
+    }
+    if !committed() {
+        for id, st := range nodes { t.Logf("%s: round=%d phase=%q leader=%q", id, st.Round, st.Phase, st.Leader) }
+        t.Fatalf("honest nodes did not commit after leader failure")
+    }
+    var want string
+    for id, st := range nodes {
+        got, _ := st.Proposal()
+        if st.Round == 0 { t.Fatalf("%s committed without a round change", id) }
+        if want == "" { want = got }
+        if got != want { t.Fatalf("%s committed %q, others %q", id, got, want) }
+    }
+    if want != "blk-node1" { t.Fatalf("want value of round-1 leader, got %q", want) }
+
+    // Keep delivering and ticking for several maximal round timeouts.
+    for end := now.Add(10 * 2 * time.Second); now.Before(end); {
+        if len(queue) > 0 {
+            msg := queue[0]
+            queue = queue[1:]
+            for _, st := range nodes { _ = st.Process(msg) }
+            continue
+        }
+        now = now.Add(50 * time.Millisecond)
+        for _, st := range nodes { _ = st.Tick(now) }
+    }
+    for id, st := range nodes {
+        if got, _ := st.Proposal(); st.Phase != "commit" || got != want { t.Fatalf("%s left its decision: phase %q proposal %q", id, st.Phase, got) }
+        if decisions[id] != 1 { t.Fatalf("%s decided %d times", id, decisions[id]) }
+    }
+}
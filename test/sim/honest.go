@@ -0,0 +1,96 @@
+package sim
+
+import (
+    "crypto/ed25519"
+    "fmt"
+    "time"
+
+    qbft "github.com/zmlAEQ/Aequa-network/internal/consensus/qbft"
+)
+
+// Honest is a correct node: a BasicVerifier in front of an InstanceManager,
+// configured the way consensus.Service configures them from a cluster lock.
+type Honest struct {
+    env      *Env
+    verifier *qbft.BasicVerifier
+    mgr      *qbft.InstanceManager
+    decided  []qbft.Decided
+    // OnSend, if set, filters this node's outgoing messages (return false to
+    // withhold one). Byzantine strategies use it to wrap honest behaviour.
+    OnSend func(msg qbft.Message) bool
+}
+
+// NewHonest builds a correct node for env.
+func NewHonest(env *Env) *Honest {
+    h := &Honest{env: env}
+    p := qbft.DefaultPolicy()
+    p.Leader = env.Leader
+    p.ContentIDs = true
+    p.Keys = map[string]ed25519.PublicKey{}
+    for _, id := range env.IDs {
+        if k, ok := env.Validators.Key(id); ok { p.Keys[id] = k }
+    }
+    h.verifier = qbft.NewBasicVerifierWithPolicy(p)
+    h.mgr = qbft.NewInstanceManager(0, 0, func(k qbft.InstanceKey) *qbft.State {
+        return &qbft.State{
+            Duty:       k.Duty,
+            Self:       env.ID,
+            Signer:     env.Signer,
+            Broadcast:  h.broadcast,
+            LeaderFn:   env.Leader,
+            Validators: env.Validators,
+            Timer:      env.Timer,
+            Now:        env.Now,
+            OnDecided:  func(d qbft.Decided) { h.decided = append(h.decided, d) },
+        }
+    })
+    h.mgr.SetClock(env.Now)
+    return h
+}
+
+func (h *Honest) broadcast(msg qbft.Message) {
+    if h.OnSend != nil && !h.OnSend(msg) { return }
+    h.env.Broadcast(msg)
+}
+
+// Propose implements Node.
+func (h *Honest) Propose(key qbft.InstanceKey, value []byte) { _ = h.mgr.Propose(key, value) }
+
+// Deliver implements Node: messages failing verification are dropped.
+func (h *Honest) Deliver(msg qbft.Message) {
+    if err := h.verifier.Verify(msg); err != nil { return }
+    _ = h.mgr.Process(msg)
+}
+
+// Tick implements Node.
+func (h *Honest) Tick(now time.Time) { _ = h.mgr.Tick(now) }
+
+// Decisions implements Decider.
+func (h *Honest) Decisions() []qbft.Decided { return append([]qbft.Decided(nil), h.decided...) }
+
+// Instance exposes the node's instance for key, if live.
+func (h *Honest) Instance(key qbft.InstanceKey) (*qbft.State, bool) { return h.mgr.Instance(key) }
+
+// CheckAgreement verifies safety over the honest decisions so far: no two
+// nodes decided different values for the same instance, no node decided an
+// instance twice, and every commit certificate verifies.
+func (s *Sim) CheckAgreement() error {
+    all := s.Decisions()
+    first := map[qbft.InstanceKey]qbft.Decided{}
+    for _, id := range s.ids {
+        ds, ok := all[id]
+        if !ok { continue }
+        seen := map[qbft.InstanceKey]bool{}
+        for _, d := range ds {
+            key := qbft.InstanceKey{Duty: d.Duty, Height: d.Height}
+            if seen[key] { return fmt.Errorf("%s decided %s twice", id, key) }
+            seen[key] = true
+            if err := d.Certificate.Verify(s.vals); err != nil { return fmt.Errorf("%s: certificate for %s: %w", id, key, err) }
+            if f, ok := first[key]; ok && f.ProposalID != d.ProposalID {
+                return fmt.Errorf("disagreement on %s: %s vs %s", key, f.ProposalID, d.ProposalID)
+            }
+            first[key] = d
+        }
+    }
+    return nil
+}
@@ -0,0 +1,348 @@
+// Package sim runs a QBFT cluster in one process under a virtual clock and a
+// simulated network. Every source of nondeterminism (delays, drops, reorders,
+// keys) is derived from Config.Seed, so a run is reproducible from its seed:
+// the same seed yields the same delivery trace and the same decisions.
+//
+// Honest nodes run the same stack consensus.Service composes (BasicVerifier
+// in front of an InstanceManager of qbft.State), minus the goroutines and
+// wall-clock tickers, which the simulator replaces with its event queue.
+package sim
+
+import (
+    "container/heap"
+    "crypto/ed25519"
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "math/rand"
+    "time"
+
+    qbft "github.com/zmlAEQ/Aequa-network/internal/consensus/qbft"
+)
+
+// NetConfig controls the simulated network between distinct nodes. Messages a
+// node sends to itself are delivered immediately and never lost.
+type NetConfig struct {
+    MinDelay time.Duration
+    MaxDelay time.Duration
+    // DropRate is the probability that a message to another node is lost.
+    // Lost messages are not retransmitted and instances have no decision
+    // sync, so a node that misses the end of an instance may never decide;
+    // runs with loss can assert safety but not liveness.
+    DropRate float64
+    // ReorderRate is the probability that a message is held back by up to
+    // ReorderDelay on top of its delay, overtaking later messages.
+    ReorderRate  float64
+    ReorderDelay time.Duration
+}
+
+// Config describes a simulated cluster. Zero values select the defaults.
+type Config struct {
+    Nodes int   // cluster size (default 4)
+    Seed  int64
+    Net   NetConfig
+    // Timer drives round changes (default base 1s, backoff 2, max 16s).
+    Timer qbft.RoundTimer
+    // TickEvery is the resolution of node timers (default 50ms).
+    TickEvery time.Duration
+    // Behaviours replaces the honest node at an index, e.g. with a
+    // Byzantine strategy. Nodes built this way are excluded from checks.
+    Behaviours map[int]NodeFactory
+}
+
+// Node is a cluster member driven by the simulator. Calls never overlap.
+type Node interface {
+    // Propose hands the node its input for a duty instance.
+    Propose(key qbft.InstanceKey, value []byte)
+    // Deliver hands the node a message from the network.
+    Deliver(msg qbft.Message)
+    // Tick advances the node's timers to now.
+    Tick(now time.Time)
+}
+
+// Decider is implemented by nodes whose decisions the simulator checks.
+type Decider interface {
+    Decisions() []qbft.Decided
+}
+
+// NodeFactory builds the node running as env.ID.
+type NodeFactory func(env *Env) Node
+
+// Env is what a node gets from the simulator: its identity and keys, the
+// cluster configuration, a seeded random source and the network.
+type Env struct {
+    ID         string
+    Index      int
+    IDs        []string
+    Validators qbft.Validators
+    Leader     qbft.LeaderFunc
+    Signer     qbft.Signer
+    Key        ed25519.PrivateKey
+    Timer      qbft.RoundTimer
+    Rand       *rand.Rand
+    Now        func() time.Time
+    // Broadcast sends msg to every node, including the sender.
+    Broadcast func(msg qbft.Message)
+    // Send sends msg to a single node.
+    Send func(to string, msg qbft.Message)
+}
+
+// Delivery is one entry of the trace: a message handed to a node.
+type Delivery struct {
+    At     time.Duration // virtual time since the start of the run
+    From   string
+    To     string
+    Type   qbft.Type
+    Duty   string
+    Height uint64
+    Round  uint64
+    ID     string
+}
+
+func (d Delivery) String() string {
+    return fmt.Sprintf("%s %s->%s %s %s/%d/%d %s", d.At, d.From, d.To, d.Type, d.Duty, d.Height, d.Round, d.ID)
+}
+
+// Stats counts what happened to messages on the network.
+type Stats struct {
+    Sent        int
+    Delivered   int
+    Dropped     int
+    Partitioned int
+    Reordered   int
+}
+
+// Sim is a simulated cluster. It is not safe for concurrent use.
+type Sim struct {
+    cfg       Config
+    start     time.Time
+    now       time.Time
+    rng       *rand.Rand
+    queue     eventQueue
+    seq       uint64
+    ids       []string
+    index     map[string]int
+    vals      qbft.Validators
+    nodes     []Node
+    honest    []bool
+    partition map[string]int // group per node; nil when fully connected
+    trace     []Delivery
+    stats     Stats
+}
+
+// New builds the cluster described by cfg and schedules its timers.
+func New(cfg Config) *Sim {
+    if cfg.Nodes <= 0 { cfg.Nodes = 4 }
+    if !cfg.Timer.Enabled() { cfg.Timer = qbft.RoundTimer{Base: time.Second, Backoff: 2, Max: 16 * time.Second} }
+    if cfg.TickEvery <= 0 { cfg.TickEvery = 50 * time.Millisecond }
+    if cfg.Net.MaxDelay < cfg.Net.MinDelay { cfg.Net.MaxDelay = cfg.Net.MinDelay }
+    start := time.Unix(1_700_000_000, 0).UTC()
+    s := &Sim{cfg: cfg, start: start, now: start, rng: rand.New(rand.NewSource(cfg.Seed)), index: map[string]int{}}
+    for i := 0; i < cfg.Nodes; i++ {
+        id := fmt.Sprintf("node%d", i)
+        s.ids = append(s.ids, id)
+        s.index[id] = i
+    }
+    keys := make([]ed25519.PrivateKey, cfg.Nodes)
+    pubs := map[string]ed25519.PublicKey{}
+    for i, id := range s.ids {
+        seed := sha256.Sum256([]byte(fmt.Sprintf("sim/%d/%s", cfg.Seed, id)))
+        keys[i] = ed25519.NewKeyFromSeed(seed[:])
+        pubs[id] = keys[i].Public().(ed25519.PublicKey)
+    }
+    vals := qbft.NewValidators(s.ids, 0).WithKeys(pubs)
+    s.vals = vals
+    for i, id := range s.ids {
+        id := id
+        env := &Env{
+            ID:         id,
+            Index:      i,
+            IDs:        append([]string(nil), s.ids...),
+            Validators: vals,
+            Leader:     qbft.RoundRobin(s.ids...),
+            Signer:     qbft.NewKeySigner(id, keys[i]),
+            Key:        keys[i],
+            Timer:      cfg.Timer,
+            Rand:       rand.New(rand.NewSource(cfg.Seed*7919 + int64(i) + 1)),
+            Now:        s.Now,
+        }
+        env.Broadcast = func(msg qbft.Message) { for _, to := range s.ids { s.send(id, to, msg) } }
+        env.Send = func(to string, msg qbft.Message) { s.send(id, to, msg) }
+        f, byz := cfg.Behaviours[i]
+        if !byz { f = func(env *Env) Node { return NewHonest(env) } }
+        s.nodes = append(s.nodes, f(env))
+        s.honest = append(s.honest, !byz)
+    }
+    s.schedule(cfg.TickEvery, s.tick)
+    return s
+}
+
+// IDs returns the node ids in index order.
+func (s *Sim) IDs() []string { return append([]string(nil), s.ids...) }
+
+// Node returns the node at index i.
+func (s *Sim) Node(i int) Node { return s.nodes[i] }
+
+// Now returns the virtual time.
+func (s *Sim) Now() time.Time { return s.now }
+
+// Elapsed returns the virtual time since the start of the run.
+func (s *Sim) Elapsed() time.Duration { return s.now.Sub(s.start) }
+
+// At runs fn after d of virtual time.
+func (s *Sim) At(d time.Duration, fn func()) { s.schedule(d, fn) }
+
+// StartDuty hands every node its input for (duty, height) now. value returns
+// the input per node (nil: a value derived from duty and height).
+func (s *Sim) StartDuty(duty string, height uint64, value func(id string) []byte) {
+    key := qbft.InstanceKey{Duty: duty, Height: height}
+    for i, id := range s.ids {
+        v := []byte(fmt.Sprintf(`{"duty":%q,"height":%d}`, duty, height))
+        if value != nil { v = value(id) }
+        n := s.nodes[i]
+        s.schedule(0, func() { n.Propose(key, v) })
+    }
+}
+
+// Partition splits the network into groups; nodes in different groups
+// cannot reach each other. Nodes not listed form one more group.
+func (s *Sim) Partition(groups ...[]string) {
+    s.partition = map[string]int{}
+    for g, ids := range groups {
+        for _, id := range ids { s.partition[id] = g + 1 }
+    }
+}
+
+// Heal reconnects all nodes.
+func (s *Sim) Heal() { s.partition = nil }
+
+// Run processes events for d of virtual time.
+func (s *Sim) Run(d time.Duration) {
+    until := s.now.Add(d)
+    for len(s.queue) > 0 && !s.queue[0].at.After(until) { s.step() }
+    s.now = until
+}
+
+// RunUntil processes events until cond holds (checked after every event) or
+// limit of virtual time has passed, and reports whether cond held.
+func (s *Sim) RunUntil(cond func() bool, limit time.Duration) bool {
+    until := s.now.Add(limit)
+    for !cond() {
+        if len(s.queue) == 0 || s.queue[0].at.After(until) {
+            s.now = until
+            return cond()
+        }
+        s.step()
+    }
+    return true
+}
+
+// Decisions returns the decisions of every honest node, by node id.
+func (s *Sim) Decisions() map[string][]qbft.Decided {
+    out := map[string][]qbft.Decided{}
+    for i, n := range s.nodes {
+        if d, ok := n.(Decider); ok && s.honest[i] { out[s.ids[i]] = d.Decisions() }
+    }
+    return out
+}
+
+// AllDecided reports whether every honest node decided (duty, height).
+func (s *Sim) AllDecided(duty string, height uint64) bool {
+    return s.DecidedCount(duty, height) == len(s.Decisions())
+}
+
+// QuorumDecided reports whether at least a quorum of nodes decided (duty, height).
+func (s *Sim) QuorumDecided(duty string, height uint64) bool {
+    return s.DecidedCount(duty, height) >= s.vals.Quorum()
+}
+
+// DecidedCount returns how many honest nodes decided (duty, height).
+func (s *Sim) DecidedCount(duty string, height uint64) int {
+    n := 0
+    for _, ds := range s.Decisions() {
+        for _, d := range ds {
+            if d.Duty == duty && d.Height == height { n++; break }
+        }
+    }
+    return n
+}
+
+// Trace returns every delivery so far, in order.
+func (s *Sim) Trace() []Delivery { return append([]Delivery(nil), s.trace...) }
+
+// Fingerprint hashes the trace; equal seeds and inputs yield equal fingerprints.
+func (s *Sim) Fingerprint() string {
+    h := sha256.New()
+    for _, d := range s.trace { fmt.Fprintln(h, d.String()) }
+    return hex.EncodeToString(h.Sum(nil))
+}
+
+// Stats returns the network counters.
+func (s *Sim) Stats() Stats { return s.stats }
+
+// send routes one message through the simulated network.
+func (s *Sim) send(from, to string, msg qbft.Message) {
+    s.stats.Sent++
+    delay := time.Duration(0)
+    if from != to {
+        if s.partition != nil && s.partition[from] != s.partition[to] {
+            s.stats.Partitioned++
+            return
+        }
+        if s.cfg.Net.DropRate > 0 && s.rng.Float64() < s.cfg.Net.DropRate {
+            s.stats.Dropped++
+            return
+        }
+        delay = s.cfg.Net.MinDelay
+        if span := s.cfg.Net.MaxDelay - s.cfg.Net.MinDelay; span > 0 { delay += time.Duration(s.rng.Int63n(int64(span) + 1)) }
+        if s.cfg.Net.ReorderRate > 0 && s.cfg.Net.ReorderDelay > 0 && s.rng.Float64() < s.cfg.Net.ReorderRate {
+            delay += time.Duration(s.rng.Int63n(int64(s.cfg.Net.ReorderDelay) + 1))
+            s.stats.Reordered++
+        }
+    }
+    n := s.nodes[s.index[to]]
+    s.schedule(delay, func() {
+        s.stats.Delivered++
+        s.trace = append(s.trace, Delivery{At: s.Elapsed(), From: from, To: to, Type: msg.Type, Duty: msg.Duty, Height: msg.Height, Round: msg.Round, ID: msg.ID})
+        n.Deliver(msg)
+    })
+}
+
+func (s *Sim) tick() {
+    for _, n := range s.nodes { n.Tick(s.now) }
+    s.schedule(s.cfg.TickEvery, s.tick)
+}
+
+func (s *Sim) schedule(d time.Duration, fn func()) {
+    s.seq++
+    heap.Push(&s.queue, &event{at: s.now.Add(d), seq: s.seq, fn: fn})
+}
+
+func (s *Sim) step() {
+    ev := heap.Pop(&s.queue).(*event)
+    s.now = ev.at
+    ev.fn()
+}
+
+// event is a scheduled callback; ties on time run in scheduling order.
+type event struct {
+    at  time.Time
+    seq uint64
+    fn  func()
+}
+
+type eventQueue []*event
+
+func (q eventQueue) Len() int { return len(q) }
+func (q eventQueue) Less(i, j int) bool {
+    if !q[i].at.Equal(q[j].at) { return q[i].at.Before(q[j].at) }
+    return q[i].seq < q[j].seq
+}
+func (q eventQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *eventQueue) Push(x any)   { *q = append(*q, x.(*event)) }
+func (q *eventQueue) Pop() any {
+    old := *q
+    ev := old[len(old)-1]
+    *q = old[:len(old)-1]
+    return ev
+}